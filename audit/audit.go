@@ -0,0 +1,147 @@
+// Package audit keeps an append-only record of administrative changes.
+package audit
+
+import (
+    "bufio"
+    "encoding/json"
+    "os"
+    "sync"
+    "time"
+
+    "your_project/config"
+)
+
+// Entry is one administrative change.
+type Entry struct {
+    Time time.Time `json:"time"`
+    // Who made the change: an admin user name or "token:<name>".
+    Actor string `json:"actor"`
+    // Address of the client the change came from.
+    Source  string               `json:"source"`
+    Action  string               `json:"action"`
+    Changes []config.FieldChange `json:"changes"`
+}
+
+// Log is an audit log stored as one JSON object per line. Entries are
+// only ever appended; nothing in this package rewrites the file.
+type Log struct {
+    mu sync.Mutex
+    f  *os.File
+    // lines locates every entry in the file, oldest first, so a page is
+    // read without decoding the rest of the log.
+    lines []span
+    size  int64
+}
+
+// span is the position of one line in the file, without its newline.
+type span struct {
+    off int64
+    n   int
+}
+
+// Open opens the log at path, creating it if needed.
+func Open(path string) (*Log, error) {
+    f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+    if err != nil {
+        return nil, err
+    }
+    if err := terminate(f); err != nil {
+        f.Close()
+        return nil, err
+    }
+    l := &Log{f: f}
+    if err := l.index(); err != nil {
+        f.Close()
+        return nil, err
+    }
+    return l, nil
+}
+
+// Append writes e to the log and syncs it to disk. A zero Time is set to
+// the current time.
+func (l *Log) Append(e Entry) error {
+    if e.Time.IsZero() {
+        e.Time = time.Now().UTC()
+    }
+    line, err := json.Marshal(e)
+    if err != nil {
+        return err
+    }
+
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if _, err := l.f.Write(append(line, '\n')); err != nil {
+        // Part of the line may have been written.
+        if fi, serr := l.f.Stat(); serr == nil {
+            l.size = fi.Size()
+        }
+        return err
+    }
+    l.lines = append(l.lines, span{off: l.size, n: len(line)})
+    l.size += int64(len(line)) + 1
+    return l.f.Sync()
+}
+
+// Page returns up to limit entries, newest first, after skipping the
+// offset newest ones, and the total number of entries.
+func (l *Log) Page(offset, limit int) ([]Entry, int, error) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    total := len(l.lines)
+    page := []Entry{}
+    for i := total - 1 - offset; i >= 0 && len(page) < limit; i-- {
+        buf := make([]byte, l.lines[i].n)
+        if _, err := l.f.ReadAt(buf, l.lines[i].off); err != nil {
+            return nil, 0, err
+        }
+        var e Entry
+        if err := json.Unmarshal(buf, &e); err != nil {
+            return nil, 0, err
+        }
+        page = append(page, e)
+    }
+    return page, total, nil
+}
+
+// index scans the file for the entries in it. A line that does not
+// decode, such as one cut short by a crash, is skipped.
+func (l *Log) index() error {
+    if _, err := l.f.Seek(0, 0); err != nil {
+        return err
+    }
+    var off int64
+    sc := bufio.NewScanner(l.f)
+    sc.Buffer(make([]byte, 64*1024), 16<<20)
+    for sc.Scan() {
+        n := len(sc.Bytes())
+        var e Entry
+        if err := json.Unmarshal(sc.Bytes(), &e); err == nil {
+            l.lines = append(l.lines, span{off: off, n: n})
+        }
+        off += int64(n) + 1
+    }
+    l.size = off
+    return sc.Err()
+}
+
+// terminate ends a line cut short by a crash, so that the next entry
+// starts on a line of its own.
+func terminate(f *os.File) error {
+    fi, err := f.Stat()
+    if err != nil || fi.Size() == 0 {
+        return err
+    }
+    last := make([]byte, 1)
+    if _, err := f.ReadAt(last, fi.Size()-1); err != nil {
+        return err
+    }
+    if last[0] != '\n' {
+        _, err = f.Write([]byte{'\n'})
+    }
+    return err
+}
+
+func (l *Log) Close() error {
+    return l.f.Close()
+}
@@ -0,0 +1,45 @@
+package audit
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestAppendAndPage(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "audit.log")
+    l, err := Open(path)
+    if err != nil {
+        t.Fatalf("Open failed: %v", err)
+    }
+    for _, action := range []string{"a", "b", "c"} {
+        if err := l.Append(Entry{Actor: "alice", Action: action}); err != nil {
+            t.Fatalf("Append failed: %v", err)
+        }
+    }
+    l.Close()
+
+    // A torn last line from a crash must not hide the other entries.
+    f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+    f.WriteString(`{"actor":"bo`)
+    f.Close()
+
+    l, err = Open(path)
+    if err != nil {
+        t.Fatalf("Reopen failed: %v", err)
+    }
+    defer l.Close()
+    if err := l.Append(Entry{Actor: "alice", Action: "d"}); err != nil {
+        t.Fatalf("Append failed: %v", err)
+    }
+    page, total, err := l.Page(2, 5)
+    if err != nil {
+        t.Fatalf("Page failed: %v", err)
+    }
+    if total != 4 || len(page) != 2 || page[0].Action != "b" || page[1].Action != "a" {
+        t.Errorf("Unexpected page %+v of %d", page, total)
+    }
+    if page[0].Time.IsZero() {
+        t.Error("Time was not set")
+    }
+}
@@ -10,17 +10,42 @@ import (
     "time"
 
     "your_project/crypto"
+    "your_project/mux"
+    "your_project/protocol"
+    "your_project/sip"
     "your_project/socks"
+    "your_project/transport"
 )
 
 type Client struct {
     serverAddr string
     localAddr  string
     password   string
+    method     string
     timeout    time.Duration
     udpAddr    *net.UDPAddr
     udpConn    *net.UDPConn
-    cipher     *crypto.Cipher
+    cipher     *crypto.AEADCipher
+
+    // SIP003 plugin; TCP goes through it when set.
+    plugin     string
+    pluginOpts string
+    tcpAddr    string
+
+    // Native simple-obfs, used instead of an obfs-local plugin.
+    obfs *transport.ObfsConfig
+    ws   *transport.WebSocketConfig
+
+    // Multiplexing; when set, requests share up to muxConns sessions.
+    mux      *mux.Config
+    muxConns int
+    muxMu    sync.Mutex
+    sessions []*mux.Session
+
+    // UDP over TCP; one association per local UDP client.
+    udpOverTCP bool
+    udpMu      sync.Mutex
+    udpAssocs  map[string]net.Conn
 }
 
 func NewClient(serverAddr, localAddr, password string, timeout time.Duration) *Client {
@@ -28,10 +53,65 @@ func NewClient(serverAddr, localAddr, password string, timeout time.Duration) *C
         serverAddr: serverAddr,
         localAddr:  localAddr,
         password:   password,
+        method:     "aes-256-gcm",
         timeout:    timeout,
     }
 }
 
+// SetMethod selects the server's cipher, "aes-256-gcm" by default.
+func (c *Client) SetMethod(method string) {
+    c.method = method
+}
+
+// SetPlugin routes TCP to the server through a SIP003 plugin, started
+// with Start. It takes the plugin and plugin_opts of an ss:// URI.
+// simple-obfs is handled natively without starting a process.
+func (c *Client) SetPlugin(plugin, opts string) error {
+    obfs, native, err := transport.ParseObfsOpts(plugin, opts)
+    if err != nil {
+        return err
+    }
+    if native {
+        c.SetObfs(obfs)
+        return nil
+    }
+    c.plugin = plugin
+    c.pluginOpts = opts
+    return nil
+}
+
+// SetObfs wraps connections to the server in a simple-obfs transport.
+func (c *Client) SetObfs(cfg transport.ObfsConfig) {
+    c.obfs = &cfg
+}
+
+// SetWebSocket carries connections to the server inside WebSockets, for
+// networks that only pass HTTP(S).
+func (c *Client) SetWebSocket(cfg transport.WebSocketConfig) {
+    c.ws = &cfg
+}
+
+// SetMux carries requests as streams over at most conns long-lived
+// connections to the server instead of opening one per request.
+func (c *Client) SetMux(cfg mux.Config, conns int) error {
+    if err := cfg.Validate(); err != nil {
+        return err
+    }
+    if conns <= 0 {
+        return errors.New("mux: connections must be positive")
+    }
+    c.mux = &cfg
+    c.muxConns = conns
+    return nil
+}
+
+// SetUDPOverTCP sends UDP to the server over TCP connections instead of
+// UDP datagrams, for networks that block UDP. The server's listener must
+// have udp_over_tcp enabled.
+func (c *Client) SetUDPOverTCP(enabled bool) {
+    c.udpOverTCP = enabled
+}
+
 func (c *Client) Start() error {
     tcpListener, err := net.Listen("tcp", c.localAddr)
     if err != nil {
@@ -39,6 +119,26 @@ func (c *Client) Start() error {
     }
     defer tcpListener.Close()
 
+    c.tcpAddr = c.serverAddr
+    if c.plugin != "" {
+        local, err := sip.FreeLocalAddr()
+        if err != nil {
+            return err
+        }
+        p, err := sip.StartPlugin(sip.PluginConfig{
+            Plugin:  c.plugin,
+            Options: c.pluginOpts,
+            Remote:  c.serverAddr,
+            Local:   local,
+        })
+        if err != nil {
+            return err
+        }
+        defer p.Stop()
+        c.tcpAddr = local
+        log.Printf("Client using plugin %s on %s", c.plugin, local)
+    }
+
     c.udpAddr, err = net.ResolveUDPAddr("udp", c.localAddr)
     if err != nil {
         return err
@@ -50,7 +150,8 @@ func (c *Client) Start() error {
     }
     defer c.udpConn.Close()
 
-    c.cipher, err = crypto.NewCipher([]byte(c.password))
+    // The key is derived the way the server derives it from its password.
+    c.cipher, err = protocol.NewCipher(c.password, c.method)
     if err != nil {
         return err
     }
@@ -70,6 +171,149 @@ func (c *Client) Start() error {
     }
 }
 
+// handleTCPConnection accepts a local SOCKS5 CONNECT and relays it to the
+// server, which expects the length-prefixed encrypted target address
+// followed by the stream in length-prefixed sealed chunks.
+func (c *Client) handleTCPConnection(conn net.Conn) {
+    defer conn.Close()
+
+    addr, err := protocol.HandleSocks5(conn)
+    if err != nil {
+        log.Printf("SOCKS5 handshake failed: %v", err)
+        return
+    }
+    if c.mux != nil {
+        c.handleMuxStream(conn, addr)
+        return
+    }
+
+    server, err := c.dialServer(addr)
+    if err != nil {
+        log.Printf("Failed to connect to server %s: %v", c.tcpAddr, err)
+        return
+    }
+    defer server.Close()
+
+    plain := func(b []byte) ([]byte, error) { return b, nil }
+    c.relay(conn, transport.Seal(server, c.cipher), plain, plain)
+}
+
+// dialServer connects to the server through the configured transports and
+// asks it for addr.
+func (c *Client) dialServer(addr string) (net.Conn, error) {
+    server, err := net.DialTimeout("tcp", c.tcpAddr, c.timeout)
+    if err != nil {
+        return nil, err
+    }
+    if c.obfs != nil {
+        server = transport.ObfsClient(server, *c.obfs)
+    }
+    if c.ws != nil {
+        server = transport.WebSocketClient(server, c.tcpAddr, *c.ws)
+    }
+
+    encryptedAddr, err := c.cipher.Encrypt([]byte(addr))
+    if err != nil {
+        server.Close()
+        return nil, err
+    }
+    header := make([]byte, 2, 2+len(encryptedAddr))
+    binary.BigEndian.PutUint16(header, uint16(len(encryptedAddr)))
+    if _, err := server.Write(append(header, encryptedAddr...)); err != nil {
+        server.Close()
+        return nil, err
+    }
+    return server, nil
+}
+
+// handleMuxStream relays a request over a stream of a shared session.
+// The session is encrypted as a whole, so the stream carries plain data.
+func (c *Client) handleMuxStream(conn net.Conn, addr string) {
+    stream, err := c.openStream()
+    if err != nil {
+        log.Printf("Failed to open stream to server %s: %v", c.tcpAddr, err)
+        return
+    }
+    defer stream.Close()
+
+    if err := protocol.WriteStreamAddress(stream, addr); err != nil {
+        log.Printf("Error sending address to server: %v", err)
+        return
+    }
+    plain := func(b []byte) ([]byte, error) { return b, nil }
+    c.relay(conn, stream, plain, plain)
+}
+
+// openStream opens a stream on the least busy session, dialing a new
+// session while fewer than muxConns are up and all of them are in use.
+func (c *Client) openStream() (*mux.Stream, error) {
+    c.muxMu.Lock()
+    defer c.muxMu.Unlock()
+
+    var best *mux.Session
+    live := c.sessions[:0]
+    for _, sess := range c.sessions {
+        if sess.IsClosed() {
+            continue
+        }
+        live = append(live, sess)
+        if n := sess.NumStreams(); n < c.mux.MaxStreams && (best == nil || n < best.NumStreams()) {
+            best = sess
+        }
+    }
+    c.sessions = live
+
+    if best == nil || (best.NumStreams() > 0 && len(c.sessions) < c.muxConns) {
+        if len(c.sessions) >= c.muxConns {
+            return nil, mux.ErrTooManyStreams
+        }
+        server, err := c.dialServer(protocol.MuxAddress)
+        if err != nil {
+            return nil, err
+        }
+        sess, err := mux.Client(transport.Seal(server, c.cipher), *c.mux)
+        if err != nil {
+            server.Close()
+            return nil, err
+        }
+        c.sessions = append(c.sessions, sess)
+        best = sess
+    }
+    return best.OpenStream()
+}
+
+// relay copies between the local connection and the server until either
+// side is done.
+func (c *Client) relay(conn, server net.Conn, up, down func([]byte) ([]byte, error)) {
+    done := make(chan struct{}, 2)
+    go c.pipe(server, conn, up, done)
+    go c.pipe(conn, server, down, done)
+    <-done
+    conn.Close()
+    server.Close()
+    <-done
+}
+
+func (c *Client) pipe(dst, src net.Conn, transform func([]byte) ([]byte, error), done chan<- struct{}) {
+    defer func() { done <- struct{}{} }()
+    buf := make([]byte, 32*1024)
+    for {
+        n, err := src.Read(buf)
+        if n > 0 {
+            data, terr := transform(buf[:n])
+            if terr != nil {
+                return
+            }
+            if _, werr := dst.Write(data); werr != nil {
+                return
+            }
+        }
+        if err != nil {
+            return
+        }
+    }
+}
+
 func (c *Client) handleUDP() {
     buf := make([]byte, 64*1024)
     for {
@@ -79,10 +323,78 @@ func (c *Client) handleUDP() {
             continue
         }
 
+        if c.udpOverTCP {
+            packet := make([]byte, n)
+            copy(packet, buf[:n])
+            go c.handleUDPOverTCP(remoteAddr, packet)
+            continue
+        }
         go c.handleUDPPacket(remoteAddr, buf[:n])
     }
 }
 
+// handleUDPOverTCP forwards a SOCKS5 UDP request as is over the
+// association of the local client that sent it.
+func (c *Client) handleUDPOverTCP(remoteAddr *net.UDPAddr, packet []byte) {
+    if len(packet) < 3 || packet[2] != 0 {
+        log.Printf("Fragmented or invalid UDP packets not supported")
+        return
+    }
+    conn, err := c.udpAssociation(remoteAddr)
+    if err != nil {
+        log.Printf("Failed to open UDP-over-TCP association to %s: %v", c.tcpAddr, err)
+        return
+    }
+    if err := protocol.WritePacket(conn, packet); err != nil {
+        log.Printf("Error sending UDP data to server: %v", err)
+        conn.Close()
+    }
+}
+
+func (c *Client) udpAssociation(remoteAddr *net.UDPAddr) (net.Conn, error) {
+    key := remoteAddr.String()
+    c.udpMu.Lock()
+    defer c.udpMu.Unlock()
+    if conn, ok := c.udpAssocs[key]; ok {
+        return conn, nil
+    }
+
+    server, err := c.dialServer(protocol.UDPOverTCPAddress)
+    if err != nil {
+        return nil, err
+    }
+    conn := transport.Seal(server, c.cipher)
+    if c.udpAssocs == nil {
+        c.udpAssocs = make(map[string]net.Conn)
+    }
+    c.udpAssocs[key] = conn
+    go c.readUDPOverTCP(key, remoteAddr, conn)
+    return conn, nil
+}
+
+// readUDPOverTCP hands replies, which arrive as complete SOCKS5 UDP
+// packets, back to the local client until the association goes idle.
+func (c *Client) readUDPOverTCP(key string, remoteAddr *net.UDPAddr, conn net.Conn) {
+    defer func() {
+        conn.Close()
+        c.udpMu.Lock()
+        if c.udpAssocs[key] == conn {
+            delete(c.udpAssocs, key)
+        }
+        c.udpMu.Unlock()
+    }()
+    for {
+        conn.SetReadDeadline(time.Now().Add(c.timeout))
+        packet, err := protocol.ReadPacket(conn)
+        if err != nil {
+            return
+        }
+        if _, err := c.udpConn.WriteToUDP(packet, remoteAddr); err != nil {
+            log.Printf("Error sending UDP response to client: %v", err)
+        }
+    }
+}
+
 func (c *Client) handleUDPPacket(remoteAddr *net.UDPAddr, data []byte) {
     if len(data) < 3 {
         log.Printf("Invalid UDP packet")
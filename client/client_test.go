@@ -0,0 +1,105 @@
+package client
+
+import (
+    "encoding/binary"
+    "io"
+    "net"
+    "testing"
+    "time"
+
+    "your_project/protocol"
+)
+
+func freeAddr(t *testing.T) string {
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer ln.Close()
+    return ln.Addr().String()
+}
+
+// TestClientServerTCP relays through a real client and shadowsocks server
+// without mux, with enough data that reads split and coalesce chunks.
+func TestClientServerTCP(t *testing.T) {
+    echo, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer echo.Close()
+    go func() {
+        for {
+            conn, err := echo.Accept()
+            if err != nil {
+                return
+            }
+            go func() {
+                io.Copy(conn, conn)
+                conn.Close()
+            }()
+        }
+    }()
+
+    ss, err := protocol.NewShadowsocks("s3cret", "aes-256-gcm", 5*time.Second)
+    if err != nil {
+        t.Fatal(err)
+    }
+    server, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer server.Close()
+    go func() {
+        for {
+            conn, err := server.Accept()
+            if err != nil {
+                return
+            }
+            go ss.HandleConnection(conn)
+        }
+    }()
+
+    local := freeAddr(t)
+    c := NewClient(server.Addr().String(), local, "s3cret", 5*time.Second)
+    go c.Start()
+
+    var conn net.Conn
+    for i := 0; i < 100; i++ {
+        if conn, err = net.Dial("tcp", local); err == nil {
+            break
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    if err != nil {
+        t.Fatalf("Client did not start: %v", err)
+    }
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+    target := echo.Addr().(*net.TCPAddr)
+    req := []byte{5, 1, 0, 5, 1, 0, 1}
+    req = append(req, target.IP.To4()...)
+    req = binary.BigEndian.AppendUint16(req, uint16(target.Port))
+    if _, err := conn.Write(req); err != nil {
+        t.Fatal(err)
+    }
+    reply := make([]byte, 2+10)
+    if _, err := io.ReadFull(conn, reply); err != nil || reply[3] != 0 {
+        t.Fatalf("SOCKS5 handshake failed: %v %v", reply, err)
+    }
+
+    data := make([]byte, 256<<10)
+    for i := range data {
+        data[i] = byte(i * 7)
+    }
+    go conn.Write(data)
+    got := make([]byte, len(data))
+    if _, err := io.ReadFull(conn, got); err != nil {
+        t.Fatalf("Reading echoed data failed: %v", err)
+    }
+    for i := range data {
+        if got[i] != data[i] {
+            t.Fatalf("Echoed data differs at byte %d", i)
+        }
+    }
+}
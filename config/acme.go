@@ -0,0 +1,56 @@
+package config
+
+// LetsEncryptURL is the ACME directory used when none is configured.
+const LetsEncryptURL = "https://acme-v02.api.letsencrypt.org/directory"
+
+// DefaultACMECacheDir is where issued certificates and the account key are
+// kept when no cache directory is configured.
+const DefaultACMECacheDir = "acme-cache"
+
+// ACMEConfig obtains a listener's certificates automatically from an ACME
+// CA instead of cert_file and key_file. Certificates are requested on the
+// first handshake for each domain and renewed before they expire. The
+// TLS-ALPN-01 challenge is answered on the listener itself; HTTPAddress
+// additionally serves HTTP-01 challenges, e.g. on ":80".
+type ACMEConfig struct {
+    Enabled      bool     `yaml:"enabled" json:"enabled"`
+    Domains      []string `yaml:"domains" json:"domains"`
+    Email        string   `yaml:"email" json:"email"`
+    DirectoryURL string   `yaml:"directory_url" json:"directory_url"`
+    // CAFile is trusted for talking to the directory, e.g. the root of a
+    // local Pebble test server.
+    CAFile      string `yaml:"ca_file" json:"ca_file"`
+    CacheDir    string `yaml:"cache_dir" json:"cache_dir"`
+    HTTPAddress string `yaml:"http_address" json:"http_address"`
+}
+
+func (ac *ACMEConfig) applyDefaults() {
+    if !ac.Enabled {
+        return
+    }
+    if ac.DirectoryURL == "" {
+        ac.DirectoryURL = LetsEncryptURL
+    }
+    if ac.CacheDir == "" {
+        ac.CacheDir = DefaultACMECacheDir
+    }
+}
+
+func (ac *ACMEConfig) validate(add func(format string, args ...interface{})) {
+    if !ac.Enabled {
+        return
+    }
+    if len(ac.Domains) == 0 {
+        add("tls.acme.domains: at least one domain is required")
+    }
+    for _, d := range ac.Domains {
+        if d == "" {
+            add("tls.acme.domains: must not contain empty names")
+        }
+    }
+    if ac.HTTPAddress != "" {
+        if err := validateAddress(ac.HTTPAddress); err != nil {
+            add("tls.acme.http_address: %v", err)
+        }
+    }
+}
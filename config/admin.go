@@ -0,0 +1,143 @@
+package config
+
+import (
+    "fmt"
+
+    "golang.org/x/crypto/bcrypt"
+)
+
+// Web admin roles. Read-only users can look at everything the admin API
+// shows; only admins can change it.
+const (
+    RoleAdmin    = "admin"
+    RoleReadOnly = "read-only"
+)
+
+// DefaultAuditLog is where administrative changes are recorded unless
+// admin.audit_log says otherwise.
+const DefaultAuditLog = "audit.log"
+
+// minTokenLength keeps API tokens from being guessable.
+const minTokenLength = 16
+
+// AdminConfig configures the web admin server. Without any accounts or
+// tokens the API only answers requests from loopback addresses.
+type AdminConfig struct {
+    // Address to listen on, e.g. "127.0.0.1:8080". The -web-admin-port
+    // flag is used when it is empty.
+    Address string    `yaml:"address" json:"address"`
+    TLS     TLSConfig `yaml:"tls" json:"tls"`
+
+    Accounts []AdminAccount `yaml:"accounts" json:"accounts"`
+    Tokens   []AdminToken   `yaml:"tokens" json:"tokens"`
+    // How long a login session lasts.
+    SessionTimeout Duration `yaml:"session_timeout" json:"session_timeout"`
+    // File every change made through the admin API is appended to.
+    AuditLog string `yaml:"audit_log" json:"audit_log"`
+}
+
+// AdminAccount logs in with a user name and password. PasswordHash is a
+// bcrypt hash, e.g. from `lunasocks config hash-password`.
+type AdminAccount struct {
+    Username     string `yaml:"username" json:"username"`
+    PasswordHash Secret `yaml:"password_hash" json:"password_hash"`
+    Role         string `yaml:"role" json:"role"`
+}
+
+// AdminToken authenticates API clients sending it as a bearer token.
+type AdminToken struct {
+    Name  string `yaml:"name" json:"name"`
+    Token Secret `yaml:"token" json:"token"`
+    Role  string `yaml:"role" json:"role"`
+}
+
+// AuthEnabled reports whether any credentials are configured.
+func (ac *AdminConfig) AuthEnabled() bool {
+    return len(ac.Accounts) > 0 || len(ac.Tokens) > 0
+}
+
+func (ac *AdminConfig) applyDefaults() {
+    if ac.AuditLog == "" {
+        ac.AuditLog = DefaultAuditLog
+    }
+    for i := range ac.Accounts {
+        if ac.Accounts[i].Role == "" {
+            ac.Accounts[i].Role = RoleReadOnly
+        }
+    }
+    for i := range ac.Tokens {
+        if ac.Tokens[i].Role == "" {
+            ac.Tokens[i].Role = RoleReadOnly
+        }
+    }
+}
+
+func (ac *AdminConfig) clone() AdminConfig {
+    cp := *ac
+    if len(ac.Accounts) > 0 {
+        cp.Accounts = append([]AdminAccount(nil), ac.Accounts...)
+    }
+    if len(ac.Tokens) > 0 {
+        cp.Tokens = append([]AdminToken(nil), ac.Tokens...)
+    }
+    cp.TLS = ac.TLS.clone()
+    return cp
+}
+
+func (ac *AdminConfig) validate() []string {
+    var problems []string
+    add := func(format string, args ...interface{}) {
+        problems = append(problems, "admin."+fmt.Sprintf(format, args...))
+    }
+
+    if ac.Address != "" {
+        if err := validateAddress(ac.Address); err != nil {
+            add("address: %v", err)
+        }
+    }
+    ac.TLS.validate(add)
+    if ac.TLS.ACME.Enabled {
+        add("tls.acme: not supported for the web admin")
+    }
+    if ac.SessionTimeout <= 0 {
+        add("session_timeout: must be positive")
+    }
+
+    users := make(map[string]bool)
+    for i, a := range ac.Accounts {
+        switch {
+        case a.Username == "":
+            add("accounts[%d].username: must not be empty", i)
+        case users[a.Username]:
+            add("accounts[%d].username: duplicate user %q", i, a.Username)
+        }
+        users[a.Username] = true
+        if _, err := bcrypt.Cost([]byte(a.PasswordHash.Value())); err != nil {
+            add("accounts[%d].password_hash: not a bcrypt hash", i)
+        }
+        if !validRole(a.Role) {
+            add("accounts[%d].role: must be %q or %q", i, RoleAdmin, RoleReadOnly)
+        }
+    }
+    names := make(map[string]bool)
+    for i, t := range ac.Tokens {
+        switch {
+        case t.Name == "":
+            add("tokens[%d].name: must not be empty", i)
+        case names[t.Name]:
+            add("tokens[%d].name: duplicate token %q", i, t.Name)
+        }
+        names[t.Name] = true
+        if len(t.Token.Value()) < minTokenLength {
+            add("tokens[%d].token: must be at least %d characters", i, minTokenLength)
+        }
+        if !validRole(t.Role) {
+            add("tokens[%d].role: must be %q or %q", i, RoleAdmin, RoleReadOnly)
+        }
+    }
+    return problems
+}
+
+func validRole(role string) bool {
+    return role == RoleAdmin || role == RoleReadOnly
+}
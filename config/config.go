@@ -1,30 +1,240 @@
 package config
 
 import (
+    "bytes"
+    "encoding/json"
+    "fmt"
     "gopkg.in/yaml.v2"
     "io/ioutil"
+    "time"
 )
 
+// CurrentVersion is the schema version written by this release. Older
+// files are migrated on load; see migrate.go.
+const CurrentVersion = 2
+
 type Config struct {
-    ServerAddress string `yaml:"server_address"`
-    Password      string `yaml:"password"`
-    UseTLS        bool   `yaml:"use_tls"`
-    TLSCertFile   string `yaml:"tls_cert_file"`
-    TLSKeyFile    string `yaml:"tls_key_file"`
-    // 추가 설정 필드
+    // 설정 파일 스키마 버전
+    Version int `yaml:"version" json:"version"`
+
+    // 단일 리스너 설정 (listeners 가 비어 있을 때 사용)
+    ServerAddress string `yaml:"server_address" json:"server_address"`
+    Password      Secret `yaml:"password" json:"password"`
+    UseTLS        bool   `yaml:"use_tls" json:"use_tls"`
+    TLSCertFile   string `yaml:"tls_cert_file" json:"tls_cert_file"`
+    TLSKeyFile    string `yaml:"tls_key_file" json:"tls_key_file"`
+
+    // 암호화 방식 기본값 (shadowsocks 리스너에서 method 를 생략한 경우)
+    Method string `yaml:"method" json:"method"`
+    // 유휴 연결 타임아웃
+    Timeout Duration `yaml:"timeout" json:"timeout"`
+
+    UDP UDPConfig `yaml:"udp" json:"udp"`
+    Log LogConfig `yaml:"log" json:"log"`
+
+    // 여러 개의 인바운드 리스너 (비어 있으면 위의 단일 서버 설정 사용)
+    Listeners []ListenerConfig `yaml:"listeners" json:"listeners"`
+
+    // 공유 링크(ss://, SIP008)에 사용할 공개 호스트 이름
+    PublicHost string `yaml:"public_host" json:"public_host"`
+
+    // 활성화할 플러그인 (나열된 순서대로 실행)
+    Plugins []PluginConfig `yaml:"plugins" json:"plugins"`
+
+    // 웹 관리 인터페이스 (바인드 주소, TLS, 계정과 API 토큰)
+    Admin AdminConfig `yaml:"admin" json:"admin"`
+
+    // 사용자, 트래픽 카운터, 차단 목록을 저장하는 파일 (시작 시 한 번 읽음)
+    StoreFile string `yaml:"store_file" json:"store_file"`
+    // 이전 버전의 사용자 파일. 있으면 시작 시 저장소로 가져오고 이름을 바꿈
+    UsersFile string `yaml:"users_file" json:"users_file"`
+}
+
+type UDPConfig struct {
+    Enabled bool `yaml:"enabled" json:"enabled"`
+    // NAT 매핑 유지 시간
+    Timeout Duration `yaml:"timeout" json:"timeout"`
 }
 
+type LogConfig struct {
+    Level string `yaml:"level" json:"level"`
+}
+
+// Default returns a configuration with every optional field set to its
+// default value.
+func Default() *Config {
+    return &Config{
+        Version: CurrentVersion,
+        Method:  "chacha20-poly1305",
+        Timeout: Duration(5 * time.Minute),
+        UDP: UDPConfig{
+            Timeout: Duration(time.Minute),
+        },
+        Log: LogConfig{
+            Level: "info",
+        },
+        Plugins: []PluginConfig{{Name: "logging"}},
+        Admin: AdminConfig{
+            SessionTimeout: Duration(12 * time.Hour),
+        },
+        StoreFile: "lunasocks.db",
+        UsersFile: "users.json",
+    }
+}
+
+// LoadConfig reads a configuration file, migrates it to the current schema,
+// rejects unknown fields and applies defaults and environment overrides.
+// The format is chosen by extension (see parse). The result is not
+// validated; call Validate before using it.
 func LoadConfig(filename string) (*Config, error) {
     data, err := ioutil.ReadFile(filename)
     if err != nil {
         return nil, err
     }
 
-    var cfg Config
-    err = yaml.Unmarshal(data, &cfg)
+    raw, err := parse(filename, data)
+    if err != nil {
+        return nil, err
+    }
+    return decode(raw)
+}
+
+func parseYAML(data []byte) (map[string]interface{}, error) {
+    var doc interface{}
+    if err := yaml.Unmarshal(data, &doc); err != nil {
+        return nil, err
+    }
+    return normalize(doc)
+}
+
+// decode turns a generic document into a Config. Every source format is
+// funnelled through here so migration and strictness behave the same.
+func decode(raw map[string]interface{}) (*Config, error) {
+    if err := migrate(raw); err != nil {
+        return nil, err
+    }
+
+    // Round-trip through JSON to get strict, typed decoding for free.
+    data, err := json.Marshal(raw)
+    if err != nil {
+        return nil, err
+    }
+    cfg := Default()
+    dec := json.NewDecoder(bytes.NewReader(data))
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(cfg); err != nil {
+        return nil, fmt.Errorf("config: %v", err)
+    }
+
+    if err := applyEnv(cfg); err != nil {
+        return nil, err
+    }
+    cfg.applyDefaults()
+    if err := cfg.ResolveSecrets(); err != nil {
+        return nil, err
+    }
+    return cfg, nil
+}
+
+// normalize converts the map[interface{}]interface{} values produced by
+// the YAML decoder into map[string]interface{}.
+func normalize(v interface{}) (map[string]interface{}, error) {
+    if v == nil {
+        return map[string]interface{}{}, nil
+    }
+    n, err := normalizeValue(v)
     if err != nil {
         return nil, err
     }
+    m, ok := n.(map[string]interface{})
+    if !ok {
+        return nil, fmt.Errorf("config: top level must be a mapping, got %T", v)
+    }
+    return m, nil
+}
+
+func normalizeValue(v interface{}) (interface{}, error) {
+    switch t := v.(type) {
+    case map[interface{}]interface{}:
+        m := make(map[string]interface{}, len(t))
+        for k, val := range t {
+            key, ok := k.(string)
+            if !ok {
+                return nil, fmt.Errorf("config: non-string key %v", k)
+            }
+            n, err := normalizeValue(val)
+            if err != nil {
+                return nil, err
+            }
+            m[key] = n
+        }
+        return m, nil
+    case map[string]interface{}:
+        for key, val := range t {
+            n, err := normalizeValue(val)
+            if err != nil {
+                return nil, err
+            }
+            t[key] = n
+        }
+        return t, nil
+    case []interface{}:
+        for i, val := range t {
+            n, err := normalizeValue(val)
+            if err != nil {
+                return nil, err
+            }
+            t[i] = n
+        }
+        return t, nil
+    default:
+        return v, nil
+    }
+}
+
+func (c *Config) applyDefaults() {
+    for i := range c.Listeners {
+        lc := &c.Listeners[i]
+        if lc.Protocol == "" {
+            lc.Protocol = ProtocolLunasocks
+        }
+        if lc.Protocol == ProtocolShadowsocks && lc.Method == "" {
+            lc.Method = c.Method
+        }
+        lc.TLS.ACME.applyDefaults()
+    }
+    c.Admin.applyDefaults()
+}
 
-    return &cfg, nil
+// Clone returns a deep copy of the configuration.
+func (c *Config) Clone() *Config {
+    if c == nil {
+        return nil
+    }
+    cp := *c
+    if c.Listeners != nil {
+        cp.Listeners = make([]ListenerConfig, len(c.Listeners))
+        for i, lc := range c.Listeners {
+            lc.Plugins = cloneStrings(lc.Plugins)
+            lc.TLS = lc.TLS.clone()
+            cp.Listeners[i] = lc
+        }
+    }
+    cp.Admin = c.Admin.clone()
+    if c.Plugins != nil {
+        cp.Plugins = make([]PluginConfig, len(c.Plugins))
+        for i, pc := range c.Plugins {
+            cp.Plugins[i] = pc.clone()
+        }
+    }
+    return &cp
+}
+
+// cloneStrings copies s, keeping nil and empty slices as they are so that
+// a clone compares equal to the original.
+func cloneStrings(s []string) []string {
+    if len(s) == 0 {
+        return s
+    }
+    return append([]string(nil), s...)
 }
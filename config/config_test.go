@@ -0,0 +1,351 @@
+package config
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+    t.Helper()
+    path := filepath.Join(t.TempDir(), name)
+    if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+        t.Fatalf("Failed to write config: %v", err)
+    }
+    return path
+}
+
+func TestLoadConfigMigratesVersion1(t *testing.T) {
+    path := writeConfig(t, "config.yaml", `
+server_address: "127.0.0.1:1080"
+password: secret
+enable_udp: true
+`)
+
+    cfg, err := LoadConfig(path)
+    if err != nil {
+        t.Fatalf("LoadConfig failed: %v", err)
+    }
+    if cfg.Version != CurrentVersion {
+        t.Errorf("Expected version %d, got %d", CurrentVersion, cfg.Version)
+    }
+    if !cfg.UDP.Enabled {
+        t.Error("Expected enable_udp to migrate to udp.enabled")
+    }
+    if cfg.Timeout.Duration() != 5*time.Minute {
+        t.Errorf("Expected default timeout, got %s", cfg.Timeout)
+    }
+    if err := cfg.Validate(); err != nil {
+        t.Errorf("Migrated config is invalid: %v", err)
+    }
+}
+
+func TestLoadConfigRejectsUnknownFields(t *testing.T) {
+    path := writeConfig(t, "config.yaml", `
+version: 2
+server_address: "127.0.0.1:1080"
+password: secret
+listeners:
+  - name: a
+    address: "127.0.0.1:1081"
+    pasword: typo
+`)
+
+    if _, err := LoadConfig(path); err == nil {
+        t.Fatal("Expected error for unknown field, got nil")
+    }
+}
+
+func TestLoadConfigEnvOverrides(t *testing.T) {
+    path := writeConfig(t, "config.yaml", `
+version: 2
+server_address: "127.0.0.1:1080"
+password: secret
+`)
+    os.Setenv("LUNASOCKS_PASSWORD", "from-env")
+    os.Setenv("LUNASOCKS_UDP_TIMEOUT", "90")
+    defer os.Unsetenv("LUNASOCKS_PASSWORD")
+    defer os.Unsetenv("LUNASOCKS_UDP_TIMEOUT")
+
+    cfg, err := LoadConfig(path)
+    if err != nil {
+        t.Fatalf("LoadConfig failed: %v", err)
+    }
+    if cfg.Password.Value() != "from-env" {
+        t.Errorf("Expected password from env, got %q", cfg.Password)
+    }
+    if cfg.UDP.Timeout.Duration() != 90*time.Second {
+        t.Errorf("Expected udp timeout 90s, got %s", cfg.UDP.Timeout)
+    }
+}
+
+func TestLoadConfigLibevJSON(t *testing.T) {
+    path := writeConfig(t, "config.json", `{
+    "server": ["0.0.0.0", "::"],
+    "server_port": 8388,
+    "password": "secret",
+    "method": "chacha20-ietf-poly1305",
+    "timeout": 300,
+    "mode": "tcp_and_udp",
+    "fast_open": true,
+    "plugin": "obfs-server",
+    "plugin_opts": "obfs=http"
+}`)
+
+    cfg, err := LoadConfig(path)
+    if err != nil {
+        t.Fatalf("LoadConfig failed: %v", err)
+    }
+    if err := cfg.Validate(); err != nil {
+        t.Fatalf("Translated config is invalid: %v", err)
+    }
+    if len(cfg.Listeners) != 2 {
+        t.Fatalf("Expected 2 listeners, got %d", len(cfg.Listeners))
+    }
+    lc := cfg.Listeners[1]
+    if lc.Address != "[::]:8388" || lc.Method != "chacha20-poly1305" || !lc.UDP ||
+        lc.Plugin != "obfs-server" || lc.PluginOpts != "obfs=http" {
+        t.Errorf("Unexpected listener %+v", lc)
+    }
+    if cfg.Timeout.Duration() != 300*time.Second {
+        t.Errorf("Expected timeout 300s, got %s", cfg.Timeout)
+    }
+}
+
+func TestLoadConfigTOML(t *testing.T) {
+    path := writeConfig(t, "config.toml", `
+version = 2
+timeout = "2m"
+
+[[listeners]]
+name = "socks"
+address = "127.0.0.1:1080"
+protocol = "socks5"
+`)
+
+    cfg, err := LoadConfig(path)
+    if err != nil {
+        t.Fatalf("LoadConfig failed: %v", err)
+    }
+    if err := cfg.Validate(); err != nil {
+        t.Fatalf("Config is invalid: %v", err)
+    }
+    if cfg.Timeout.Duration() != 2*time.Minute || cfg.Listeners[0].Protocol != ProtocolSocks5 {
+        t.Errorf("Unexpected config %+v", cfg)
+    }
+}
+
+func TestLoadConfigACMEDefaults(t *testing.T) {
+    path := writeConfig(t, "config.yaml", `
+version: 2
+listeners:
+  - name: https
+    address: ":443"
+    protocol: http
+    tls:
+      enabled: true
+      acme:
+        enabled: true
+        domains: [proxy.example.com]
+`)
+
+    cfg, err := LoadConfig(path)
+    if err != nil {
+        t.Fatalf("LoadConfig failed: %v", err)
+    }
+    if err := cfg.Validate(); err != nil {
+        t.Fatalf("Config is invalid: %v", err)
+    }
+    acme := cfg.Listeners[0].TLS.ACME
+    if acme.DirectoryURL != LetsEncryptURL || acme.CacheDir != DefaultACMECacheDir {
+        t.Errorf("Unexpected ACME defaults %+v", acme)
+    }
+
+    cfg.Listeners[0].TLS.ClientCA = "ca.pem"
+    if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "tls.acme.http_address") {
+        t.Errorf("Expected an http_address error with client_ca, got %v", err)
+    }
+    cfg.Listeners[0].TLS.ACME.HTTPAddress = ":80"
+    if err := cfg.Validate(); err != nil {
+        t.Errorf("Config with HTTP-01 and client_ca is invalid: %v", err)
+    }
+
+    cfg.Listeners[0].TLS.ACME.Domains = nil
+    cfg.Listeners[0].TLS.CertFile = "cert.pem"
+    err = cfg.Validate()
+    if err == nil || !strings.Contains(err.Error(), "tls.acme.domains") || !strings.Contains(err.Error(), "certificate files") {
+        t.Errorf("Expected domain and certificate file errors, got %v", err)
+    }
+}
+
+func TestLoadConfigMux(t *testing.T) {
+    path := writeConfig(t, "config.yaml", `
+version: 2
+listeners:
+  - name: ss
+    address: ":8388"
+    protocol: shadowsocks
+    method: aes-256-gcm
+    password: s3cret
+    mux:
+      keepalive_interval: 15s
+      keepalive_timeout: 1m
+      max_streams: 64
+`)
+
+    cfg, err := LoadConfig(path)
+    if err != nil {
+        t.Fatalf("LoadConfig failed: %v", err)
+    }
+    if err := cfg.Validate(); err != nil {
+        t.Fatalf("Config is invalid: %v", err)
+    }
+    if mc := cfg.Listeners[0].Mux; mc.KeepAliveTimeout != Duration(time.Minute) || mc.MaxStreams != 64 {
+        t.Errorf("Unexpected mux config %+v", mc)
+    }
+
+    cfg.Listeners[0].Mux.KeepAliveTimeout = Duration(time.Second)
+    cfg.Listeners[0].Mux.MaxFrameSize = 1 << 20
+    err = cfg.Validate()
+    if err == nil || !strings.Contains(err.Error(), "mux.keepalive_timeout") || !strings.Contains(err.Error(), "mux.max_frame_size") {
+        t.Errorf("Expected keepalive and frame size errors, got %v", err)
+    }
+}
+
+func TestAdminAccountsAndTokens(t *testing.T) {
+    path := writeConfig(t, "config.yaml", `
+version: 2
+admin:
+  address: "127.0.0.1:8443"
+  accounts:
+    - username: alice
+      password_hash: "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"
+      role: admin
+    - username: bob
+      password_hash: "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"
+  tokens:
+    - name: monitoring
+      token: "0123456789abcdef0123"
+listeners:
+  - name: a
+    address: "127.0.0.1:1080"
+    protocol: socks5
+`)
+
+    cfg, err := LoadConfig(path)
+    if err != nil {
+        t.Fatalf("LoadConfig failed: %v", err)
+    }
+    if err := cfg.Validate(); err != nil {
+        t.Fatalf("Config is invalid: %v", err)
+    }
+    if !cfg.Admin.AuthEnabled() || cfg.Admin.Accounts[1].Role != RoleReadOnly || cfg.Admin.Tokens[0].Role != RoleReadOnly {
+        t.Errorf("Unexpected admin defaults %+v", cfg.Admin)
+    }
+
+    cfg.Admin.Accounts[1].Username = "alice"
+    cfg.Admin.Accounts[1].PasswordHash = NewSecret("hunter2")
+    cfg.Admin.Tokens[0].Token = NewSecret("short")
+    err = cfg.Validate()
+    for _, want := range []string{"duplicate user", "not a bcrypt hash", "at least 16 characters"} {
+        if err == nil || !strings.Contains(err.Error(), want) {
+            t.Errorf("Expected %q in %v", want, err)
+        }
+    }
+}
+
+func TestSecretReferencesAreResolvedAndRedacted(t *testing.T) {
+    secretFile := writeConfig(t, "password", "from-file\n")
+    path := writeConfig(t, "config.yaml", `
+version: 2
+listeners:
+  - name: a
+    address: "127.0.0.1:1080"
+    password: "file:`+secretFile+`"
+  - name: b
+    address: "127.0.0.1:1081"
+    password: literal
+`)
+
+    cfg, err := LoadConfig(path)
+    if err != nil {
+        t.Fatalf("LoadConfig failed: %v", err)
+    }
+    if got := cfg.Listeners[0].Password.Value(); got != "from-file" {
+        t.Errorf("Expected password from file, got %q", got)
+    }
+    if files := cfg.SecretFiles(); len(files) != 1 || files[0] != secretFile {
+        t.Errorf("Unexpected secret files %v", files)
+    }
+
+    out, err := json.Marshal(cfg)
+    if err != nil {
+        t.Fatalf("Marshal failed: %v", err)
+    }
+    if strings.Contains(string(out), "from-file") || strings.Contains(string(out), "literal") {
+        t.Errorf("Secret leaked into JSON: %s", out)
+    }
+
+    // Posting the redacted form back keeps the existing secret.
+    if err := json.Unmarshal(out, cfg); err != nil {
+        t.Fatalf("Unmarshal failed: %v", err)
+    }
+    if got := cfg.Listeners[1].Password.Value(); got != "literal" {
+        t.Errorf("Expected literal password to survive round trip, got %q", got)
+    }
+}
+
+func TestCloneKeepsEmptySlices(t *testing.T) {
+    cfg := Default()
+    cfg.Listeners = []ListenerConfig{{Name: "a", Plugins: []string{}, TLS: TLSConfig{ALPN: []string{"h2"}, CipherSuites: []string{}}}}
+    cfg.Admin.Tokens = []AdminToken{}
+
+    cp := cfg.Clone()
+    if !reflect.DeepEqual(cfg, cp) {
+        t.Errorf("Clone differs from the original:\n%+v\n%+v", cfg, cp)
+    }
+    cp.Listeners[0].TLS.ALPN[0] = "http/1.1"
+    if cfg.Listeners[0].TLS.ALPN[0] != "h2" {
+        t.Error("Clone shares the ALPN slice with the original")
+    }
+}
+
+func TestRedactedDiff(t *testing.T) {
+    old := Default()
+    old.Listeners = []ListenerConfig{{Name: "a", Address: ":1080", Protocol: "shadowsocks", Password: NewSecret("old-password")}}
+    next := old.Clone()
+    next.Timeout = Duration(time.Minute)
+    next.Listeners[0].Password = NewSecret("new-password")
+    next.Listeners = append(next.Listeners, ListenerConfig{Name: "b", Address: ":1081", Protocol: "socks5"})
+    next.Plugins[0].Settings = map[string]interface{}{"api_key": "plugin-secret"}
+
+    changes := RedactedDiff(old, next)
+    paths := make(map[string]FieldChange)
+    for _, c := range changes {
+        paths[c.Path] = c
+    }
+    if len(changes) != 4 {
+        t.Errorf("Expected 4 changes, got %+v", changes)
+    }
+    if c, ok := paths["timeout"]; !ok || c.Before.(json.RawMessage) == nil {
+        t.Errorf("Missing timeout change in %+v", changes)
+    }
+    if c := paths["listeners[0].password"]; c.Before != Redacted || c.After != Redacted {
+        t.Errorf("Password change not redacted: %+v", c)
+    }
+    if c, ok := paths["listeners[1]"]; !ok || c.Before != nil {
+        t.Errorf("Missing added listener in %+v", changes)
+    }
+    if c := paths["plugins[0].settings.api_key"]; c.Before != nil || c.After != Redacted {
+        t.Errorf("Plugin setting change not redacted: %+v", c)
+    }
+    data, _ := json.Marshal(changes)
+    if strings.Contains(string(data), "new-password") || strings.Contains(string(data), "plugin-secret") {
+        t.Errorf("Secret leaked: %s", data)
+    }
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+    "encoding/json"
+    "fmt"
+    "time"
+)
+
+// Duration is a time.Duration that is written as a Go duration string
+// ("30s", "5m") and also accepts a bare number of seconds.
+type Duration time.Duration
+
+func (d Duration) Duration() time.Duration {
+    return time.Duration(d)
+}
+
+func (d Duration) String() string {
+    return time.Duration(d).String()
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+    return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+    v, err := time.ParseDuration(string(text))
+    if err != nil {
+        return err
+    }
+    *d = Duration(v)
+    return nil
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+    var v interface{}
+    if err := json.Unmarshal(data, &v); err != nil {
+        return err
+    }
+    switch t := v.(type) {
+    case float64:
+        *d = Duration(t * float64(time.Second))
+        return nil
+    case string:
+        return d.UnmarshalText([]byte(t))
+    default:
+        return fmt.Errorf("invalid duration %s", data)
+    }
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+    return d.String(), nil
+}
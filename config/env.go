@@ -0,0 +1,86 @@
+package config
+
+import (
+    "fmt"
+    "os"
+    "reflect"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// EnvPrefix prefixes the environment variables that override scalar
+// config fields. The variable name is the field's YAML path in upper case
+// joined by underscores, e.g. LUNASOCKS_UDP_TIMEOUT for udp.timeout.
+const EnvPrefix = "LUNASOCKS"
+
+var durationType = reflect.TypeOf(Duration(0))
+
+func applyEnv(cfg *Config) error {
+    return applyEnvValue(reflect.ValueOf(cfg).Elem(), EnvPrefix)
+}
+
+func applyEnvValue(v reflect.Value, prefix string) error {
+    t := v.Type()
+    for i := 0; i < t.NumField(); i++ {
+        field := t.Field(i)
+        tag := strings.Split(field.Tag.Get("yaml"), ",")[0]
+        if tag == "" || tag == "-" {
+            continue
+        }
+        name := prefix + "_" + strings.ToUpper(tag)
+        fv := v.Field(i)
+
+        if fv.Kind() == reflect.Struct && fv.Type() != durationType && fv.Type() != secretType {
+            if err := applyEnvValue(fv, name); err != nil {
+                return err
+            }
+            continue
+        }
+
+        value, ok := os.LookupEnv(name)
+        if !ok {
+            continue
+        }
+        if err := setFromString(fv, value); err != nil {
+            return fmt.Errorf("config: %s: %v", name, err)
+        }
+    }
+    return nil
+}
+
+func setFromString(v reflect.Value, s string) error {
+    if v.Type() == secretType {
+        return v.Addr().Interface().(*Secret).UnmarshalText([]byte(s))
+    }
+    if v.Type() == durationType {
+        var d Duration
+        if n, err := strconv.ParseFloat(s, 64); err == nil {
+            d = Duration(n * float64(time.Second))
+        } else if err := d.UnmarshalText([]byte(s)); err != nil {
+            return err
+        }
+        v.Set(reflect.ValueOf(d))
+        return nil
+    }
+
+    switch v.Kind() {
+    case reflect.String:
+        v.SetString(s)
+    case reflect.Bool:
+        b, err := strconv.ParseBool(s)
+        if err != nil {
+            return err
+        }
+        v.SetBool(b)
+    case reflect.Int, reflect.Int64:
+        n, err := strconv.ParseInt(s, 10, 64)
+        if err != nil {
+            return err
+        }
+        v.SetInt(n)
+    default:
+        // Lists such as listeners cannot be expressed in a single variable.
+    }
+    return nil
+}
@@ -0,0 +1,366 @@
+package config
+
+import (
+    "crypto/tls"
+    "fmt"
+    "reflect"
+    "strings"
+)
+
+// Inbound protocols understood by network.Server.
+const (
+    ProtocolLunasocks   = "lunasocks"
+    ProtocolSocks5      = "socks5"
+    ProtocolShadowsocks = "shadowsocks"
+    ProtocolHTTP        = "http"
+)
+
+// DefaultListenerName is the name given to the listener synthesized from
+// the top-level server_address fields when no listeners are declared.
+const DefaultListenerName = "default"
+
+type ListenerConfig struct {
+    Name     string    `yaml:"name" json:"name"`
+    Address  string    `yaml:"address" json:"address"`
+    Protocol string    `yaml:"protocol" json:"protocol"`
+    Method   string    `yaml:"method" json:"method"`
+    Username string    `yaml:"username" json:"username"`
+    Password Secret    `yaml:"password" json:"password"`
+    TLS      TLSConfig `yaml:"tls" json:"tls"`
+    UDP      bool      `yaml:"udp" json:"udp"`
+    Plugins  []string  `yaml:"plugins" json:"plugins"`
+
+    // Accept UDP carried over the TCP connection (shadowsocks only), for
+    // clients on networks that block UDP.
+    UDPOverTCP bool `yaml:"udp_over_tcp" json:"udp_over_tcp"`
+
+    // Also accept the users managed through /api/users (socks5, http and
+    // shadowsocks). A shadowsocks listener may then leave password empty
+    // to accept only those users.
+    Users bool `yaml:"users" json:"users"`
+
+    // SIP003 plugin binary and options (shadowsocks only). The plugin
+    // listens on Address and forwards to the listener on loopback;
+    // obfs-server is built in and runs without a process.
+    Plugin     string `yaml:"plugin,omitempty" json:"plugin,omitempty"`
+    PluginOpts string `yaml:"plugin_opts,omitempty" json:"plugin_opts,omitempty"`
+
+    WebSocket WebSocketConfig `yaml:"websocket" json:"websocket"`
+
+    // Limits of the multiplexed sessions clients open (shadowsocks only).
+    Mux MuxConfig `yaml:"mux" json:"mux"`
+}
+
+// MuxConfig tunes the multiplexed sessions of a shadowsocks listener.
+// Fields left at zero keep the defaults of the mux package; Disabled
+// refuses sessions altogether, so clients open a connection per request.
+type MuxConfig struct {
+    Disabled          bool     `yaml:"disabled" json:"disabled"`
+    KeepAliveInterval Duration `yaml:"keepalive_interval" json:"keepalive_interval"`
+    KeepAliveTimeout  Duration `yaml:"keepalive_timeout" json:"keepalive_timeout"`
+    MaxFrameSize      int      `yaml:"max_frame_size" json:"max_frame_size"`
+    MaxStreamBuffer   int      `yaml:"max_stream_buffer" json:"max_stream_buffer"`
+    MaxStreams        int      `yaml:"max_streams" json:"max_streams"`
+}
+
+// Smallest max_stream_buffer a session runs with: a stream's initial
+// receive window.
+const minMuxStreamBuffer = 256 << 10
+
+// WebSocketConfig carries a listener's connections inside WebSocket
+// upgrades on Path. With ShareWebAdmin the upgrades are served by the web
+// admin server on its port and the listener binds no address of its own.
+type WebSocketConfig struct {
+    Enabled       bool   `yaml:"enabled" json:"enabled"`
+    Path          string `yaml:"path" json:"path"`
+    ShareWebAdmin bool   `yaml:"share_web_admin" json:"share_web_admin"`
+}
+
+// SharesWebAdmin reports whether the listener is served by the web admin.
+func (lc *ListenerConfig) SharesWebAdmin() bool {
+    return lc.WebSocket.Enabled && lc.WebSocket.ShareWebAdmin
+}
+
+type TLSConfig struct {
+    Enabled  bool   `yaml:"enabled" json:"enabled"`
+    CertFile string `yaml:"cert_file" json:"cert_file"`
+    KeyFile  string `yaml:"key_file" json:"key_file"`
+
+    // Further certificates, chosen by SNI. Certificate files are reloaded
+    // when they change on disk.
+    Certificates []CertificateConfig `yaml:"certificates" json:"certificates"`
+    // Lowest protocol version accepted: "1.0" to "1.3"; "1.2" by default.
+    MinVersion string `yaml:"min_version" json:"min_version"`
+    // Cipher suites for TLS 1.2 and below, by Go name. TLS 1.3 suites are
+    // not configurable.
+    CipherSuites []string `yaml:"cipher_suites" json:"cipher_suites"`
+    // Protocols offered in ALPN, in order of preference.
+    ALPN []string `yaml:"alpn" json:"alpn"`
+    // Certificates from an ACME CA, replacing the certificate files.
+    ACME ACMEConfig `yaml:"acme" json:"acme"`
+
+    // Mutual TLS. With ClientCA set, clients present a certificate signed
+    // by one of its CAs and the certificate's CN or SAN (see Identity)
+    // becomes the connection's user. CRLFile lists revoked certificates.
+    ClientCA   string `yaml:"client_ca" json:"client_ca"`
+    ClientAuth string `yaml:"client_auth" json:"client_auth"`
+    Identity   string `yaml:"identity" json:"identity"`
+    CRLFile    string `yaml:"crl_file" json:"crl_file"`
+}
+
+func (tc TLSConfig) clone() TLSConfig {
+    if len(tc.Certificates) > 0 {
+        tc.Certificates = append([]CertificateConfig(nil), tc.Certificates...)
+    }
+    tc.CipherSuites = cloneStrings(tc.CipherSuites)
+    tc.ALPN = cloneStrings(tc.ALPN)
+    tc.ACME.Domains = cloneStrings(tc.ACME.Domains)
+    return tc
+}
+
+type CertificateConfig struct {
+    CertFile string `yaml:"cert_file" json:"cert_file"`
+    KeyFile  string `yaml:"key_file" json:"key_file"`
+}
+
+// TLSVersions maps the accepted min_version values to protocol versions.
+var TLSVersions = map[string]uint16{
+    "1.0": tls.VersionTLS10,
+    "1.1": tls.VersionTLS11,
+    "1.2": tls.VersionTLS12,
+    "1.3": tls.VersionTLS13,
+}
+
+// CipherSuite returns the id of a secure cipher suite by its Go name,
+// e.g. "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256".
+func CipherSuite(name string) (uint16, bool) {
+    for _, cs := range tls.CipherSuites() {
+        if cs.Name == name {
+            return cs.ID, true
+        }
+    }
+    return 0, false
+}
+
+// Client certificate policies for TLSConfig.ClientAuth. The default is
+// ClientAuthRequire.
+const (
+    ClientAuthRequire  = "require"
+    ClientAuthOptional = "optional"
+)
+
+// Certificate fields TLSConfig.Identity can name. The default is
+// IdentityCN.
+const (
+    IdentityCN  = "cn"
+    IdentitySAN = "san"
+)
+
+// Inbounds returns the listeners to run. Configs written before listeners
+// existed describe a single lunasocks listener with the top-level fields.
+func (c *Config) Inbounds() []ListenerConfig {
+    if len(c.Listeners) > 0 {
+        return c.Listeners
+    }
+    return []ListenerConfig{{
+        Name:     DefaultListenerName,
+        Address:  c.ServerAddress,
+        Protocol: ProtocolLunasocks,
+        Password: c.Password,
+        TLS: TLSConfig{
+            Enabled:  c.UseTLS,
+            CertFile: c.TLSCertFile,
+            KeyFile:  c.TLSKeyFile,
+        },
+        UDP: c.UDP.Enabled,
+    }}
+}
+
+// Inbound returns the listener with the given name.
+func (c *Config) Inbound(name string) (ListenerConfig, bool) {
+    return findListener(c.Inbounds(), name)
+}
+
+func (lc *ListenerConfig) validate() []string {
+    var problems []string
+    prefix := fmt.Sprintf("listeners[%s]", lc.Name)
+    add := func(format string, args ...interface{}) {
+        problems = append(problems, prefix+"."+fmt.Sprintf(format, args...))
+    }
+
+    if lc.Name == "" {
+        problems = append(problems, "listeners: name must not be empty")
+    }
+    if lc.SharesWebAdmin() {
+        if lc.Address != "" {
+            add("address: must be empty when websocket.share_web_admin is set")
+        }
+    } else if err := validateAddress(lc.Address); err != nil {
+        add("address: %v", err)
+    }
+
+    switch lc.Protocol {
+    case ProtocolLunasocks:
+        if lc.Password.Value() == "" {
+            add("password: must not be empty")
+        }
+    case ProtocolShadowsocks:
+        if lc.Password.Value() == "" && !lc.Users {
+            add("password: must not be empty")
+        }
+        if !supportedMethods[lc.Method] {
+            add("method: unsupported method %q", lc.Method)
+        }
+    case ProtocolSocks5, ProtocolHTTP:
+        if lc.Username != "" && lc.Password.Value() == "" {
+            add("password: required when username is set")
+        }
+        if lc.UDP {
+            add("udp: not supported for %s listeners", lc.Protocol)
+        }
+    default:
+        add("protocol: unknown protocol %q", lc.Protocol)
+    }
+
+    lc.TLS.validate(add)
+    if lc.Plugin != "" && lc.Protocol != ProtocolShadowsocks {
+        add("plugin: SIP003 plugins are only supported for shadowsocks listeners")
+    }
+    if lc.PluginOpts != "" && lc.Plugin == "" {
+        add("plugin_opts: requires plugin")
+    }
+    if lc.UDPOverTCP && lc.Protocol != ProtocolShadowsocks {
+        add("udp_over_tcp: only supported for shadowsocks listeners")
+    }
+    if lc.Users && lc.Protocol == ProtocolLunasocks {
+        add("users: not supported for lunasocks listeners")
+    }
+    if lc.Mux != (MuxConfig{}) && lc.Protocol != ProtocolShadowsocks {
+        add("mux: only supported for shadowsocks listeners")
+    }
+    lc.Mux.validate(add)
+    if lc.WebSocket.Enabled {
+        if !strings.HasPrefix(lc.WebSocket.Path, "/") {
+            add("websocket.path: must start with /")
+        }
+        if lc.Plugin != "" {
+            add("websocket: cannot be combined with plugin")
+        }
+        if lc.WebSocket.ShareWebAdmin && (lc.TLS.Enabled || lc.UDP) {
+            add("websocket.share_web_admin: tls and udp come from the web admin server")
+        }
+    }
+    return problems
+}
+
+// validate checks a TLS section; add reports problems relative to the
+// section's parent.
+func (tc *TLSConfig) validate(add func(format string, args ...interface{})) {
+    if tc.Enabled {
+        if tc.ACME.Enabled {
+            if tc.CertFile != "" || tc.KeyFile != "" || len(tc.Certificates) > 0 {
+                add("tls.acme: cannot be combined with certificate files")
+            }
+            tc.ACME.validate(add)
+            // The CA presents no client certificate when it checks a
+            // TLS-ALPN-01 challenge, so required client certificates leave
+            // HTTP-01 as the only way to get one.
+            if tc.ClientCA != "" && tc.ClientAuth != ClientAuthOptional && tc.ACME.HTTPAddress == "" {
+                add("tls.acme.http_address: required when client certificates are required")
+            }
+        } else {
+            if tc.CertFile == "" {
+                add("tls.cert_file: required when tls is enabled")
+            }
+            if tc.KeyFile == "" {
+                add("tls.key_file: required when tls is enabled")
+            }
+        }
+        for i, cc := range tc.Certificates {
+            if cc.CertFile == "" || cc.KeyFile == "" {
+                add("tls.certificates[%d]: cert_file and key_file are required", i)
+            }
+        }
+        if _, ok := TLSVersions[tc.MinVersion]; !ok && tc.MinVersion != "" {
+            add("tls.min_version: must be one of 1.0, 1.1, 1.2 or 1.3")
+        }
+        for _, name := range tc.CipherSuites {
+            if _, ok := CipherSuite(name); !ok {
+                add("tls.cipher_suites: unknown or insecure cipher suite %q", name)
+            }
+        }
+    } else if tc.ClientCA != "" {
+        add("tls.client_ca: requires tls to be enabled")
+    }
+    switch tc.ClientAuth {
+    case "", ClientAuthRequire, ClientAuthOptional:
+    default:
+        add("tls.client_auth: must be %q or %q", ClientAuthRequire, ClientAuthOptional)
+    }
+    switch tc.Identity {
+    case "", IdentityCN, IdentitySAN:
+    default:
+        add("tls.identity: must be %q or %q", IdentityCN, IdentitySAN)
+    }
+    if tc.ClientCA == "" && (tc.ClientAuth != "" || tc.CRLFile != "") {
+        add("tls: client_auth and crl_file require client_ca")
+    }
+}
+
+// validate checks a mux section; add reports problems relative to the
+// section's parent.
+func (mc *MuxConfig) validate(add func(format string, args ...interface{})) {
+    if mc.KeepAliveInterval < 0 || mc.KeepAliveTimeout < 0 {
+        add("mux: keepalive_interval and keepalive_timeout must not be negative")
+    }
+    if mc.KeepAliveInterval > 0 && mc.KeepAliveTimeout > 0 && mc.KeepAliveTimeout < mc.KeepAliveInterval {
+        add("mux.keepalive_timeout: must not be shorter than keepalive_interval")
+    }
+    if mc.MaxFrameSize < 0 || mc.MaxFrameSize > 65535 {
+        add("mux.max_frame_size: must be between 1 and 65535")
+    }
+    if mc.MaxStreamBuffer != 0 && mc.MaxStreamBuffer < minMuxStreamBuffer {
+        add("mux.max_stream_buffer: must be at least %d", minMuxStreamBuffer)
+    }
+    if mc.MaxStreams < 0 {
+        add("mux.max_streams: must not be negative")
+    }
+}
+
+var supportedMethods = map[string]bool{
+    "aes-256-gcm":       true,
+    "chacha20-poly1305": true,
+}
+
+// SupportedMethod reports whether method is a cipher shadowsocks
+// listeners can use.
+func SupportedMethod(method string) bool {
+    return supportedMethods[method]
+}
+
+func compareListeners(old, new []ListenerConfig) []string {
+    var fields []string
+    seen := make(map[string]bool)
+    for _, o := range old {
+        seen[o.Name] = true
+        n, ok := findListener(new, o.Name)
+        if !ok || !reflect.DeepEqual(o, n) {
+            fields = append(fields, fmt.Sprintf("listeners[%s]", o.Name))
+        }
+    }
+    for _, n := range new {
+        if !seen[n.Name] {
+            fields = append(fields, fmt.Sprintf("listeners[%s]", n.Name))
+        }
+    }
+    return fields
+}
+
+func findListener(list []ListenerConfig, name string) (ListenerConfig, bool) {
+    for _, lc := range list {
+        if lc.Name == name {
+            return lc, true
+        }
+    }
+    return ListenerConfig{}, false
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "sync"
+    "sync/atomic"
+)
+
+// Applier pushes a configuration change into a running subsystem. It is
+// called with the previous and the candidate configuration; returning an
+// error aborts the update and rolls back the appliers that already ran.
+type Applier interface {
+    ApplyConfig(old, new *Config, diff Diff) error
+}
+
+type ApplierFunc func(old, new *Config, diff Diff) error
+
+func (f ApplierFunc) ApplyConfig(old, new *Config, diff Diff) error {
+    return f(old, new, diff)
+}
+
+type namedApplier struct {
+    name    string
+    applier Applier
+}
+
+// Manager owns the live configuration. Readers get an immutable snapshot
+// through Current; writers go through Update, which validates, diffs and
+// applies the candidate before swapping it in.
+type Manager struct {
+    mu       sync.Mutex
+    current  atomic.Pointer[Config]
+    appliers []namedApplier
+    // edited holds the fields changed through Edit since the
+    // configuration was last reloaded from its file.
+    edited map[string]bool
+}
+
+// EditsError is returned by Reload when the reloaded configuration would
+// revert fields changed through Edit.
+type EditsError struct {
+    Fields []string
+}
+
+func (e *EditsError) Error() string {
+    return "reload would discard runtime edits to " + strings.Join(e.Fields, ", ")
+}
+
+func NewManager(cfg *Config) (*Manager, error) {
+    next, err := prepare(cfg)
+    if err != nil {
+        return nil, err
+    }
+    m := &Manager{}
+    m.current.Store(next)
+    return m, nil
+}
+
+// prepare copies cfg, resolves its secret references and validates it.
+func prepare(cfg *Config) (*Config, error) {
+    next := cfg.Clone()
+    if err := next.ResolveSecrets(); err != nil {
+        return nil, err
+    }
+    if err := next.Validate(); err != nil {
+        return nil, err
+    }
+    return next, nil
+}
+
+// Current returns the active configuration. Callers must not modify it.
+func (m *Manager) Current() *Config {
+    return m.current.Load()
+}
+
+// Subscribe registers an applier. Appliers run in registration order and
+// are rolled back in reverse order.
+func (m *Manager) Subscribe(name string, a Applier) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.appliers = append(m.appliers, namedApplier{name: name, applier: a})
+}
+
+// Update replaces the active configuration with cfg. Secret references
+// are re-read, so an update with unchanged settings picks up rotated
+// secrets. Nothing is swapped in unless every subscribed applier accepts
+// the change.
+func (m *Manager) Update(cfg *Config) (Diff, error) {
+    next, err := prepare(cfg)
+    if err != nil {
+        return Diff{}, err
+    }
+
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return m.apply(next)
+}
+
+// Edit is Update for changes made at runtime, such as through the web
+// admin. The changed fields exist only in memory, so Reload checks them
+// before it reverts them.
+func (m *Manager) Edit(cfg *Config) (Diff, error) {
+    next, err := prepare(cfg)
+    if err != nil {
+        return Diff{}, err
+    }
+
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    diff, err := m.apply(next)
+    if err != nil {
+        return diff, err
+    }
+    for _, f := range diff.Fields {
+        if m.edited == nil {
+            m.edited = make(map[string]bool)
+        }
+        m.edited[f] = true
+    }
+    return diff, nil
+}
+
+// Reload is Update for a configuration read back from its file. If it
+// would revert fields changed through Edit, it fails with an *EditsError
+// unless force is set; a forced reload returns the fields it reverted.
+func (m *Manager) Reload(cfg *Config, force bool) (Diff, []string, error) {
+    next, err := prepare(cfg)
+    if err != nil {
+        return Diff{}, nil, err
+    }
+
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    var discarded []string
+    for _, f := range Compare(m.current.Load(), next).Fields {
+        if m.edited[f] {
+            discarded = append(discarded, f)
+        }
+    }
+    sort.Strings(discarded)
+    if len(discarded) > 0 && !force {
+        return Diff{}, discarded, &EditsError{Fields: discarded}
+    }
+
+    diff, err := m.apply(next)
+    if err != nil {
+        return diff, nil, err
+    }
+    // The live configuration matches the file again.
+    m.edited = nil
+    return diff, discarded, nil
+}
+
+// apply runs the appliers and swaps next in. The caller holds m.mu.
+func (m *Manager) apply(next *Config) (Diff, error) {
+    old := m.current.Load()
+    diff := Compare(old, next)
+    if diff.Empty() {
+        return diff, nil
+    }
+
+    for i, na := range m.appliers {
+        if err := na.applier.ApplyConfig(old, next, diff); err != nil {
+            m.rollback(m.appliers[:i], old, next, diff)
+            return diff, fmt.Errorf("apply %s: %w", na.name, err)
+        }
+    }
+
+    m.current.Store(next)
+    return diff, nil
+}
+
+func (m *Manager) rollback(applied []namedApplier, old, next *Config, diff Diff) {
+    for i := len(applied) - 1; i >= 0; i-- {
+        // Best effort: the old configuration was running a moment ago.
+        applied[i].applier.ApplyConfig(next, old, diff)
+    }
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+    "errors"
+    "testing"
+)
+
+func validConfig() *Config {
+    cfg := Default()
+    cfg.ServerAddress = "127.0.0.1:1080"
+    cfg.Password = NewSecret("secret")
+    return cfg
+}
+
+func TestUpdateRejectsInvalidConfig(t *testing.T) {
+    m, err := NewManager(validConfig())
+    if err != nil {
+        t.Fatalf("NewManager failed: %v", err)
+    }
+
+    bad := validConfig()
+    bad.ServerAddress = "no-port"
+    if _, err := m.Update(bad); err == nil {
+        t.Fatal("Expected validation error, got nil")
+    }
+    if m.Current().ServerAddress != "127.0.0.1:1080" {
+        t.Errorf("Config changed after rejected update: %s", m.Current().ServerAddress)
+    }
+}
+
+func TestUpdateRollsBackOnApplyFailure(t *testing.T) {
+    m, err := NewManager(validConfig())
+    if err != nil {
+        t.Fatalf("NewManager failed: %v", err)
+    }
+
+    var applied []string
+    m.Subscribe("first", ApplierFunc(func(old, new *Config, diff Diff) error {
+        applied = append(applied, new.ServerAddress)
+        return nil
+    }))
+    m.Subscribe("second", ApplierFunc(func(old, new *Config, diff Diff) error {
+        return errors.New("boom")
+    }))
+
+    next := validConfig()
+    next.ServerAddress = "127.0.0.1:2080"
+    diff, err := m.Update(next)
+    if err == nil {
+        t.Fatal("Expected apply error, got nil")
+    }
+    if diff.Changes&ChangeListeners == 0 {
+        t.Errorf("Expected listener change in diff, got %v", diff.Changes)
+    }
+
+    want := []string{"127.0.0.1:2080", "127.0.0.1:1080"}
+    if len(applied) != len(want) || applied[0] != want[0] || applied[1] != want[1] {
+        t.Errorf("Applied %v, want %v", applied, want)
+    }
+    if m.Current().ServerAddress != "127.0.0.1:1080" {
+        t.Errorf("Config swapped despite failure: %s", m.Current().ServerAddress)
+    }
+}
+
+func TestTogglePluginIsAChange(t *testing.T) {
+    m, err := NewManager(validConfig())
+    if err != nil {
+        t.Fatalf("NewManager failed: %v", err)
+    }
+
+    next := m.Current().Clone()
+    if err := next.SetPluginEnabled("logging", false); err != nil {
+        t.Fatal(err)
+    }
+    diff, err := m.Update(next)
+    if err != nil {
+        t.Fatalf("Update failed: %v", err)
+    }
+    if diff.Changes&ChangePlugins == 0 {
+        t.Errorf("Expected plugins change, got %s", diff.Changes)
+    }
+    if pc, _ := m.Current().Plugin("logging"); pc.IsEnabled() {
+        t.Error("Plugin still enabled after update")
+    }
+
+    next = m.Current().Clone()
+    next.Plugins = append(next.Plugins, PluginConfig{Name: "logging"})
+    if _, err := m.Update(next); err == nil {
+        t.Error("Expected duplicate plugin to be rejected")
+    }
+}
+
+func TestReloadKeepsEdits(t *testing.T) {
+    m, err := NewManager(validConfig())
+    if err != nil {
+        t.Fatalf("NewManager failed: %v", err)
+    }
+
+    edited := validConfig()
+    edited.ServerAddress = "127.0.0.1:2080"
+    if _, err := m.Edit(edited); err != nil {
+        t.Fatalf("Edit failed: %v", err)
+    }
+
+    // The file still has the old address.
+    var edits *EditsError
+    if _, _, err := m.Reload(validConfig(), false); !errors.As(err, &edits) {
+        t.Fatalf("Reload: got %v, want an EditsError", err)
+    }
+    if m.Current().ServerAddress != "127.0.0.1:2080" {
+        t.Errorf("Refused reload reverted the edit: %s", m.Current().ServerAddress)
+    }
+
+    // A file that carries the edit reloads, and clears it.
+    if _, _, err := m.Reload(edited, false); err != nil {
+        t.Fatalf("Reload of the edited config failed: %v", err)
+    }
+    if _, discarded, err := m.Reload(validConfig(), false); err != nil || len(discarded) != 0 {
+        t.Fatalf("Reload after the edit was saved: %v %v", discarded, err)
+    }
+
+    if _, err := m.Edit(edited); err != nil {
+        t.Fatalf("Edit failed: %v", err)
+    }
+    _, discarded, err := m.Reload(validConfig(), true)
+    if err != nil || len(discarded) == 0 {
+        t.Errorf("Forced reload: got %v %v, want the edited fields", discarded, err)
+    }
+    if m.Current().ServerAddress != "127.0.0.1:1080" {
+        t.Errorf("Forced reload kept the edit: %s", m.Current().ServerAddress)
+    }
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+    "fmt"
+)
+
+// migrations upgrade a raw document from the version used as the key to
+// the next one. Files without a version field are version 1.
+var migrations = map[int]func(raw map[string]interface{}) error{
+    1: migrateV1,
+}
+
+func migrate(raw map[string]interface{}) error {
+    version := 1
+    if v, ok := raw["version"]; ok {
+        n, ok := toInt(v)
+        if !ok {
+            return fmt.Errorf("config: invalid version %v", v)
+        }
+        version = n
+    }
+    if version > CurrentVersion {
+        return fmt.Errorf("config: version %d is newer than supported version %d", version, CurrentVersion)
+    }
+
+    for ; version < CurrentVersion; version++ {
+        if err := migrations[version](raw); err != nil {
+            return fmt.Errorf("config: migrate from version %d: %v", version, err)
+        }
+    }
+    raw["version"] = CurrentVersion
+    return nil
+}
+
+// migrateV1 moves the flat enable_udp flag into the udp section.
+func migrateV1(raw map[string]interface{}) error {
+    v, ok := raw["enable_udp"]
+    if !ok {
+        return nil
+    }
+    delete(raw, "enable_udp")
+
+    udp, ok := raw["udp"].(map[string]interface{})
+    if !ok {
+        udp = make(map[string]interface{})
+        raw["udp"] = udp
+    }
+    if _, set := udp["enabled"]; !set {
+        udp["enabled"] = v
+    }
+    return nil
+}
+
+func toInt(v interface{}) (int, bool) {
+    switch n := v.(type) {
+    case int:
+        return n, true
+    case int64:
+        return int(n), true
+    case float64:
+        return int(n), n == float64(int(n))
+    default:
+        return 0, false
+    }
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+    "fmt"
+    "reflect"
+)
+
+// PluginConfig enables one plugin. Plugins run in the order
+// they are listed.
+type PluginConfig struct {
+    Name string `yaml:"name" json:"name"`
+    // Enabled defaults to true; set it to false to keep a plugin's
+    // settings around without loading it.
+    Enabled  *bool                  `yaml:"enabled,omitempty" json:"enabled,omitempty"`
+    Settings map[string]interface{} `yaml:"settings,omitempty" json:"settings,omitempty"`
+    // External runs the plugin out of process instead of looking it up in
+    // the registry.
+    External *ExternalPluginConfig `yaml:"external,omitempty" json:"external,omitempty"`
+}
+
+// ExternalPluginConfig describes a plugin process. Command is started and
+// restarted by Lunasocks; it talks over stdin/stdout unless Socket is set.
+// With only Socket set, an already running plugin is connected to.
+type ExternalPluginConfig struct {
+    Command string   `yaml:"command" json:"command"`
+    Args    []string `yaml:"args,omitempty" json:"args,omitempty"`
+    Socket  string   `yaml:"socket,omitempty" json:"socket,omitempty"`
+    // Timeout bounds every call into the plugin.
+    Timeout Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+    // FailOpen lets connections through while the plugin is down or slow
+    // instead of rejecting them.
+    FailOpen bool `yaml:"fail_open,omitempty" json:"fail_open,omitempty"`
+}
+
+func (pc PluginConfig) IsEnabled() bool {
+    return pc.Enabled == nil || *pc.Enabled
+}
+
+func (pc PluginConfig) clone() PluginConfig {
+    if pc.Enabled != nil {
+        enabled := *pc.Enabled
+        pc.Enabled = &enabled
+    }
+    if pc.Settings != nil {
+        settings := make(map[string]interface{}, len(pc.Settings))
+        for k, v := range pc.Settings {
+            settings[k] = v
+        }
+        pc.Settings = settings
+    }
+    if pc.External != nil {
+        ext := *pc.External
+        ext.Args = cloneStrings(ext.Args)
+        pc.External = &ext
+    }
+    return pc
+}
+
+// Plugin returns the plugin entry with the given name.
+func (c *Config) Plugin(name string) (PluginConfig, bool) {
+    for _, pc := range c.Plugins {
+        if pc.Name == name {
+            return pc, true
+        }
+    }
+    return PluginConfig{}, false
+}
+
+// SetPluginEnabled turns a configured plugin on or off.
+func (c *Config) SetPluginEnabled(name string, enabled bool) error {
+    for i := range c.Plugins {
+        if c.Plugins[i].Name == name {
+            c.Plugins[i].Enabled = &enabled
+            return nil
+        }
+    }
+    return fmt.Errorf("plugin %q is not configured", name)
+}
+
+func validatePlugins(plugins []PluginConfig) []string {
+    var problems []string
+    seen := make(map[string]bool)
+    for i, pc := range plugins {
+        if pc.Name == "" {
+            problems = append(problems, fmt.Sprintf("plugins[%d].name: must not be empty", i))
+            continue
+        }
+        if seen[pc.Name] {
+            problems = append(problems, fmt.Sprintf("plugins[%d].name: duplicate plugin %q", i, pc.Name))
+        }
+        seen[pc.Name] = true
+        if ext := pc.External; ext != nil {
+            if ext.Command == "" && ext.Socket == "" {
+                problems = append(problems, fmt.Sprintf("plugins[%s].external: command or socket is required", pc.Name))
+            }
+            if ext.Timeout < 0 {
+                problems = append(problems, fmt.Sprintf("plugins[%s].external.timeout: must not be negative", pc.Name))
+            }
+        }
+    }
+    return problems
+}
+
+func comparePlugins(old, new []PluginConfig) bool {
+    if len(old) != len(new) {
+        return true
+    }
+    for i := range old {
+        if old[i].Name != new[i].Name || old[i].IsEnabled() != new[i].IsEnabled() ||
+            !reflect.DeepEqual(old[i].Settings, new[i].Settings) ||
+            !reflect.DeepEqual(old[i].External, new[i].External) {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,193 @@
+package config
+
+import (
+    "encoding"
+    "encoding/json"
+    "fmt"
+    "reflect"
+    "sort"
+    "strings"
+)
+
+// FieldChange is one value that differs between two configurations.
+// Before or After is nil when the value was added or removed. Secrets
+// appear as they marshal, so a changed literal secret shows Redacted on
+// both sides, and so do plugin settings, which may hold credentials the
+// config cannot tell apart from other values.
+type FieldChange struct {
+    Path   string      `json:"path"`
+    Before interface{} `json:"before"`
+    After  interface{} `json:"after"`
+}
+
+var (
+    textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+    settingsType      = reflect.TypeOf(map[string]interface{}(nil))
+)
+
+// RedactedDiff lists the leaf values that differ between old and new,
+// which must have the same type, keyed by their JSON path such as
+// "listeners[1].tls.cert_file". No secret value is ever included.
+func RedactedDiff(old, new interface{}) []FieldChange {
+    var changes []FieldChange
+    diffValue(&changes, "", reflect.ValueOf(old), reflect.ValueOf(new))
+    return changes
+}
+
+func diffValue(changes *[]FieldChange, path string, a, b reflect.Value) {
+    if !a.IsValid() || !b.IsValid() {
+        if a.IsValid() || b.IsValid() {
+            *changes = append(*changes, FieldChange{Path: path, Before: redacted(a), After: redacted(b)})
+        }
+        return
+    }
+
+    t := a.Type()
+    if t == secretType {
+        sa, sb := a.Interface().(Secret), b.Interface().(Secret)
+        if sa != sb {
+            *changes = append(*changes, FieldChange{Path: path, Before: sa.String(), After: sb.String()})
+        }
+        return
+    }
+    if t == settingsType {
+        diffSettings(changes, path, a, b)
+        return
+    }
+    if t.Implements(textMarshalerType) {
+        if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+            *changes = append(*changes, FieldChange{Path: path, Before: redacted(a), After: redacted(b)})
+        }
+        return
+    }
+
+    switch t.Kind() {
+    case reflect.Ptr, reflect.Interface:
+        if a.IsNil() || b.IsNil() {
+            if a.IsNil() != b.IsNil() {
+                *changes = append(*changes, FieldChange{Path: path, Before: redacted(a), After: redacted(b)})
+            }
+            return
+        }
+        ea, eb := a.Elem(), b.Elem()
+        if ea.Type() != eb.Type() {
+            *changes = append(*changes, FieldChange{Path: path, Before: redacted(a), After: redacted(b)})
+            return
+        }
+        diffValue(changes, path, ea, eb)
+    case reflect.Struct:
+        for i := 0; i < t.NumField(); i++ {
+            f := t.Field(i)
+            name := fieldName(f)
+            if name == "" {
+                continue
+            }
+            diffValue(changes, joinPath(path, name), a.Field(i), b.Field(i))
+        }
+    case reflect.Slice, reflect.Array:
+        n := a.Len()
+        if b.Len() > n {
+            n = b.Len()
+        }
+        for i := 0; i < n; i++ {
+            var ea, eb reflect.Value
+            if i < a.Len() {
+                ea = a.Index(i)
+            }
+            if i < b.Len() {
+                eb = b.Index(i)
+            }
+            diffValue(changes, fmt.Sprintf("%s[%d]", path, i), ea, eb)
+        }
+    case reflect.Map:
+        keys := make(map[string]reflect.Value)
+        for _, k := range a.MapKeys() {
+            keys[fmt.Sprint(k.Interface())] = k
+        }
+        for _, k := range b.MapKeys() {
+            keys[fmt.Sprint(k.Interface())] = k
+        }
+        names := make([]string, 0, len(keys))
+        for name := range keys {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+        for _, name := range names {
+            k := keys[name]
+            diffValue(changes, joinPath(path, name), a.MapIndex(k), b.MapIndex(k))
+        }
+    default:
+        if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+            *changes = append(*changes, FieldChange{Path: path, Before: a.Interface(), After: b.Interface()})
+        }
+    }
+}
+
+// diffSettings lists the keys of a free-form settings map whose values
+// differ, showing every value present as Redacted.
+func diffSettings(changes *[]FieldChange, path string, a, b reflect.Value) {
+    keys := make(map[string]bool)
+    for _, k := range a.MapKeys() {
+        keys[k.String()] = true
+    }
+    for _, k := range b.MapKeys() {
+        keys[k.String()] = true
+    }
+    names := make([]string, 0, len(keys))
+    for name := range keys {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    hidden := func(v reflect.Value) interface{} {
+        if !v.IsValid() {
+            return nil
+        }
+        return Redacted
+    }
+    for _, name := range names {
+        k := reflect.ValueOf(name)
+        va, vb := a.MapIndex(k), b.MapIndex(k)
+        if va.IsValid() && vb.IsValid() && reflect.DeepEqual(va.Interface(), vb.Interface()) {
+            continue
+        }
+        *changes = append(*changes, FieldChange{Path: joinPath(path, name), Before: hidden(va), After: hidden(vb)})
+    }
+}
+
+// redacted renders v the way the API shows it, which redacts secrets.
+func redacted(v reflect.Value) interface{} {
+    if !v.IsValid() {
+        return nil
+    }
+    data, err := json.Marshal(v.Interface())
+    if err != nil {
+        return nil
+    }
+    return json.RawMessage(data)
+}
+
+func fieldName(f reflect.StructField) string {
+    if f.PkgPath != "" {
+        return ""
+    }
+    tag := f.Tag.Get("json")
+    if tag == "" {
+        tag = f.Tag.Get("yaml")
+    }
+    name := strings.Split(tag, ",")[0]
+    switch name {
+    case "-":
+        return ""
+    case "":
+        return f.Name
+    }
+    return name
+}
+
+func joinPath(path, name string) string {
+    if path == "" {
+        return name
+    }
+    return path + "." + name
+}
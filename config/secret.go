@@ -0,0 +1,164 @@
+package config
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "reflect"
+    "strings"
+)
+
+// Redacted is what a literal secret is rendered as in API responses, logs
+// and marshalled config. Submitting it back leaves the secret unchanged.
+const Redacted = "********"
+
+const (
+    secretFilePrefix = "file:"
+    secretEnvPrefix  = "env:"
+)
+
+// Secret holds a sensitive value such as a password. In a config file it
+// is either the literal value or a reference, "file:/path" or "env:NAME",
+// resolved by ResolveSecrets. A Secret never marshals its value: literals
+// become Redacted and references are written out as the reference.
+type Secret struct {
+    ref   string
+    value string
+}
+
+func NewSecret(value string) Secret {
+    return Secret{value: value}
+}
+
+// Value returns the resolved secret.
+func (s Secret) Value() string {
+    return s.value
+}
+
+// Ref returns the file: or env: reference, or "" for literal secrets.
+func (s Secret) Ref() string {
+    return s.ref
+}
+
+func (s Secret) IsZero() bool {
+    return s.ref == "" && s.value == ""
+}
+
+func (s Secret) String() string {
+    if s.ref != "" {
+        return s.ref
+    }
+    if s.value == "" {
+        return ""
+    }
+    return Redacted
+}
+
+func (s Secret) MarshalText() ([]byte, error) {
+    return []byte(s.String()), nil
+}
+
+func (s *Secret) UnmarshalText(text []byte) error {
+    t := string(text)
+    if t == Redacted {
+        return nil
+    }
+    if strings.HasPrefix(t, secretFilePrefix) || strings.HasPrefix(t, secretEnvPrefix) {
+        *s = Secret{ref: t}
+        return nil
+    }
+    *s = Secret{value: t}
+    return nil
+}
+
+func (s Secret) MarshalYAML() (interface{}, error) {
+    return s.String(), nil
+}
+
+// resolve loads the value of a referenced secret. Literal secrets are
+// left as they are.
+func (s *Secret) resolve() error {
+    switch {
+    case strings.HasPrefix(s.ref, secretFilePrefix):
+        data, err := ioutil.ReadFile(strings.TrimPrefix(s.ref, secretFilePrefix))
+        if err != nil {
+            return err
+        }
+        s.value = strings.TrimRight(string(data), "\r\n")
+    case strings.HasPrefix(s.ref, secretEnvPrefix):
+        name := strings.TrimPrefix(s.ref, secretEnvPrefix)
+        v, ok := os.LookupEnv(name)
+        if !ok {
+            return fmt.Errorf("environment variable %s is not set", name)
+        }
+        s.value = v
+    }
+    return nil
+}
+
+func (s Secret) file() string {
+    if strings.HasPrefix(s.ref, secretFilePrefix) {
+        return strings.TrimPrefix(s.ref, secretFilePrefix)
+    }
+    return ""
+}
+
+var secretType = reflect.TypeOf(Secret{})
+
+// ResolveSecrets reads every file: and env: secret reference in the
+// configuration. Calling it again picks up rotated secrets.
+func (c *Config) ResolveSecrets() error {
+    var problems []string
+    walkSecrets(reflect.ValueOf(c).Elem(), "", func(path string, s *Secret) {
+        if err := s.resolve(); err != nil {
+            problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+        }
+    })
+    if len(problems) > 0 {
+        return &ValidationError{Problems: problems}
+    }
+    return nil
+}
+
+// SecretFiles returns the files referenced by file: secrets, so they can
+// be watched for rotation.
+func (c *Config) SecretFiles() []string {
+    var files []string
+    walkSecrets(reflect.ValueOf(c).Elem(), "", func(path string, s *Secret) {
+        if f := s.file(); f != "" {
+            files = append(files, f)
+        }
+    })
+    return files
+}
+
+// walkSecrets calls fn for every Secret reachable from v, with its YAML
+// path for error messages.
+func walkSecrets(v reflect.Value, path string, fn func(path string, s *Secret)) {
+    switch v.Kind() {
+    case reflect.Struct:
+        if v.Type() == secretType {
+            fn(path, v.Addr().Interface().(*Secret))
+            return
+        }
+        t := v.Type()
+        for i := 0; i < t.NumField(); i++ {
+            if t.Field(i).PkgPath != "" {
+                continue
+            }
+            name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+            if path != "" {
+                name = path + "." + name
+            }
+            walkSecrets(v.Field(i), name, fn)
+        }
+    case reflect.Slice:
+        for i := 0; i < v.Len(); i++ {
+            walkSecrets(v.Index(i), fmt.Sprintf("%s[%d]", path, i), fn)
+        }
+    case reflect.Ptr:
+        if !v.IsNil() {
+            walkSecrets(v.Elem(), path, fn)
+        }
+    }
+}
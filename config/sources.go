@@ -0,0 +1,163 @@
+package config
+
+import (
+    "encoding/json"
+    "fmt"
+    "net"
+    "path/filepath"
+    "strconv"
+    "strings"
+
+    "github.com/BurntSushi/toml"
+)
+
+// parse decodes data into a generic document according to the file
+// extension: .json (native or shadowsocks-libev), .toml, and YAML for
+// anything else.
+func parse(filename string, data []byte) (map[string]interface{}, error) {
+    switch strings.ToLower(filepath.Ext(filename)) {
+    case ".json":
+        var raw map[string]interface{}
+        if err := json.Unmarshal(data, &raw); err != nil {
+            return nil, err
+        }
+        if raw == nil {
+            raw = map[string]interface{}{}
+        }
+        if isLibev(raw) {
+            return fromLibev(raw)
+        }
+        return raw, nil
+    case ".toml":
+        var raw map[string]interface{}
+        if err := toml.Unmarshal(data, &raw); err != nil {
+            return nil, err
+        }
+        if raw == nil {
+            raw = map[string]interface{}{}
+        }
+        return raw, nil
+    default:
+        return parseYAML(data)
+    }
+}
+
+// isLibev reports whether a JSON document is a shadowsocks-libev
+// config.json rather than a native one.
+func isLibev(raw map[string]interface{}) bool {
+    _, ok := raw["server_port"]
+    return ok
+}
+
+// libevIgnored lists shadowsocks-libev keys that have no equivalent here
+// and can safely be dropped (client-side or OS tuning options).
+var libevIgnored = map[string]bool{
+    "local_address": true,
+    "local_port":    true,
+    "fast_open":     true,
+    "reuse_port":    true,
+    "no_delay":      true,
+    "nameserver":    true,
+    "ipv6_first":    true,
+    "workers":       true,
+    "user":          true,
+}
+
+// libevMethods maps shadowsocks-libev cipher names to ours.
+var libevMethods = map[string]string{
+    "aes-256-gcm":            "aes-256-gcm",
+    "chacha20-ietf-poly1305": "chacha20-poly1305",
+    "chacha20-poly1305":      "chacha20-poly1305",
+}
+
+// fromLibev translates a shadowsocks-libev config.json into a native
+// document with one shadowsocks listener per server address.
+func fromLibev(raw map[string]interface{}) (map[string]interface{}, error) {
+    var hosts []string
+    var port string
+    listener := map[string]interface{}{
+        "protocol": ProtocolShadowsocks,
+    }
+    out := map[string]interface{}{
+        "version": CurrentVersion,
+    }
+
+    for key, v := range raw {
+        switch key {
+        case "server":
+            switch t := v.(type) {
+            case string:
+                hosts = append(hosts, t)
+            case []interface{}:
+                for _, h := range t {
+                    s, ok := h.(string)
+                    if !ok {
+                        return nil, fmt.Errorf("libev config: invalid server %v", h)
+                    }
+                    hosts = append(hosts, s)
+                }
+            default:
+                return nil, fmt.Errorf("libev config: invalid server %v", v)
+            }
+        case "server_port":
+            n, ok := toInt(v)
+            if !ok {
+                return nil, fmt.Errorf("libev config: invalid server_port %v", v)
+            }
+            port = strconv.Itoa(n)
+        case "password":
+            listener["password"] = v
+        case "method":
+            name, _ := v.(string)
+            method, ok := libevMethods[name]
+            if !ok {
+                return nil, fmt.Errorf("libev config: unsupported method %q", name)
+            }
+            listener["method"] = method
+            out["method"] = method
+        case "timeout":
+            if _, ok := toInt(v); !ok {
+                return nil, fmt.Errorf("libev config: invalid timeout %v", v)
+            }
+            out["timeout"] = v
+        case "plugin", "plugin_opts":
+            listener[key] = v
+        case "mode":
+            switch v {
+            case "tcp_only":
+                listener["udp"] = false
+            case "tcp_and_udp":
+                listener["udp"] = true
+            default:
+                return nil, fmt.Errorf("libev config: unsupported mode %v", v)
+            }
+        default:
+            if !libevIgnored[key] {
+                return nil, fmt.Errorf("libev config: unsupported field %q", key)
+            }
+        }
+    }
+
+    if len(hosts) == 0 {
+        hosts = []string{"0.0.0.0"}
+    }
+    if port == "" {
+        return nil, fmt.Errorf("libev config: server_port is required")
+    }
+
+    var listeners []interface{}
+    for i, host := range hosts {
+        lc := make(map[string]interface{}, len(listener)+2)
+        for k, v := range listener {
+            lc[k] = v
+        }
+        lc["name"] = "shadowsocks"
+        if len(hosts) > 1 {
+            lc["name"] = fmt.Sprintf("shadowsocks-%d", i)
+        }
+        lc["address"] = net.JoinHostPort(host, port)
+        listeners = append(listeners, lc)
+    }
+    out["listeners"] = listeners
+    return out, nil
+}
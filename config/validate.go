@@ -0,0 +1,181 @@
+package config
+
+import (
+    "errors"
+    "fmt"
+    "net"
+    "reflect"
+    "strconv"
+    "strings"
+)
+
+// Change is a bit set describing which subsystems are affected by a
+// configuration update.
+type Change uint
+
+const (
+    ChangeListeners Change = 1 << iota
+    ChangeCiphers
+    ChangeUsers
+    ChangePlugins
+    ChangeAdmin
+)
+
+func (c Change) String() string {
+    var parts []string
+    if c&ChangeListeners != 0 {
+        parts = append(parts, "listeners")
+    }
+    if c&ChangeCiphers != 0 {
+        parts = append(parts, "ciphers")
+    }
+    if c&ChangeUsers != 0 {
+        parts = append(parts, "users")
+    }
+    if c&ChangePlugins != 0 {
+        parts = append(parts, "plugins")
+    }
+    if c&ChangeAdmin != 0 {
+        parts = append(parts, "admin")
+    }
+    if len(parts) == 0 {
+        return "none"
+    }
+    return strings.Join(parts, ",")
+}
+
+// Diff is the result of comparing two configurations.
+type Diff struct {
+    Fields  []string `json:"fields"`
+    Changes Change   `json:"-"`
+}
+
+func (d Diff) Empty() bool {
+    return len(d.Fields) == 0
+}
+
+type ValidationError struct {
+    Problems []string
+}
+
+func (e *ValidationError) Error() string {
+    return "invalid config: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate checks the configuration for values that cannot be applied.
+func (c *Config) Validate() error {
+    if c == nil {
+        return errors.New("config is nil")
+    }
+
+    var problems []string
+    if len(c.Listeners) == 0 {
+        if err := validateAddress(c.ServerAddress); err != nil {
+            problems = append(problems, fmt.Sprintf("server_address: %v", err))
+        }
+        if c.Password.Value() == "" {
+            problems = append(problems, "password: must not be empty")
+        }
+        if c.UseTLS {
+            if c.TLSCertFile == "" {
+                problems = append(problems, "tls_cert_file: required when use_tls is set")
+            }
+            if c.TLSKeyFile == "" {
+                problems = append(problems, "tls_key_file: required when use_tls is set")
+            }
+        }
+    }
+
+    if c.Version != CurrentVersion {
+        problems = append(problems, fmt.Sprintf("version: expected %d, got %d", CurrentVersion, c.Version))
+    }
+    if c.Method != "" && !supportedMethods[c.Method] {
+        problems = append(problems, fmt.Sprintf("method: unsupported method %q", c.Method))
+    }
+    if c.Timeout <= 0 {
+        problems = append(problems, "timeout: must be positive")
+    }
+    if c.UDP.Timeout <= 0 {
+        problems = append(problems, "udp.timeout: must be positive")
+    }
+    switch c.Log.Level {
+    case "debug", "info", "error":
+    default:
+        problems = append(problems, fmt.Sprintf("log.level: unknown level %q", c.Log.Level))
+    }
+
+    names := make(map[string]bool)
+    addrs := make(map[string]bool)
+    for i := range c.Listeners {
+        lc := &c.Listeners[i]
+        problems = append(problems, lc.validate()...)
+        if names[lc.Name] {
+            problems = append(problems, fmt.Sprintf("listeners[%s]: duplicate name", lc.Name))
+        }
+        // Listeners on the web admin port are told apart by path.
+        addr := lc.Address
+        if lc.SharesWebAdmin() {
+            addr = "web-admin" + lc.WebSocket.Path
+        }
+        if addrs[addr] {
+            problems = append(problems, fmt.Sprintf("listeners[%s]: address %s already in use", lc.Name, addr))
+        }
+        names[lc.Name] = true
+        addrs[addr] = true
+    }
+    problems = append(problems, validatePlugins(c.Plugins)...)
+    problems = append(problems, c.Admin.validate()...)
+
+    if len(problems) > 0 {
+        return &ValidationError{Problems: problems}
+    }
+    return nil
+}
+
+func validateAddress(addr string) error {
+    if addr == "" {
+        return errors.New("must not be empty")
+    }
+    _, port, err := net.SplitHostPort(addr)
+    if err != nil {
+        return err
+    }
+    n, err := strconv.Atoi(port)
+    if err != nil || n < 0 || n > 65535 {
+        return fmt.Errorf("invalid port %q", port)
+    }
+    return nil
+}
+
+// Compare reports which fields differ between old and new and which
+// subsystems need restarting as a result.
+func Compare(old, new *Config) Diff {
+    var d Diff
+    mark := func(field string, changed bool, c Change) {
+        if changed {
+            d.Fields = append(d.Fields, field)
+            d.Changes |= c
+        }
+    }
+
+    mark("server_address", old.ServerAddress != new.ServerAddress, ChangeListeners)
+    mark("use_tls", old.UseTLS != new.UseTLS, ChangeListeners)
+    mark("tls_cert_file", old.TLSCertFile != new.TLSCertFile, ChangeListeners)
+    mark("tls_key_file", old.TLSKeyFile != new.TLSKeyFile, ChangeListeners)
+    mark("udp.enabled", old.UDP.Enabled != new.UDP.Enabled, ChangeListeners)
+    mark("udp.timeout", old.UDP.Timeout != new.UDP.Timeout, 0)
+    mark("method", old.Method != new.Method, ChangeCiphers)
+    mark("timeout", old.Timeout != new.Timeout, ChangeListeners)
+    mark("log.level", old.Log.Level != new.Log.Level, 0)
+    mark("public_host", old.PublicHost != new.PublicHost, 0)
+    mark("store_file", old.StoreFile != new.StoreFile, 0)
+    mark("users_file", old.UsersFile != new.UsersFile, 0)
+    mark("password", old.Password != new.Password, ChangeCiphers|ChangeUsers)
+    for _, field := range compareListeners(old.Listeners, new.Listeners) {
+        mark(field, true, ChangeListeners|ChangeCiphers)
+    }
+    mark("plugins", comparePlugins(old.Plugins, new.Plugins), ChangePlugins)
+    mark("admin", !reflect.DeepEqual(old.Admin, new.Admin), ChangeAdmin)
+
+    return d
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+    "os"
+    "sync"
+    "time"
+)
+
+// Watcher polls a set of files and calls onChange when any of them is
+// modified, created or removed.
+type Watcher struct {
+    mu       sync.Mutex
+    files    []string
+    interval time.Duration
+    onChange func()
+    stop     chan struct{}
+}
+
+type fileState struct {
+    exists  bool
+    size    int64
+    modTime time.Time
+}
+
+func NewWatcher(interval time.Duration, onChange func(), files ...string) *Watcher {
+    return &Watcher{
+        files:    files,
+        interval: interval,
+        onChange: onChange,
+        stop:     make(chan struct{}),
+    }
+}
+
+// SetFiles replaces the set of watched files, e.g. after a reload changed
+// the secret files the configuration refers to.
+func (w *Watcher) SetFiles(files ...string) {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    w.files = files
+}
+
+func (w *Watcher) Start() {
+    go w.run()
+}
+
+func (w *Watcher) Stop() {
+    close(w.stop)
+}
+
+func (w *Watcher) run() {
+    states := w.snapshot()
+    ticker := time.NewTicker(w.interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-w.stop:
+            return
+        case <-ticker.C:
+            next := w.snapshot()
+            if changed(states, next) {
+                w.onChange()
+            }
+            states = next
+        }
+    }
+}
+
+func (w *Watcher) snapshot() map[string]fileState {
+    w.mu.Lock()
+    files := w.files
+    w.mu.Unlock()
+
+    states := make(map[string]fileState, len(files))
+    for _, name := range files {
+        info, err := os.Stat(name)
+        if err != nil {
+            states[name] = fileState{}
+            continue
+        }
+        states[name] = fileState{exists: true, size: info.Size(), modTime: info.ModTime()}
+    }
+    return states
+}
+
+// changed compares the files present in both snapshots; files that were
+// just added to or removed from the watch list do not count as changes.
+func changed(a, b map[string]fileState) bool {
+    for name, sa := range a {
+        if sb, ok := b[name]; ok && sa != sb {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+    "bufio"
+    "errors"
+    "flag"
+    "fmt"
+    "os"
+    "strings"
+
+    "golang.org/x/crypto/bcrypt"
+    "your_project/config"
+)
+
+// runConfigCommand implements `lunasocks config <subcommand>`.
+func runConfigCommand(args []string) int {
+    if len(args) == 0 {
+        fmt.Fprintln(os.Stderr, "usage: lunasocks config validate [-config file] | hash-password")
+        return 2
+    }
+
+    switch args[0] {
+    case "validate":
+        return validateConfig(args[1:])
+    case "hash-password":
+        return hashPassword()
+    default:
+        fmt.Fprintf(os.Stderr, "unknown config subcommand %q\n", args[0])
+        return 2
+    }
+}
+
+func validateConfig(args []string) int {
+    fs := flag.NewFlagSet("config validate", flag.ExitOnError)
+    configFile := fs.String("config", "config.yaml", "Path to configuration file")
+    fs.Parse(args)
+
+    cfg, err := config.LoadConfig(*configFile)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "%s: %v\n", *configFile, err)
+        return 1
+    }
+
+    if err := cfg.Validate(); err != nil {
+        var verr *config.ValidationError
+        if errors.As(err, &verr) {
+            fmt.Fprintf(os.Stderr, "%s: %d problem(s):\n", *configFile, len(verr.Problems))
+            for _, p := range verr.Problems {
+                fmt.Fprintf(os.Stderr, "  - %s\n", p)
+            }
+            return 1
+        }
+        fmt.Fprintf(os.Stderr, "%s: %v\n", *configFile, err)
+        return 1
+    }
+
+    fmt.Printf("%s: OK (schema version %d, %d listener(s))\n", *configFile, cfg.Version, len(cfg.Inbounds()))
+    return 0
+}
+
+// hashPassword reads a password from the first line of standard input and
+// prints its bcrypt hash for admin.accounts[].password_hash.
+func hashPassword() int {
+    line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+    password := strings.TrimRight(line, "\r\n")
+    if password == "" {
+        if err == nil {
+            err = errors.New("empty password")
+        }
+        fmt.Fprintf(os.Stderr, "reading password: %v\n", err)
+        return 1
+    }
+
+    hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "hashing password: %v\n", err)
+        return 1
+    }
+    fmt.Println(string(hash))
+    return 0
+}
@@ -1,6 +1,7 @@
 package crypto
 
 import (
+    "crypto/md5"
     "crypto/sha256"
     "golang.org/x/crypto/hkdf"
     "io"
@@ -19,3 +20,18 @@ func DeriveKey(password string, salt []byte, keySize int) ([]byte, error) {
 
     return key, nil
 }
+
+// EVPBytesToKey derives a keySize byte key from password the way
+// OpenSSL's EVP_BytesToKey does with MD5, one iteration and no salt,
+// which is how shadowsocks clients turn a password into a key.
+func EVPBytesToKey(password string, keySize int) []byte {
+    var key, prev []byte
+    for len(key) < keySize {
+        h := md5.New()
+        h.Write(prev)
+        h.Write([]byte(password))
+        prev = h.Sum(nil)
+        key = append(key, prev...)
+    }
+    return key[:keySize]
+}
@@ -0,0 +1,14 @@
+package crypto
+
+import (
+    "encoding/hex"
+    "testing"
+)
+
+func TestEVPBytesToKey(t *testing.T) {
+    // Key shadowsocks-libev derives from the password "foobar".
+    want := "3858f62230ac3c915f300c664312c63f568378529614d22ddb49237d2f60bfdf"
+    if got := hex.EncodeToString(EVPBytesToKey("foobar", 32)); got != want {
+        t.Errorf("EVPBytesToKey = %s, want %s", got, want)
+    }
+}
@@ -1,20 +1,41 @@
 package main
 
 import (
+    "context"
+    "errors"
     "flag"
     "log"
+    "os"
+    "os/signal"
+    "strings"
+    "syscall"
+    "time"
     "your_project/config"
+    "your_project/logging"
     "your_project/network"
-    "your_project/plugin"
+    "your_project/store"
+    "your_project/users"
     "your_project/web"
 )
 
 func main() {
+    // 서브커맨드 처리 (lunasocks config validate ..., lunasocks uri ...)
+    if len(os.Args) > 1 {
+        switch os.Args[1] {
+        case "config":
+            os.Exit(runConfigCommand(os.Args[2:]))
+        case "uri":
+            os.Exit(runURICommand(os.Args[2:]))
+        }
+    }
+
     // 명령줄 인자 처리
     configFile := flag.String("config", "config.yaml", "Path to configuration file")
     enableTLS := flag.Bool("tls", false, "Enable TLS")
     enableWebAdmin := flag.Bool("web-admin", false, "Enable web admin interface")
     webAdminPort := flag.Int("web-admin-port", 8080, "Web admin interface port")
+    shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "Time to wait for active connections on shutdown")
+    watchInterval := flag.Duration("watch-config", 0, "Poll the config file for changes at this interval (0 disables)")
     flag.Parse()
 
     // 설정 파일 로드
@@ -23,13 +44,48 @@ func main() {
         log.Fatalf("Failed to load config: %v", err)
     }
 
+    logging.SetLogLevel(cfg.Log.Level)
+
     // TLS 설정 적용
     if *enableTLS {
         cfg.UseTLS = true
     }
 
+    // 설정 관리자 생성 (검증 및 핫 리로드)
+    manager, err := config.NewManager(cfg)
+    if err != nil {
+        log.Fatalf("Invalid config: %v", err)
+    }
+
     // 서버 인스턴스 생성
-    server := network.NewServer(cfg)
+    server := network.NewServer(manager.Current())
+    manager.Subscribe("server", server)
+    manager.Subscribe("logging", config.ApplierFunc(func(old, new *config.Config, diff config.Diff) error {
+        logging.SetLogLevel(new.Log.Level)
+        return nil
+    }))
+
+    // 런타임 상태 저장소 (사용자, 트래픽 카운터, 차단 목록)
+    st, err := store.OpenFile(manager.Current().StoreFile)
+    if err != nil {
+        log.Fatalf("Failed to open store: %v", err)
+    }
+    defer st.Close()
+    if err := server.SetStore(st); err != nil {
+        log.Fatalf("Failed to load server state: %v", err)
+    }
+
+    // 관리 사용자 (/api/users) 불러오기
+    userManager, err := users.New(st)
+    if err != nil {
+        log.Fatalf("Failed to load users: %v", err)
+    }
+    if n, err := userManager.ImportFile(manager.Current().UsersFile); err != nil {
+        log.Fatalf("Failed to import users: %v", err)
+    } else if n > 0 {
+        log.Printf("Imported %d users from %s", n, manager.Current().UsersFile)
+    }
+    server.SetUsers(userManager)
 
     // TLS 설정 (설정에서 활성화된 경우)
     if cfg.UseTLS {
@@ -39,13 +95,66 @@ func main() {
         }
     }
 
-    // 플러그인 추가
-    server.AddPlugin(&plugin.LoggingPlugin{})
-    // 추가 플러그인은 여기에 구현
+    // 설정 리로드 (SIGHUP 및 설정/비밀 파일 변경 감지)
+    var watcher *config.Watcher
+    watchedFiles := func() []string {
+        return append([]string{*configFile}, manager.Current().SecretFiles()...)
+    }
+    // 웹 관리로 바꾼 설정은 메모리에만 있다. 파일 변경 감지로 인한 리로드는
+    // 그 변경을 되돌리게 되면 거부하고, 명시적인 SIGHUP 은 적용하되 무엇이
+    // 버려졌는지 남긴다.
+    reload := func(reason string, force bool) {
+        next, err := config.LoadConfig(*configFile)
+        if err != nil {
+            log.Printf("Config reload (%s) failed: %v", reason, err)
+            return
+        }
+        if *enableTLS {
+            next.UseTLS = true
+        }
+        diff, discarded, err := manager.Reload(next, force)
+        var edits *config.EditsError
+        if errors.As(err, &edits) {
+            log.Printf("Config reload (%s) refused: %s would revert changes made through the web admin to %s; copy them into the file or send SIGHUP to reload anyway",
+                reason, *configFile, strings.Join(edits.Fields, ", "))
+            return
+        }
+        if err != nil {
+            log.Printf("Config reload (%s) failed: %v", reason, err)
+            return
+        }
+        if len(discarded) > 0 {
+            log.Printf("Config reload (%s) discarded changes made through the web admin to %s", reason, strings.Join(discarded, ", "))
+        }
+        if watcher != nil {
+            watcher.SetFiles(watchedFiles()...)
+        }
+        if diff.Empty() {
+            log.Printf("Config reload (%s): no changes", reason)
+            return
+        }
+        log.Printf("Config reloaded (%s): changed %s; affected %s", reason, strings.Join(diff.Fields, ", "), diff.Changes)
+    }
+
+    if *watchInterval > 0 {
+        watcher = config.NewWatcher(*watchInterval, func() { reload("file change", false) }, watchedFiles()...)
+        watcher.Start()
+        defer watcher.Stop()
+    }
+
+    hup := make(chan os.Signal, 1)
+    signal.Notify(hup, syscall.SIGHUP)
+    go func() {
+        for range hup {
+            reload("SIGHUP", true)
+        }
+    }()
 
     // 웹 관리 인터페이스 활성화 (명령줄 인자로 지정된 경우)
+    var webServer *web.WebServer
     if *enableWebAdmin {
-        webServer := web.NewWebServer(cfg, server, *webAdminPort)
+        webServer = web.NewWebServer(manager, server, *webAdminPort)
+        webServer.SetUsers(userManager)
         go func() {
             if err := webServer.Start(); err != nil {
                 log.Fatalf("Web admin interface failed to start: %v", err)
@@ -54,7 +163,75 @@ func main() {
     }
 
     // 서버 시작
-    if err := server.Start(); err != nil {
-        log.Fatalf("Server failed to start: %v", err)
+    errCh := make(chan error, 1)
+    go func() {
+        errCh <- server.Start()
+    }()
+
+    // 종료 시그널 대기 (SIGINT/SIGTERM), SIGUSR2 는 무중단 업그레이드
+    stop := make(chan os.Signal, 1)
+    signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+    upgrade := make(chan os.Signal, 1)
+    signal.Notify(upgrade, syscall.SIGUSR2)
+
+wait:
+    for {
+        select {
+        case err := <-errCh:
+            if err != nil {
+                log.Fatalf("Server failed to start: %v", err)
+            }
+            return
+        case sig := <-stop:
+            log.Printf("Received %s, shutting down (timeout %s)", sig, *shutdownTimeout)
+            break wait
+        case <-upgrade:
+            // 새 프로세스가 시작하면서 스토어를 열기 때문에 그 전에 저장을
+            // 멈춘다. 두 프로세스가 동시에 쓰지 않도록 업그레이드가 성공하면
+            // 이 프로세스는 다시 쓰지 않는다.
+            if err := detachStore(server, userManager); err != nil {
+                log.Printf("Upgrade failed, continuing to serve: %v", err)
+                continue
+            }
+            ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+            child, err := server.Upgrade(ctx)
+            cancel()
+            if err != nil {
+                server.AttachStore()
+                userManager.AttachStore()
+                log.Printf("Upgrade failed, continuing to serve: %v", err)
+                continue
+            }
+            st.Close()
+            log.Printf("Handed listeners to process %d, draining (timeout %s)", child.Pid, *shutdownTimeout)
+            break wait
+        }
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+    defer cancel()
+
+    if webServer != nil {
+        webServer.Shutdown(ctx)
+    }
+    if err := server.Shutdown(ctx); err != nil {
+        log.Printf("Forced shutdown: %v", err)
+    }
+    if err := userManager.Flush(); err != nil {
+        log.Printf("Failed to save user traffic counters: %v", err)
+    }
+    log.Printf("Server stopped")
+}
+
+// detachStore는 서버와 사용자 관리자가 스토어에 쓰는 것을 멈춘다. 둘 중
+// 하나라도 실패하면 원래대로 되돌린다.
+func detachStore(server *network.Server, userManager *users.Manager) error {
+    if err := server.DetachStore(); err != nil {
+        return err
+    }
+    if err := userManager.DetachStore(); err != nil {
+        server.AttachStore()
+        return err
     }
+    return nil
 }
@@ -0,0 +1,78 @@
+// Package mux multiplexes many streams over one connection, in the style
+// of smux and yamux. Every frame starts with an 8 byte header:
+//
+//	version(1) cmd(1) length(2) stream id(4)
+//
+// followed by length bytes of data. Streams opened by the client have odd
+// ids and streams opened by the server even ones. Each side may send at
+// most a window of unread data per stream; the reader returns credit with
+// UPD frames as the application consumes it.
+package mux
+
+import (
+    "encoding/binary"
+    "errors"
+    "io"
+)
+
+const version = 1
+
+// Frame commands.
+const (
+    cmdSYN byte = iota // open a stream
+    cmdFIN             // close a stream
+    cmdPSH             // stream data
+    cmdNOP             // keepalive
+    cmdUPD             // return window credit; data is a uint32
+)
+
+const headerSize = 8
+
+// initialWindow is the credit each side starts with on a new stream. A
+// reader with a larger buffer grants the rest as soon as the stream opens.
+const initialWindow = 256 << 10
+
+var errProtocol = errors.New("mux: protocol error")
+
+type frame struct {
+    cmd  byte
+    sid  uint32
+    data []byte
+}
+
+func (f frame) encode() []byte {
+    buf := make([]byte, headerSize+len(f.data))
+    buf[0] = version
+    buf[1] = f.cmd
+    binary.BigEndian.PutUint16(buf[2:], uint16(len(f.data)))
+    binary.BigEndian.PutUint32(buf[4:], f.sid)
+    copy(buf[headerSize:], f.data)
+    return buf
+}
+
+func readFrame(r io.Reader) (frame, error) {
+    var header [headerSize]byte
+    if _, err := io.ReadFull(r, header[:]); err != nil {
+        return frame{}, err
+    }
+    if header[0] != version || header[1] > cmdUPD {
+        return frame{}, errProtocol
+    }
+    f := frame{
+        cmd: header[1],
+        sid: binary.BigEndian.Uint32(header[4:]),
+    }
+    if n := binary.BigEndian.Uint16(header[2:]); n > 0 {
+        f.data = make([]byte, n)
+        if _, err := io.ReadFull(r, f.data); err != nil {
+            return frame{}, err
+        }
+    }
+    return f, nil
+}
+
+func windowUpdate(sid uint32, credit uint32) frame {
+    data := make([]byte, 4)
+    binary.BigEndian.PutUint32(data, credit)
+    return frame{cmd: cmdUPD, sid: sid, data: data}
+}
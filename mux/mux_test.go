@@ -0,0 +1,113 @@
+package mux
+
+import (
+    "bytes"
+    "crypto/rand"
+    "io"
+    "net"
+    "sync"
+    "testing"
+    "time"
+)
+
+func testConfig() Config {
+    cfg := DefaultConfig()
+    cfg.MaxStreamBuffer = initialWindow
+    cfg.MaxStreams = 4
+    return cfg
+}
+
+func pair(t *testing.T, cfg Config) (*Session, *Session) {
+    a, b := net.Pipe()
+    client, err := Client(a, cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    server, err := Server(b, cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return client, server
+}
+
+func TestStreamsAndFlowControl(t *testing.T) {
+    client, server := pair(t, testConfig())
+    defer client.Close()
+    defer server.Close()
+
+    go func() {
+        for {
+            st, err := server.AcceptStream()
+            if err != nil {
+                return
+            }
+            go func() {
+                defer st.Close()
+                io.Copy(st, st)
+            }()
+        }
+    }()
+
+    // Each stream sends several windows' worth so writers have to wait
+    // for credit.
+    payload := make([]byte, 3*initialWindow+123)
+    rand.Read(payload)
+
+    var wg sync.WaitGroup
+    for i := 0; i < 4; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            st, err := client.OpenStream()
+            if err != nil {
+                t.Error(err)
+                return
+            }
+            defer st.Close()
+            go st.Write(payload)
+            got := make([]byte, len(payload))
+            if _, err := io.ReadFull(st, got); err != nil {
+                t.Error(err)
+                return
+            }
+            if !bytes.Equal(got, payload) {
+                t.Error("payload mismatch")
+            }
+        }()
+    }
+    wg.Wait()
+}
+
+func TestMaxStreams(t *testing.T) {
+    client, server := pair(t, testConfig())
+    defer client.Close()
+    defer server.Close()
+
+    for i := 0; i < 4; i++ {
+        if _, err := client.OpenStream(); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if _, err := client.OpenStream(); err != ErrTooManyStreams {
+        t.Fatalf("fifth stream: got %v, want ErrTooManyStreams", err)
+    }
+}
+
+func TestKeepAliveTimeout(t *testing.T) {
+    cfg := testConfig()
+    cfg.KeepAliveInterval = 10 * time.Millisecond
+    cfg.KeepAliveTimeout = 50 * time.Millisecond
+
+    // The peer drains what we send but never answers.
+    a, b := net.Pipe()
+    go io.Copy(io.Discard, b)
+    defer b.Close()
+
+    sess, err := Client(a, cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := sess.AcceptStream(); err != ErrKeepAliveTimeout {
+        t.Fatalf("got %v, want ErrKeepAliveTimeout", err)
+    }
+}
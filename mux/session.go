@@ -0,0 +1,284 @@
+package mux
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "net"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+var (
+    // ErrSessionClosed is returned by operations on a closed session.
+    ErrSessionClosed = errors.New("mux: session closed")
+    // ErrTooManyStreams is returned by OpenStream when the session already
+    // carries MaxStreams streams.
+    ErrTooManyStreams = errors.New("mux: too many streams")
+    // ErrKeepAliveTimeout closes a session whose peer stopped answering.
+    ErrKeepAliveTimeout = errors.New("mux: keepalive timeout")
+)
+
+// Config tunes a session. Both ends should use the same values.
+type Config struct {
+    // KeepAliveInterval is how often a NOP is sent; KeepAliveTimeout how
+    // long the session may go without receiving any frame.
+    KeepAliveInterval time.Duration
+    KeepAliveTimeout  time.Duration
+    // MaxFrameSize bounds the data carried by one frame.
+    MaxFrameSize int
+    // MaxStreamBuffer is the per-stream receive window.
+    MaxStreamBuffer int
+    // MaxStreams bounds the streams open on a session at once.
+    MaxStreams int
+}
+
+// DefaultConfig returns the configuration used when none is given.
+func DefaultConfig() Config {
+    return Config{
+        KeepAliveInterval: 10 * time.Second,
+        KeepAliveTimeout:  30 * time.Second,
+        MaxFrameSize:      32 << 10,
+        MaxStreamBuffer:   1 << 20,
+        MaxStreams:        256,
+    }
+}
+
+// Validate reports a configuration a session cannot run with.
+func (c Config) Validate() error {
+    switch {
+    case c.KeepAliveInterval <= 0:
+        return errors.New("mux: keepalive interval must be positive")
+    case c.KeepAliveTimeout < c.KeepAliveInterval:
+        return errors.New("mux: keepalive timeout must not be shorter than the interval")
+    case c.MaxFrameSize <= 0 || c.MaxFrameSize > 65535:
+        return fmt.Errorf("mux: max frame size must be between 1 and 65535")
+    case c.MaxStreamBuffer < initialWindow:
+        return fmt.Errorf("mux: max stream buffer must be at least %d", initialWindow)
+    case c.MaxStreams <= 0:
+        return errors.New("mux: max streams must be positive")
+    }
+    return nil
+}
+
+// Session multiplexes streams over a single connection.
+type Session struct {
+    conn net.Conn
+    cfg  Config
+
+    mu      sync.Mutex
+    streams map[uint32]*Stream
+    nextID  uint32
+    accept  chan *Stream
+
+    wmu sync.Mutex
+
+    die     chan struct{}
+    dieOnce sync.Once
+    err     error
+
+    lastRecv int64 // unix nanoseconds, accessed atomically
+}
+
+// Client starts the opening side of a session on conn.
+func Client(conn net.Conn, cfg Config) (*Session, error) {
+    return newSession(conn, cfg, 1)
+}
+
+// Server starts the accepting side of a session on conn.
+func Server(conn net.Conn, cfg Config) (*Session, error) {
+    return newSession(conn, cfg, 2)
+}
+
+func newSession(conn net.Conn, cfg Config, firstID uint32) (*Session, error) {
+    if err := cfg.Validate(); err != nil {
+        return nil, err
+    }
+    s := &Session{
+        conn:     conn,
+        cfg:      cfg,
+        streams:  make(map[uint32]*Stream),
+        nextID:   firstID,
+        accept:   make(chan *Stream, cfg.MaxStreams),
+        die:      make(chan struct{}),
+        lastRecv: time.Now().UnixNano(),
+    }
+    go s.recvLoop()
+    go s.keepalive()
+    return s, nil
+}
+
+// OpenStream opens a new stream to the peer.
+func (s *Session) OpenStream() (*Stream, error) {
+    s.mu.Lock()
+    if s.IsClosed() {
+        s.mu.Unlock()
+        return nil, ErrSessionClosed
+    }
+    if len(s.streams) >= s.cfg.MaxStreams {
+        s.mu.Unlock()
+        return nil, ErrTooManyStreams
+    }
+    id := s.nextID
+    s.nextID += 2
+    st := newStream(id, s)
+    s.streams[id] = st
+    s.mu.Unlock()
+
+    if err := s.writeFrame(frame{cmd: cmdSYN, sid: id}); err != nil {
+        s.removeStream(id)
+        return nil, err
+    }
+    if err := st.grantExtra(); err != nil {
+        s.removeStream(id)
+        return nil, err
+    }
+    return st, nil
+}
+
+// AcceptStream waits for the peer to open a stream.
+func (s *Session) AcceptStream() (*Stream, error) {
+    select {
+    case st := <-s.accept:
+        if err := st.grantExtra(); err != nil {
+            return nil, err
+        }
+        return st, nil
+    case <-s.die:
+        return nil, s.closeErr()
+    }
+}
+
+// NumStreams returns the number of open streams.
+func (s *Session) NumStreams() int {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return len(s.streams)
+}
+
+// IsClosed reports whether the session is closed.
+func (s *Session) IsClosed() bool {
+    select {
+    case <-s.die:
+        return true
+    default:
+        return false
+    }
+}
+
+// Close closes the session, its connection and all of its streams.
+func (s *Session) Close() error {
+    s.closeWith(ErrSessionClosed)
+    return nil
+}
+
+func (s *Session) closeWith(err error) {
+    s.dieOnce.Do(func() {
+        s.err = err
+        close(s.die)
+        s.conn.Close()
+    })
+}
+
+func (s *Session) closeErr() error {
+    <-s.die
+    return s.err
+}
+
+func (s *Session) removeStream(id uint32) {
+    s.mu.Lock()
+    delete(s.streams, id)
+    s.mu.Unlock()
+}
+
+func (s *Session) writeFrame(f frame) error {
+    if s.IsClosed() {
+        return s.closeErr()
+    }
+    s.wmu.Lock()
+    defer s.wmu.Unlock()
+    if _, err := s.conn.Write(f.encode()); err != nil {
+        s.closeWith(err)
+        return err
+    }
+    return nil
+}
+
+func (s *Session) recvLoop() {
+    for {
+        f, err := readFrame(s.conn)
+        if err != nil {
+            s.closeWith(err)
+            return
+        }
+        atomic.StoreInt64(&s.lastRecv, time.Now().UnixNano())
+
+        if err := s.handleFrame(f); err != nil {
+            s.closeWith(err)
+            return
+        }
+    }
+}
+
+func (s *Session) handleFrame(f frame) error {
+    switch f.cmd {
+    case cmdNOP:
+        return nil
+    case cmdSYN:
+        s.mu.Lock()
+        if _, ok := s.streams[f.sid]; ok {
+            s.mu.Unlock()
+            return errProtocol
+        }
+        if len(s.streams) >= s.cfg.MaxStreams {
+            s.mu.Unlock()
+            // Refuse the stream; the peer sees it closed right away.
+            go s.writeFrame(frame{cmd: cmdFIN, sid: f.sid})
+            return nil
+        }
+        st := newStream(f.sid, s)
+        s.streams[f.sid] = st
+        s.mu.Unlock()
+        s.accept <- st
+        return nil
+    }
+
+    s.mu.Lock()
+    st := s.streams[f.sid]
+    s.mu.Unlock()
+    if st == nil {
+        // Frames may still be in flight for a stream we closed.
+        return nil
+    }
+    switch f.cmd {
+    case cmdFIN:
+        st.remoteClose()
+    case cmdPSH:
+        return st.push(f.data)
+    case cmdUPD:
+        if len(f.data) != 4 {
+            return errProtocol
+        }
+        st.grant(binary.BigEndian.Uint32(f.data))
+    }
+    return nil
+}
+
+func (s *Session) keepalive() {
+    ticker := time.NewTicker(s.cfg.KeepAliveInterval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ticker.C:
+            last := time.Unix(0, atomic.LoadInt64(&s.lastRecv))
+            if time.Since(last) > s.cfg.KeepAliveTimeout {
+                s.closeWith(ErrKeepAliveTimeout)
+                return
+            }
+            s.writeFrame(frame{cmd: cmdNOP})
+        case <-s.die:
+            return
+        }
+    }
+}
@@ -0,0 +1,223 @@
+package mux
+
+import (
+    "bytes"
+    "io"
+    "net"
+    "os"
+    "sync"
+    "time"
+)
+
+// Stream is one multiplexed connection. It implements net.Conn.
+type Stream struct {
+    id   uint32
+    sess *Session
+
+    mu            sync.Mutex
+    buf           bytes.Buffer
+    recvWindow    int // bytes the peer may still send
+    unacked       int // bytes read but not yet returned as credit
+    sendWindow    int // bytes we may still send
+    finRecv       bool
+    closed        bool
+    readDeadline  time.Time
+    writeDeadline time.Time
+
+    readable chan struct{}
+    writable chan struct{}
+    die      chan struct{}
+}
+
+func newStream(id uint32, sess *Session) *Stream {
+    return &Stream{
+        id:         id,
+        sess:       sess,
+        recvWindow: initialWindow,
+        sendWindow: initialWindow,
+        readable:   make(chan struct{}, 1),
+        writable:   make(chan struct{}, 1),
+        die:        make(chan struct{}),
+    }
+}
+
+// ID returns the stream id.
+func (st *Stream) ID() uint32 { return st.id }
+
+// grantExtra hands the peer the part of our receive buffer beyond the
+// initial window.
+func (st *Stream) grantExtra() error {
+    extra := st.sess.cfg.MaxStreamBuffer - initialWindow
+    if extra == 0 {
+        return nil
+    }
+    st.mu.Lock()
+    st.recvWindow += extra
+    st.mu.Unlock()
+    return st.sess.writeFrame(windowUpdate(st.id, uint32(extra)))
+}
+
+func (st *Stream) Read(b []byte) (int, error) {
+    for {
+        st.mu.Lock()
+        if st.buf.Len() > 0 {
+            n, _ := st.buf.Read(b)
+            st.unacked += n
+            var credit int
+            if st.unacked >= st.sess.cfg.MaxStreamBuffer/2 {
+                credit, st.unacked = st.unacked, 0
+                st.recvWindow += credit
+            }
+            st.mu.Unlock()
+            if credit > 0 {
+                st.sess.writeFrame(windowUpdate(st.id, uint32(credit)))
+            }
+            return n, nil
+        }
+        finRecv, closed, deadline := st.finRecv, st.closed, st.readDeadline
+        st.mu.Unlock()
+
+        if finRecv {
+            return 0, io.EOF
+        }
+        if closed {
+            return 0, io.ErrClosedPipe
+        }
+        if err := st.wait(st.readable, deadline); err != nil {
+            return 0, err
+        }
+    }
+}
+
+func (st *Stream) Write(b []byte) (int, error) {
+    written := 0
+    for written < len(b) {
+        st.mu.Lock()
+        if st.closed || st.finRecv {
+            st.mu.Unlock()
+            return written, io.ErrClosedPipe
+        }
+        if st.sendWindow == 0 {
+            deadline := st.writeDeadline
+            st.mu.Unlock()
+            if err := st.wait(st.writable, deadline); err != nil {
+                return written, err
+            }
+            continue
+        }
+        n := len(b) - written
+        if n > st.sess.cfg.MaxFrameSize {
+            n = st.sess.cfg.MaxFrameSize
+        }
+        if n > st.sendWindow {
+            n = st.sendWindow
+        }
+        st.sendWindow -= n
+        st.mu.Unlock()
+
+        if err := st.sess.writeFrame(frame{cmd: cmdPSH, sid: st.id, data: b[written : written+n]}); err != nil {
+            return written, err
+        }
+        written += n
+    }
+    return written, nil
+}
+
+// wait blocks until ch is signalled, the stream or session dies, or the
+// deadline passes.
+func (st *Stream) wait(ch <-chan struct{}, deadline time.Time) error {
+    var timeout <-chan time.Time
+    if !deadline.IsZero() {
+        d := time.Until(deadline)
+        if d <= 0 {
+            return os.ErrDeadlineExceeded
+        }
+        timer := time.NewTimer(d)
+        defer timer.Stop()
+        timeout = timer.C
+    }
+    select {
+    case <-ch:
+        return nil
+    case <-st.die:
+        return nil
+    case <-st.sess.die:
+        return st.sess.closeErr()
+    case <-timeout:
+        return os.ErrDeadlineExceeded
+    }
+}
+
+// Close closes the stream in both directions.
+func (st *Stream) Close() error {
+    st.mu.Lock()
+    if st.closed {
+        st.mu.Unlock()
+        return nil
+    }
+    st.closed = true
+    close(st.die)
+    st.mu.Unlock()
+
+    st.sess.removeStream(st.id)
+    return st.sess.writeFrame(frame{cmd: cmdFIN, sid: st.id})
+}
+
+func (st *Stream) push(data []byte) error {
+    st.mu.Lock()
+    if len(data) > st.recvWindow {
+        st.mu.Unlock()
+        return errProtocol
+    }
+    st.recvWindow -= len(data)
+    st.buf.Write(data)
+    st.mu.Unlock()
+    notify(st.readable)
+    return nil
+}
+
+func (st *Stream) grant(credit uint32) {
+    st.mu.Lock()
+    st.sendWindow += int(credit)
+    st.mu.Unlock()
+    notify(st.writable)
+}
+
+func (st *Stream) remoteClose() {
+    st.mu.Lock()
+    st.finRecv = true
+    st.mu.Unlock()
+    notify(st.readable)
+    notify(st.writable)
+}
+
+func notify(ch chan struct{}) {
+    select {
+    case ch <- struct{}{}:
+    default:
+    }
+}
+
+func (st *Stream) LocalAddr() net.Addr  { return st.sess.conn.LocalAddr() }
+func (st *Stream) RemoteAddr() net.Addr { return st.sess.conn.RemoteAddr() }
+
+func (st *Stream) SetDeadline(t time.Time) error {
+    st.SetReadDeadline(t)
+    return st.SetWriteDeadline(t)
+}
+
+func (st *Stream) SetReadDeadline(t time.Time) error {
+    st.mu.Lock()
+    st.readDeadline = t
+    st.mu.Unlock()
+    notify(st.readable)
+    return nil
+}
+
+func (st *Stream) SetWriteDeadline(t time.Time) error {
+    st.mu.Lock()
+    st.writeDeadline = t
+    st.mu.Unlock()
+    notify(st.writable)
+    return nil
+}
@@ -0,0 +1,72 @@
+package network
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "net"
+    "net/http"
+
+    "golang.org/x/crypto/acme"
+    "golang.org/x/crypto/acme/autocert"
+    "your_project/config"
+)
+
+// acmeIssuer obtains and renews the certificates of one listener. It
+// answers TLS-ALPN-01 challenges through the listener's TLS config and,
+// if configured, HTTP-01 challenges on a plain HTTP server of its own.
+type acmeIssuer struct {
+    manager *autocert.Manager
+    http    *http.Server
+}
+
+// startACME hooks an ACME manager into tlsConfig.
+func startACME(ac config.ACMEConfig, tlsConfig *tls.Config) (*acmeIssuer, error) {
+    client := &acme.Client{DirectoryURL: ac.DirectoryURL}
+    if ac.CAFile != "" {
+        pemData, err := ioutil.ReadFile(ac.CAFile)
+        if err != nil {
+            return nil, err
+        }
+        pool := x509.NewCertPool()
+        if !pool.AppendCertsFromPEM(pemData) {
+            return nil, fmt.Errorf("%s: no certificates found", ac.CAFile)
+        }
+        client.HTTPClient = &http.Client{
+            Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+        }
+    }
+
+    a := &acmeIssuer{manager: &autocert.Manager{
+        Prompt:     autocert.AcceptTOS,
+        Cache:      autocert.DirCache(ac.CacheDir),
+        HostPolicy: autocert.HostWhitelist(ac.Domains...),
+        Email:      ac.Email,
+        Client:     client,
+    }}
+    tlsConfig.GetCertificate = a.manager.GetCertificate
+    tlsConfig.NextProtos = append(tlsConfig.NextProtos, acme.ALPNProto)
+
+    if ac.HTTPAddress != "" {
+        ln, err := net.Listen("tcp", ac.HTTPAddress)
+        if err != nil {
+            return nil, err
+        }
+        a.http = &http.Server{Handler: a.manager.HTTPHandler(nil)}
+        go func() {
+            if err := a.http.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+                log.Printf("ACME HTTP-01 server on %s: %v", ac.HTTPAddress, err)
+            }
+        }()
+    }
+    return a, nil
+}
+
+func (a *acmeIssuer) close() {
+    if a.http != nil {
+        a.http.Close()
+    }
+}
@@ -0,0 +1,74 @@
+package network
+
+import (
+    "context"
+    "net"
+    "sync"
+)
+
+// connTracker keeps the set of live connections so they can be drained on
+// shutdown and force-closed once the deadline passes.
+type connTracker struct {
+    mu      sync.Mutex
+    conns   map[net.Conn]struct{}
+    wg      sync.WaitGroup
+    closing bool
+}
+
+// add registers conn. It returns false once draining has started, in which
+// case the caller should drop the connection.
+func (t *connTracker) add(conn net.Conn) bool {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if t.closing {
+        return false
+    }
+    if t.conns == nil {
+        t.conns = make(map[net.Conn]struct{})
+    }
+    t.conns[conn] = struct{}{}
+    t.wg.Add(1)
+    return true
+}
+
+func (t *connTracker) remove(conn net.Conn) {
+    t.mu.Lock()
+    if _, ok := t.conns[conn]; ok {
+        delete(t.conns, conn)
+        t.wg.Done()
+    }
+    t.mu.Unlock()
+}
+
+func (t *connTracker) count() int {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    return len(t.conns)
+}
+
+// drain waits for all tracked connections to finish. If ctx expires first
+// the remaining connections are closed and ctx.Err() is returned.
+func (t *connTracker) drain(ctx context.Context) error {
+    t.mu.Lock()
+    t.closing = true
+    t.mu.Unlock()
+
+    done := make(chan struct{})
+    go func() {
+        t.wg.Wait()
+        close(done)
+    }()
+
+    select {
+    case <-done:
+        return nil
+    case <-ctx.Done():
+        t.mu.Lock()
+        for conn := range t.conns {
+            conn.Close()
+        }
+        t.mu.Unlock()
+        <-done
+        return ctx.Err()
+    }
+}
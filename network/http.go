@@ -0,0 +1,181 @@
+package network
+
+import (
+    "bufio"
+    "io"
+    "log"
+    "net"
+    "net/http"
+
+    "your_project/plugin"
+)
+
+// Hop-by-hop headers are meaningful only for a single connection and must
+// not be forwarded by a proxy (RFC 7230, section 6.1).
+var hopHeaders = []string{
+    "Connection",
+    "Proxy-Connection",
+    "Keep-Alive",
+    "Proxy-Authenticate",
+    "Proxy-Authorization",
+    "Te",
+    "Trailer",
+    "Transfer-Encoding",
+    "Upgrade",
+}
+
+var httpTransport = &http.Transport{
+    Proxy:               nil,
+    DialContext:         (&net.Dialer{Timeout: dialTimeout}).DialContext,
+    MaxIdleConnsPerHost: 4,
+}
+
+// handleHTTP serves an HTTP proxy connection: CONNECT requests are turned
+// into raw tunnels, anything else is forwarded to the absolute URI given in
+// the request line.
+func (s *Server) handleHTTP(ctx *plugin.ConnContext, in *inbound) {
+    conn := ctx.Conn
+    br := bufio.NewReader(conn)
+    authed := false
+
+    for {
+        req, err := http.ReadRequest(br)
+        if err != nil {
+            if err != io.EOF {
+                log.Printf("Error reading HTTP request: %v", err)
+            }
+            return
+        }
+
+        user, ok := s.checkProxyAuth(req, in)
+        if !ok {
+            resp := &http.Response{
+                StatusCode: http.StatusProxyAuthRequired,
+                ProtoMajor: 1,
+                ProtoMinor: 1,
+                Header:     http.Header{"Proxy-Authenticate": {`Basic realm="lunasocks"`}},
+            }
+            resp.Write(conn)
+            return
+        }
+        if !authed {
+            if user == "" {
+                user = ctx.Identity
+            }
+            if err := in.plugins.Auth(ctx, user); err != nil {
+                log.Printf("HTTP proxy connection %v", err)
+                writeHTTPStatus(conn, http.StatusForbidden)
+                return
+            }
+            authed = true
+        }
+
+        if req.Method == http.MethodConnect {
+            s.tunnelHTTP(ctx, in, br, req)
+            return
+        }
+
+        if !s.forwardHTTP(ctx, in, req) {
+            return
+        }
+    }
+}
+
+func (s *Server) tunnelHTTP(ctx *plugin.ConnContext, in *inbound, br *bufio.Reader, req *http.Request) {
+    conn := ctx.Conn
+    dest, err := s.dial(ctx, in, req.Host)
+    if err != nil {
+        log.Printf("Failed to connect to %s: %v", req.Host, err)
+        in.plugins.Error(ctx, err)
+        writeHTTPStatus(conn, http.StatusBadGateway)
+        return
+    }
+    defer dest.Close()
+
+    if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+        return
+    }
+
+    // The client may have pipelined data behind the CONNECT request.
+    if n := br.Buffered(); n > 0 {
+        buffered, _ := br.Peek(n)
+        if _, err := dest.Write(in.plugins.Data(ctx, plugin.Upstream, buffered)); err != nil {
+            return
+        }
+    }
+
+    relayHooks(ctx, in.plugins, dest)
+}
+
+// forwardHTTP proxies a single request and reports whether the client
+// connection can be reused for another one. OnDial may redirect the request
+// to another host; OnData does not see forwarded requests, which are not
+// relayed as raw bytes.
+func (s *Server) forwardHTTP(ctx *plugin.ConnContext, in *inbound, req *http.Request) bool {
+    conn := ctx.Conn
+    if req.URL.Host == "" {
+        writeHTTPStatus(conn, http.StatusBadRequest)
+        return false
+    }
+
+    host, err := in.plugins.Dial(ctx, req.URL.Host)
+    if err != nil {
+        log.Printf("Request to %s %v", req.URL.Host, err)
+        writeHTTPStatus(conn, http.StatusForbidden)
+        return false
+    }
+    req.URL.Host = host
+
+    req.RequestURI = ""
+    removeHopHeaders(req.Header)
+
+    resp, err := httpTransport.RoundTrip(req)
+    if err != nil {
+        log.Printf("Failed to forward request to %s: %v", req.URL.Host, err)
+        in.plugins.Error(ctx, err)
+        writeHTTPStatus(conn, http.StatusBadGateway)
+        return false
+    }
+    defer resp.Body.Close()
+
+    removeHopHeaders(resp.Header)
+    if err := resp.Write(conn); err != nil {
+        return false
+    }
+    return !req.Close && !resp.Close
+}
+
+// checkProxyAuth checks the request's credentials against the listener's
+// and, if it accepts them, the managed users'. It returns the user name,
+// which is empty when the listener requires no credentials.
+func (s *Server) checkProxyAuth(req *http.Request, in *inbound) (string, bool) {
+    if in.cfg.Username == "" && !in.cfg.Users {
+        return "", true
+    }
+    // Reuse the Basic credential parser of net/http on the proxy header.
+    r := &http.Request{Header: http.Header{"Authorization": req.Header["Proxy-Authorization"]}}
+    user, pass, ok := r.BasicAuth()
+    if !ok {
+        return "", false
+    }
+    if in.cfg.Username != "" && user == in.cfg.Username && pass == in.cfg.Password.Value() {
+        return user, true
+    }
+    return user, s.authenticateUser(in, user, pass)
+}
+
+func removeHopHeaders(h http.Header) {
+    for _, name := range hopHeaders {
+        h.Del(name)
+    }
+}
+
+func writeHTTPStatus(w io.Writer, code int) {
+    resp := &http.Response{
+        StatusCode: code,
+        ProtoMajor: 1,
+        ProtoMinor: 1,
+        Header:     http.Header{},
+    }
+    resp.Write(w)
+}
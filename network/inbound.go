@@ -0,0 +1,355 @@
+package network
+
+import (
+    "crypto/tls"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "reflect"
+    "time"
+    "your_project/config"
+    "your_project/mux"
+    "your_project/plugin"
+    "your_project/protocol"
+    "your_project/sip"
+    "your_project/transport"
+)
+
+const dialTimeout = 10 * time.Second
+
+// pluginBindTimeout bounds how long a listener waits for its SIP003
+// plugin to accept connections before it counts as serving.
+const pluginBindTimeout = 5 * time.Second
+
+// inbound is one running listener: its configuration, the sockets it is
+// bound to and the per-listener state its protocol handler needs.
+type inbound struct {
+    cfg     config.ListenerConfig
+    timeout time.Duration
+    b       *binding
+    plugins plugin.Chain
+    gen     int
+    ss      *protocol.Shadowsocks
+}
+
+// binding holds the sockets opened for one listener. tcp is the raw
+// listener underneath listener, kept for descriptor handoff on upgrade.
+// With a SIP003 plugin, tcp is a loopback port the plugin forwards to.
+type binding struct {
+    listener net.Listener
+    tcp      *net.TCPListener
+    udp      *net.UDPConn
+    plugin   *sip.Plugin
+    acme     *acmeIssuer
+}
+
+func (b *binding) close() {
+    if b.listener != nil {
+        b.listener.Close()
+    }
+    if b.udp != nil {
+        b.udp.Close()
+    }
+    if b.plugin != nil {
+        b.plugin.Stop()
+    }
+    if b.acme != nil {
+        b.acme.close()
+    }
+}
+
+func bind(lc config.ListenerConfig, certs *certCache) (*binding, error) {
+    if lc.SharesWebAdmin() {
+        // Connections arrive through ServeWebSocket.
+        return &binding{}, nil
+    }
+    obfs, native, err := transport.ParseObfsOpts(lc.Plugin, lc.PluginOpts)
+    if err != nil {
+        return nil, err
+    }
+    if lc.Plugin != "" && !native {
+        return bindPlugin(lc)
+    }
+    tcp, err := ListenTCP(lc.Address)
+    if err != nil {
+        return nil, err
+    }
+    b := &binding{listener: tcp, tcp: tcp}
+
+    if lc.TLS.Enabled {
+        tlsConfig, err := certs.serverTLSConfig(lc.TLS)
+        if err != nil {
+            tcp.Close()
+            return nil, err
+        }
+        if lc.TLS.ACME.Enabled {
+            if b.acme, err = startACME(lc.TLS.ACME, tlsConfig); err != nil {
+                tcp.Close()
+                return nil, err
+            }
+        }
+        b.listener = tls.NewListener(tcp, tlsConfig)
+    }
+    if native {
+        // simple-obfs is built in; no plugin process is needed.
+        b.listener = transport.ObfsListener(b.listener, obfs)
+    }
+    if lc.WebSocket.Enabled {
+        b.listener = transport.WebSocketListener(b.listener, lc.WebSocket.Path)
+    }
+
+    if lc.UDP {
+        b.udp, err = listenUDP(lc.Address)
+        if err != nil {
+            b.close()
+            return nil, err
+        }
+    }
+    return b, nil
+}
+
+// bindPlugin listens on a loopback port and starts the listener's SIP003
+// plugin on the configured address in front of it. UDP is not carried by
+// plugins and is bound directly.
+func bindPlugin(lc config.ListenerConfig) (*binding, error) {
+    tcp, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+    if err != nil {
+        return nil, err
+    }
+    b := &binding{listener: tcp, tcp: tcp}
+
+    if lc.UDP {
+        b.udp, err = listenUDP(lc.Address)
+        if err != nil {
+            tcp.Close()
+            return nil, err
+        }
+    }
+
+    b.plugin, err = sip.StartPlugin(sip.PluginConfig{
+        Plugin:  lc.Plugin,
+        Options: lc.PluginOpts,
+        Remote:  lc.Address,
+        Local:   tcp.Addr().String(),
+    })
+    if err != nil {
+        b.close()
+        return nil, err
+    }
+    // After an upgrade the parent has just released the address; make sure
+    // the plugin holds it before this process reports that it is serving.
+    if err := b.plugin.WaitListening(pluginBindTimeout); err != nil {
+        log.Printf("Warning: SIP003 plugin %s is not accepting on %s yet: %v", lc.Plugin, lc.Address, err)
+    }
+    return b, nil
+}
+
+// needsRebind reports whether moving from old to new requires new sockets.
+// Everything else is picked up by connections accepted after the change.
+func needsRebind(old, new config.ListenerConfig) bool {
+    return old.Address != new.Address || old.UDP != new.UDP || !reflect.DeepEqual(old.TLS, new.TLS) ||
+        old.Plugin != new.Plugin || old.PluginOpts != new.PluginOpts || old.WebSocket != new.WebSocket
+}
+
+// addr describes where the binding accepts connections.
+func (in *inbound) addr() string {
+    if in.b.listener == nil {
+        return "web-admin" + in.cfg.WebSocket.Path
+    }
+    return in.b.listener.Addr().String()
+}
+
+func (s *Server) inbound(name string) *inbound {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    return s.inbounds[name]
+}
+
+// reconcile brings the running listeners in line with cfg. It must be
+// called with s.mu held. On error some listeners may already have been
+// changed; callers restore them by reconciling against the previous
+// configuration, as ApplyConfig and EnableTLS do.
+func (s *Server) reconcile(cfg *config.Config) error {
+    want := cfg.Inbounds()
+
+    // Close listeners that are gone or must rebind before opening new
+    // ones, so that an address can move between listeners.
+    for name, in := range s.inbounds {
+        if lc, ok := cfg.Inbound(name); ok && !needsRebind(in.cfg, lc) {
+            continue
+        }
+        in.b.close()
+        delete(s.inbounds, name)
+        log.Printf("Listener %s on %s stopped", name, in.cfg.Address)
+    }
+
+    for _, lc := range want {
+        cur, ok := s.inbounds[lc.Name]
+        if ok && reflect.DeepEqual(cur.cfg, lc) && cur.timeout == cfg.Timeout.Duration() && cur.gen == s.pluginGen {
+            continue
+        }
+
+        in, err := s.newInbound(cfg, lc)
+        if err != nil {
+            return fmt.Errorf("listener %s: %w", lc.Name, err)
+        }
+        if ok {
+            in.b = cur.b
+            s.inbounds[lc.Name] = in
+            log.Printf("Listener %s on %s updated", lc.Name, lc.Address)
+            continue
+        }
+
+        in.b, err = bind(lc, &s.certs)
+        if err != nil {
+            return fmt.Errorf("listener %s: %w", lc.Name, err)
+        }
+        s.inbounds[lc.Name] = in
+        log.Printf("Listener %s (%s) started on %s", lc.Name, lc.Protocol, in.addr())
+
+        if in.b.listener != nil {
+            go s.serve(lc.Name, in.b.listener)
+        }
+        if in.b.udp != nil {
+            go s.handleUDP(lc.Name, in.b.udp)
+        }
+    }
+    return nil
+}
+
+func (s *Server) newInbound(cfg *config.Config, lc config.ListenerConfig) (*inbound, error) {
+    chain, err := s.chainFor(cfg, lc)
+    if err != nil {
+        return nil, err
+    }
+    in := &inbound{cfg: lc, timeout: cfg.Timeout.Duration(), plugins: chain, gen: s.pluginGen}
+
+    if lc.Protocol == config.ProtocolShadowsocks {
+        ss, err := protocol.NewShadowsocks(lc.Password.Value(), lc.Method, in.timeout)
+        if err != nil {
+            return nil, err
+        }
+        ss.SetHooks(in.plugins)
+        if lc.Mux.Disabled {
+            ss.DisableMux()
+        } else if err := ss.SetMux(muxConfig(lc.Mux)); err != nil {
+            return nil, err
+        }
+        if lc.Users {
+            ss.SetUsers(func() []protocol.UserCipher {
+                return s.shadowsocksUsers(lc)
+            })
+        }
+        if lc.UDPOverTCP {
+            ss.SetUDPOverTCP(func(ctx *plugin.ConnContext, conn net.Conn) {
+                s.relayUDPOverTCP(ctx, in, conn)
+            })
+        }
+        in.ss = ss
+    }
+    return in, nil
+}
+
+// muxConfig fills the fields mc leaves at zero with the defaults.
+func muxConfig(mc config.MuxConfig) mux.Config {
+    cfg := mux.DefaultConfig()
+    if mc.KeepAliveInterval > 0 {
+        cfg.KeepAliveInterval = mc.KeepAliveInterval.Duration()
+    }
+    if mc.KeepAliveTimeout > 0 {
+        cfg.KeepAliveTimeout = mc.KeepAliveTimeout.Duration()
+    }
+    if mc.MaxFrameSize > 0 {
+        cfg.MaxFrameSize = mc.MaxFrameSize
+    }
+    if mc.MaxStreamBuffer > 0 {
+        cfg.MaxStreamBuffer = mc.MaxStreamBuffer
+    }
+    if mc.MaxStreams > 0 {
+        cfg.MaxStreams = mc.MaxStreams
+    }
+    return cfg
+}
+
+func (s *Server) closeInbounds() {
+    for name, in := range s.inbounds {
+        in.b.close()
+        delete(s.inbounds, name)
+    }
+}
+
+func (s *Server) handleSocks5(ctx *plugin.ConnContext, in *inbound) {
+    var auth func(username, password string) bool
+    if in.cfg.Username != "" || in.cfg.Users {
+        auth = func(username, password string) bool {
+            listener := in.cfg.Username != "" && username == in.cfg.Username && password == in.cfg.Password.Value()
+            if !listener && !s.authenticateUser(in, username, password) {
+                return false
+            }
+            if err := in.plugins.Auth(ctx, username); err != nil {
+                log.Printf("SOCKS5 user %s %v", username, err)
+                return false
+            }
+            return true
+        }
+    }
+
+    addr, err := protocol.HandleSocks5WithAuth(ctx.Conn, auth)
+    if err != nil {
+        log.Printf("SOCKS5 handshake failed: %v", err)
+        in.plugins.Error(ctx, err)
+        return
+    }
+    if auth == nil {
+        if err := in.plugins.Auth(ctx, ctx.Identity); err != nil {
+            log.Printf("SOCKS5 connection %v", err)
+            return
+        }
+    }
+
+    dest, err := s.dial(ctx, in, addr)
+    if err != nil {
+        log.Printf("Failed to connect to %s: %v", addr, err)
+        in.plugins.Error(ctx, err)
+        return
+    }
+    defer dest.Close()
+
+    relayHooks(ctx, in.plugins, dest)
+}
+
+// dial passes addr through the OnDial hooks and connects to the result.
+func (s *Server) dial(ctx *plugin.ConnContext, in *inbound, addr string) (net.Conn, error) {
+    dest, err := in.plugins.Dial(ctx, addr)
+    if err != nil {
+        return nil, err
+    }
+    return net.DialTimeout("tcp", dest, dialTimeout)
+}
+
+// ServeWebSocket hands a WebSocket upgrade received by the web admin
+// server to the listener sharing its port on the request path. It reports
+// whether such a listener exists; if not, the request is left untouched.
+func (s *Server) ServeWebSocket(w http.ResponseWriter, r *http.Request) bool {
+    var in *inbound
+    s.mu.RLock()
+    for _, cand := range s.inbounds {
+        if cand.cfg.SharesWebAdmin() && cand.cfg.WebSocket.Path == r.URL.Path {
+            in = cand
+            break
+        }
+    }
+    s.mu.RUnlock()
+    if in == nil {
+        return false
+    }
+
+    conn, err := transport.UpgradeWebSocket(w, r)
+    if err != nil {
+        log.Printf("WebSocket upgrade on %s failed: %v", r.URL.Path, err)
+        return true
+    }
+    s.handleConnection(conn, in)
+    return true
+}
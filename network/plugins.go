@@ -0,0 +1,190 @@
+package network
+
+import (
+    "fmt"
+    "io"
+    "log"
+    "reflect"
+    "your_project/config"
+    "your_project/plugin"
+)
+
+// loadedPlugin is a plugin instance created from a config entry.
+type loadedPlugin struct {
+    cfg   config.PluginConfig
+    hooks plugin.Hooks
+}
+
+// loadPlugins instantiates the enabled plugins of cfg in order, keeping
+// instances whose settings did not change. The instances it drops are
+// returned rather than closed, so that the previous set can be put back
+// with restorePlugins if the rest of an update fails; pass them to
+// closePluginSet once it succeeded. It must be called with s.mu held.
+func (s *Server) loadPlugins(cfg *config.Config) ([]loadedPlugin, error) {
+    var loaded []loadedPlugin
+    kept := make(map[string]bool)
+    for _, pc := range cfg.Plugins {
+        if !pc.IsEnabled() {
+            continue
+        }
+        if lp, ok := s.findLoaded(pc.Name); ok && reflect.DeepEqual(lp.cfg.Settings, pc.Settings) &&
+            reflect.DeepEqual(lp.cfg.External, pc.External) {
+            loaded = append(loaded, lp)
+            kept[pc.Name] = true
+            continue
+        }
+        h, err := newPlugin(pc)
+        if err != nil {
+            for _, lp := range loaded {
+                if !kept[lp.cfg.Name] {
+                    closePlugin(lp.hooks)
+                }
+            }
+            return nil, err
+        }
+        loaded = append(loaded, loadedPlugin{cfg: pc, hooks: h})
+    }
+
+    var dropped []loadedPlugin
+    changed := len(loaded) != len(s.loaded)
+    for i, lp := range s.loaded {
+        if !kept[lp.cfg.Name] {
+            dropped = append(dropped, lp)
+            changed = true
+        } else if i >= len(loaded) || loaded[i].cfg.Name != lp.cfg.Name {
+            changed = true
+        }
+    }
+    s.loaded = loaded
+    if changed {
+        s.pluginGen++
+        log.Printf("Plugins loaded: %v", s.pluginNames())
+    }
+    return dropped, nil
+}
+
+// restorePlugins puts back the plugin set loadPlugins replaced, closing
+// the instances it created. It must be called with s.mu held.
+func (s *Server) restorePlugins(prev []loadedPlugin) {
+    for _, lp := range s.loaded {
+        if !containsPlugin(prev, lp.hooks) {
+            closePlugin(lp.hooks)
+        }
+    }
+    s.loaded = prev
+    s.pluginGen++
+}
+
+func containsPlugin(set []loadedPlugin, h plugin.Hooks) bool {
+    for _, lp := range set {
+        if lp.hooks == h {
+            return true
+        }
+    }
+    return false
+}
+
+func closePluginSet(set []loadedPlugin) {
+    for _, lp := range set {
+        closePlugin(lp.hooks)
+    }
+}
+
+// newPlugin creates a registered plugin, or starts an external one.
+func newPlugin(pc config.PluginConfig) (plugin.Hooks, error) {
+    ext := pc.External
+    if ext == nil {
+        return plugin.New(pc.Name, pc.Settings)
+    }
+    return plugin.NewExternal(plugin.ExternalConfig{
+        Name:     pc.Name,
+        Command:  ext.Command,
+        Args:     ext.Args,
+        Socket:   ext.Socket,
+        Timeout:  ext.Timeout.Duration(),
+        FailOpen: ext.FailOpen,
+        Settings: pc.Settings,
+    })
+}
+
+func (s *Server) findLoaded(name string) (loadedPlugin, bool) {
+    for _, lp := range s.loaded {
+        if lp.cfg.Name == name {
+            return lp, true
+        }
+    }
+    return loadedPlugin{}, false
+}
+
+// chainFor returns the plugins a listener runs: the ones it names, or all
+// of them if it names none. Plugins added with AddHooks come first. A
+// listener naming a plugin that is configured but disabled runs without it.
+func (s *Server) chainFor(cfg *config.Config, lc config.ListenerConfig) (plugin.Chain, error) {
+    if len(lc.Plugins) == 0 {
+        chain := append(plugin.Chain(nil), s.plugins...)
+        for _, lp := range s.loaded {
+            chain = append(chain, lp.hooks)
+        }
+        return chain, nil
+    }
+
+    var chain plugin.Chain
+    for _, name := range lc.Plugins {
+        if lp, ok := s.findLoaded(name); ok {
+            chain = append(chain, lp.hooks)
+            continue
+        }
+        if pc, ok := cfg.Plugin(name); ok && !pc.IsEnabled() {
+            continue
+        }
+        h := s.findPlugin(name)
+        if h == nil {
+            return nil, fmt.Errorf("unknown plugin %q", name)
+        }
+        chain = append(chain, h)
+    }
+    return chain, nil
+}
+
+func (s *Server) findPlugin(name string) plugin.Hooks {
+    for _, p := range s.plugins {
+        if p.Name() == name {
+            return p
+        }
+    }
+    return nil
+}
+
+func (s *Server) pluginNames() []string {
+    names := make([]string, 0, len(s.plugins)+len(s.loaded))
+    for _, p := range s.plugins {
+        names = append(names, p.Name())
+    }
+    for _, lp := range s.loaded {
+        names = append(names, lp.cfg.Name)
+    }
+    return names
+}
+
+// Plugins returns the names of the plugins currently loaded, in order.
+// Plugins created from the config are listed by their config name.
+func (s *Server) Plugins() []string {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    return s.pluginNames()
+}
+
+func (s *Server) closePlugins() {
+    for _, lp := range s.loaded {
+        closePlugin(lp.hooks)
+    }
+    s.loaded = nil
+}
+
+func closePlugin(h plugin.Hooks) {
+    if c, ok := h.(io.Closer); ok {
+        if err := c.Close(); err != nil {
+            log.Printf("Error closing plugin %s: %v", h.Name(), err)
+        }
+    }
+}
@@ -0,0 +1,71 @@
+package network
+
+import (
+    "io"
+    "net"
+
+    "your_project/plugin"
+)
+
+// relay copies data between a and b in both directions until either side
+// is done, then closes both so the other direction unblocks. It returns
+// how many bytes went from a to b and from b to a.
+func relay(a, b net.Conn) (ab, ba int64) {
+    done := make(chan struct{}, 2)
+    go func() {
+        ab, _ = io.Copy(b, a)
+        done <- struct{}{}
+    }()
+    go func() {
+        ba, _ = io.Copy(a, b)
+        done <- struct{}{}
+    }()
+
+    <-done
+    a.Close()
+    b.Close()
+    <-done
+    return ab, ba
+}
+
+// relayHooks relays between the client of ctx and dest, passing the data
+// through the streaming plugins and every chunk through the OnData hooks.
+// When no plugin does either it is a plain relay between the raw sockets,
+// which lets io.Copy use the kernel's zero-copy paths.
+func relayHooks(ctx *plugin.ConnContext, hooks plugin.Chain, dest net.Conn) {
+    inspect := hooks.InspectsData()
+    if !inspect && !hooks.Transforms(ctx) {
+        ctx.AddBytes(relay(ctx.Unwrap(), dest))
+        return
+    }
+
+    done := make(chan struct{}, 2)
+    copyHalf := func(dst, src net.Conn, dir plugin.Direction) {
+        r, w := hooks.Wrap(ctx, dir, src, dst)
+        buf := make([]byte, 32*1024)
+        for {
+            n, err := r.Read(buf)
+            if n > 0 {
+                data := buf[:n]
+                if inspect {
+                    data = hooks.Data(ctx, dir, data)
+                }
+                if _, err := w.Write(data); err != nil {
+                    break
+                }
+            }
+            if err != nil {
+                break
+            }
+        }
+        done <- struct{}{}
+    }
+
+    go copyHalf(dest, ctx.Conn, plugin.Upstream)
+    go copyHalf(ctx.Conn, dest, plugin.Downstream)
+
+    <-done
+    ctx.Conn.Close()
+    dest.Close()
+    <-done
+}
@@ -0,0 +1,140 @@
+package network
+
+import (
+    "io"
+    "net"
+    "sync/atomic"
+    "testing"
+
+    "your_project/config"
+    "your_project/plugin"
+    "your_project/store"
+    "your_project/users"
+)
+
+// chunkCounter counts the chunks OnData sees; with skip set it claims not
+// to look at them.
+type chunkCounter struct {
+    plugin.BaseHooks
+    skip   bool
+    chunks int64
+}
+
+func (c *chunkCounter) Name() string    { return "chunk-counter" }
+func (c *chunkCounter) SkipsData() bool { return c.skip }
+
+func (c *chunkCounter) OnData(ctx *plugin.ConnContext, dir plugin.Direction, data []byte) []byte {
+    atomic.AddInt64(&c.chunks, 1)
+    return data
+}
+
+func TestRelayHooksPath(t *testing.T) {
+    for _, skip := range []bool{true, false} {
+        counter := &chunkCounter{skip: skip}
+        client, server := net.Pipe()
+        dest, remote := net.Pipe()
+        done := make(chan struct{})
+        go func() {
+            relayHooks(plugin.NewConnContext(server, "test", "tcp"), plugin.Chain{counter}, dest)
+            close(done)
+        }()
+
+        go client.Write([]byte("hello"))
+        buf := make([]byte, 5)
+        if _, err := io.ReadFull(remote, buf); err != nil || string(buf) != "hello" {
+            t.Fatalf("Relayed %q, %v", buf, err)
+        }
+        client.Close()
+        <-done
+        remote.Close()
+
+        chunks := atomic.LoadInt64(&counter.chunks)
+        if skip && chunks != 0 {
+            t.Errorf("OnData was called %d times on the plain relay path", chunks)
+        }
+        if !skip && chunks == 0 {
+            t.Error("OnData was not called for a plugin that inspects data")
+        }
+    }
+}
+
+// TestDefaultChainTakesFastPath checks that the default plugins and the
+// users manager leave relays of users without limits on the plain path,
+// and that their traffic is still counted.
+func TestDefaultChainTakesFastPath(t *testing.T) {
+    m, err := users.New(store.NewMemory())
+    if err != nil {
+        t.Fatal(err)
+    }
+    m.Create(users.User{Name: "alice", Password: "alice-password"})
+    m.Create(users.User{Name: "bob", Password: "bob-password", RateLimit: 1 << 20})
+
+    cfg := config.Default()
+    s := NewServer(cfg)
+    s.SetUsers(m)
+    if _, err := s.loadPlugins(cfg); err != nil {
+        t.Fatal(err)
+    }
+    defer s.closePlugins()
+    chain, err := s.chainFor(cfg, config.ListenerConfig{Name: "test"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if chain.InspectsData() {
+        t.Error("Default plugins look at every chunk")
+    }
+
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer ln.Close()
+    pair := func() (net.Conn, net.Conn) {
+        c, err := net.Dial("tcp", ln.Addr().String())
+        if err != nil {
+            t.Fatal(err)
+        }
+        s, err := ln.Accept()
+        if err != nil {
+            t.Fatal(err)
+        }
+        return c, s
+    }
+    client, server := pair()
+    dest, remote := pair()
+
+    ctx := plugin.NewConnContext(server, "test", "socks5")
+    ctx.User = "bob"
+    if !chain.Transforms(ctx) {
+        t.Error("A rate limited user's relay is not wrapped")
+    }
+    ctx.User = "alice"
+    if chain.Transforms(ctx) {
+        t.Fatal("A user without limits is kept off the plain path")
+    }
+    if _, ok := ctx.Unwrap().(*net.TCPConn); !ok {
+        t.Fatalf("Unwrap returned %T, want the TCP connection", ctx.Unwrap())
+    }
+
+    done := make(chan struct{})
+    go func() {
+        relayHooks(ctx, chain, dest)
+        close(done)
+    }()
+    client.Write([]byte("hello"))
+    buf := make([]byte, 5)
+    if _, err := io.ReadFull(remote, buf); err != nil {
+        t.Fatal(err)
+    }
+    remote.Write([]byte("hi"))
+    if _, err := io.ReadFull(client, buf[:2]); err != nil {
+        t.Fatal(err)
+    }
+    client.Close()
+    <-done
+    remote.Close()
+
+    if st := ctx.Stats(); st.BytesUp != 5 || st.BytesDown != 2 {
+        t.Errorf("Plain relay counted %d up, %d down; want 5, 2", st.BytesUp, st.BytesDown)
+    }
+}
@@ -1,103 +1,269 @@
 package network
 
 import (
-    "crypto/tls"
+    "context"
     "encoding/binary"
     "errors"
     "io"
     "log"
     "net"
+    "sync"
     "time"
     "your_project/config"
     "your_project/plugin"
+    "your_project/users"
 )
 
 type Server struct {
+    mu       sync.RWMutex
     cfg      *config.Config
-    listener net.Listener
-    plugins  []plugin.Plugin
+    inbounds map[string]*inbound
+    started  bool
+    shared   map[string]*net.TCPListener
+    plugins  []plugin.Hooks
+    loaded   []loadedPlugin
+    conns    connTracker
+    certs    certCache
+    users    *users.Manager
+    ssUsers  userCiphers
+    state    serverState
+    done     chan struct{}
+    stopOnce sync.Once
+
+    // pluginGen changes whenever the set of plugins does, so reconcile
+    // knows to rebuild the listeners' plugin chains.
+    pluginGen int
 }
 
 func NewServer(cfg *config.Config) *Server {
     return &Server{
-        cfg: cfg,
+        cfg:      cfg,
+        inbounds: make(map[string]*inbound),
+        done:     make(chan struct{}),
     }
 }
 
+func (s *Server) config() *config.Config {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    return s.cfg
+}
+
+// EnableTLS turns on TLS for the listener described by the top-level
+// server fields. Listeners declared in the listeners list carry their own
+// TLS settings. The certificate is loaded once here and then shared with
+// the listener; if the server is running, the listener is rebound with
+// TLS right away.
 func (s *Server) EnableTLS(certFile, keyFile string) error {
-    cert, err := tls.LoadX509KeyPair(certFile, keyFile)
-    if err != nil {
+    if _, err := s.certs.load(certFile, keyFile); err != nil {
         return err
     }
-    s.cfg.UseTLS = true
-    s.cfg.TLSCertFile = certFile
-    s.cfg.TLSKeyFile = keyFile
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    cfg := s.cfg.Clone()
+    cfg.UseTLS = true
+    cfg.TLSCertFile = certFile
+    cfg.TLSKeyFile = keyFile
+    if s.started {
+        if err := s.reconcile(cfg); err != nil {
+            s.reconcile(s.cfg)
+            return err
+        }
+    }
+    s.cfg = cfg
     return nil
 }
 
+// AddPlugin registers a v1 plugin; see plugin.Adapt.
 func (s *Server) AddPlugin(p plugin.Plugin) {
-    s.plugins = append(s.plugins, p)
+    s.AddHooks(plugin.Adapt(p))
+}
+
+// AddHooks registers a plugin that is not created from the config. These
+// run before the configured plugins; add them before Start.
+func (s *Server) AddHooks(h plugin.Hooks) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.plugins = append(s.plugins, h)
+    s.pluginGen++
 }
 
 func (s *Server) Start() error {
-    var err error
-    if s.cfg.UseTLS {
-        cert, err := tls.LoadX509KeyPair(s.cfg.TLSCertFile, s.cfg.TLSKeyFile)
-        if err != nil {
-            return err
-        }
-        tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
-        s.listener, err = tls.Listen("tcp", s.cfg.ServerAddress, tlsConfig)
-    } else {
-        s.listener, err = net.Listen("tcp", s.cfg.ServerAddress)
+    s.mu.Lock()
+    s.started = true
+    if _, err := s.loadPlugins(s.cfg); err != nil {
+        s.started = false
+        s.mu.Unlock()
+        return err
     }
-    if err != nil {
+    if err := s.reconcile(s.cfg); err != nil {
+        s.closeInbounds()
+        s.closePlugins()
+        s.started = false
+        s.mu.Unlock()
         return err
     }
-    log.Printf("Server started on %s", s.cfg.ServerAddress)
-    defer s.listener.Close()
+    s.mu.Unlock()
+
+    notifyReady()
+    <-s.done
+    return nil
+}
+
+func (s *Server) serve(name string, ln net.Listener) {
+    defer ln.Close()
 
     for {
-        conn, err := s.listener.Accept()
+        conn, err := ln.Accept()
         if err != nil {
-            log.Printf("Error accepting connection: %v", err)
+            if errors.Is(err, net.ErrClosed) {
+                return
+            }
+            log.Printf("Error accepting connection on %s: %v", name, err)
+            continue
+        }
+
+        in := s.inbound(name)
+        if in == nil {
+            conn.Close()
             continue
         }
-        go s.handleConnection(conn)
+        go s.handleConnection(conn, in)
+    }
+}
+
+// ApplyConfig implements config.Applier. Listeners whose address, TLS or
+// UDP settings changed are rebound, removed ones are closed and new ones
+// are opened; connections that are already established keep running with
+// the settings they were accepted with. Plugins are reloaded when their
+// configuration changed. If any of it fails, the previous plugins and
+// listeners are put back before the error is returned.
+func (s *Server) ApplyConfig(old, new *config.Config, diff config.Diff) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if s.started {
+        prev := s.loaded
+        var dropped []loadedPlugin
+        reloaded := diff.Changes&config.ChangePlugins != 0
+        if reloaded {
+            var err error
+            if dropped, err = s.loadPlugins(new); err != nil {
+                return err
+            }
+        }
+        if err := s.reconcile(new); err != nil {
+            if reloaded {
+                s.restorePlugins(prev)
+            }
+            if rerr := s.reconcile(s.cfg); rerr != nil {
+                log.Printf("Failed to restore listeners: %v", rerr)
+            }
+            return err
+        }
+        closePluginSet(dropped)
     }
+    s.cfg = new
+    return nil
+}
+
+// Shutdown closes the listeners and waits for active connections to finish.
+// Connections still open when ctx expires are closed forcibly.
+func (s *Server) Shutdown(ctx context.Context) error {
+    s.mu.Lock()
+    s.closeInbounds()
+    s.started = false
+    s.mu.Unlock()
+
+    s.stopOnce.Do(func() { close(s.done) })
+
+    if n := s.conns.count(); n > 0 {
+        log.Printf("Draining %d active connections", n)
+    }
+    err := s.conns.drain(ctx)
+
+    s.mu.Lock()
+    s.closePlugins()
+    s.mu.Unlock()
+
+    if ferr := s.flushState(); ferr != nil {
+        log.Printf("Failed to save traffic counters: %v", ferr)
+    }
+    return err
 }
 
-func (s *Server) handleConnection(conn net.Conn) {
+func (s *Server) handleConnection(conn net.Conn, in *inbound) {
     defer conn.Close()
-    
-    for _, p := range s.plugins {
-        p.OnConnect(conn)
+
+    if !s.conns.add(conn) {
+        return
+    }
+    defer s.conns.remove(conn)
+
+    if s.banned(conn.RemoteAddr()) {
+        return
+    }
+
+    identity, err := clientIdentity(conn, in.cfg.TLS)
+    if err != nil {
+        log.Printf("TLS handshake with %s failed: %v", conn.RemoteAddr(), err)
+        return
+    }
+
+    ctx := plugin.NewConnContext(conn, in.cfg.Name, in.cfg.Protocol)
+    ctx.Identity = identity
+    defer func() { s.countTraffic(in.cfg.Name, ctx.Stats()) }()
+    if err := in.plugins.Accept(ctx); err != nil {
+        log.Printf("Connection from %s %v", conn.RemoteAddr(), err)
+        return
+    }
+    defer in.plugins.Close(ctx)
+
+    switch in.cfg.Protocol {
+    case config.ProtocolSocks5:
+        s.handleSocks5(ctx, in)
+    case config.ProtocolShadowsocks:
+        in.ss.Serve(ctx)
+    case config.ProtocolHTTP:
+        s.handleHTTP(ctx, in)
+    default:
+        s.handleLunasocks(ctx, in)
     }
+}
 
-    if err := s.authenticate(conn); err != nil {
+func (s *Server) handleLunasocks(ctx *plugin.ConnContext, in *inbound) {
+    conn := ctx.Conn
+    if err := s.authenticate(conn, in.cfg.Password.Value()); err != nil {
         log.Printf("Authentication failed: %v", err)
+        in.plugins.Error(ctx, err)
+        return
+    }
+    if err := in.plugins.Auth(ctx, ctx.Identity); err != nil {
+        log.Printf("Authentication %v", err)
         return
     }
 
     for {
-        cmd, err := s.readCommand(conn)
+        cmd, err := s.readCommand(ctx, in.plugins)
         if err != nil {
             if err != io.EOF {
                 log.Printf("Error reading command: %v", err)
+                in.plugins.Error(ctx, err)
             }
             return
         }
 
-        response := s.processCommand(cmd)
+        response := in.plugins.Data(ctx, plugin.Downstream, s.processCommand(cmd))
 
         if err := s.writeResponse(conn, response); err != nil {
             log.Printf("Error writing response: %v", err)
+            in.plugins.Error(ctx, err)
             return
         }
     }
 }
 
-func (s *Server) authenticate(conn net.Conn) error {
+func (s *Server) authenticate(conn net.Conn, password string) error {
     conn.SetDeadline(time.Now().Add(10 * time.Second))
     defer conn.SetDeadline(time.Time{})
 
@@ -111,29 +277,25 @@ func (s *Server) authenticate(conn net.Conn) error {
         return err
     }
 
-    if string(passBuf) != s.cfg.Password {
+    if string(passBuf) != password {
         return errors.New("invalid password")
     }
 
     return nil
 }
 
-func (s *Server) readCommand(conn net.Conn) ([]byte, error) {
+func (s *Server) readCommand(ctx *plugin.ConnContext, plugins plugin.Chain) ([]byte, error) {
     var cmdLen uint32
-    if err := binary.Read(conn, binary.BigEndian, &cmdLen); err != nil {
+    if err := binary.Read(ctx.Conn, binary.BigEndian, &cmdLen); err != nil {
         return nil, err
     }
 
     cmdBuf := make([]byte, cmdLen)
-    if _, err := io.ReadFull(conn, cmdBuf); err != nil {
+    if _, err := io.ReadFull(ctx.Conn, cmdBuf); err != nil {
         return nil, err
     }
 
-    for _, p := range s.plugins {
-        cmdBuf = p.OnData(cmdBuf)
-    }
-
-    return cmdBuf, nil
+    return plugins.Data(ctx, plugin.Upstream, cmdBuf), nil
 }
 
 func (s *Server) processCommand(cmd []byte) []byte {
@@ -150,5 +312,19 @@ func (s *Server) writeResponse(conn net.Conn, response []byte) error {
 }
 
 func (s *Server) IsRunning() bool {
-    return s.listener != nil
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    return len(s.inbounds) > 0
+}
+
+// Listeners returns the addresses the server is currently bound to, keyed
+// by listener name.
+func (s *Server) Listeners() map[string]string {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    addrs := make(map[string]string, len(s.inbounds))
+    for name, in := range s.inbounds {
+        addrs[name] = in.addr()
+    }
+    return addrs
 }
@@ -0,0 +1,52 @@
+package network
+
+import (
+    "context"
+    "net"
+    "testing"
+    "time"
+
+    "your_project/config"
+)
+
+func freeAddr(t *testing.T) string {
+    t.Helper()
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer ln.Close()
+    return ln.Addr().String()
+}
+
+func TestFailedRebindRestoresListener(t *testing.T) {
+    busy, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer busy.Close()
+
+    cfg := config.Default()
+    cfg.Listeners = []config.ListenerConfig{{Name: "a", Address: freeAddr(t), Protocol: config.ProtocolSocks5}}
+    s := NewServer(cfg)
+    go s.Start()
+    defer s.Shutdown(context.Background())
+    for i := 0; i < 100 && len(s.Listeners()) == 0; i++ {
+        time.Sleep(10 * time.Millisecond)
+    }
+
+    next := cfg.Clone()
+    next.Listeners[0].Address = busy.Addr().String()
+    if err := s.ApplyConfig(cfg, next, config.Compare(cfg, next)); err == nil {
+        t.Fatal("Expected rebinding to a port in use to fail")
+    }
+
+    if addr := s.Listeners()["a"]; addr != cfg.Listeners[0].Address {
+        t.Fatalf("Listener a is on %q, want %s", addr, cfg.Listeners[0].Address)
+    }
+    conn, err := net.DialTimeout("tcp", cfg.Listeners[0].Address, time.Second)
+    if err != nil {
+        t.Fatalf("Old listener no longer accepts connections: %v", err)
+    }
+    conn.Close()
+}
@@ -0,0 +1,298 @@
+package network
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "your_project/plugin"
+    "your_project/store"
+)
+
+// Store buckets used by the server.
+const (
+    bansBucket    = "bans"
+    trafficBucket = "traffic"
+)
+
+// trafficSaveInterval limits how often traffic counters are written to the
+// store. Shutdown saves whatever is left.
+const trafficSaveInterval = time.Minute
+
+var ErrBanNotFound = errors.New("ban not found")
+
+// Ban refuses connections from Address, an IP address or CIDR range,
+// until Until, or for good if Until is nil.
+type Ban struct {
+    Address string     `json:"address"`
+    Reason  string     `json:"reason,omitempty"`
+    Until   *time.Time `json:"until,omitempty"`
+}
+
+func (b *Ban) parse() (*net.IPNet, error) {
+    if strings.Contains(b.Address, "/") {
+        _, n, err := net.ParseCIDR(b.Address)
+        return n, err
+    }
+    ip := net.ParseIP(b.Address)
+    if ip == nil {
+        return nil, fmt.Errorf("invalid address %q", b.Address)
+    }
+    bits := 128
+    if ip4 := ip.To4(); ip4 != nil {
+        ip, bits = ip4, 32
+    }
+    return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+func (b *Ban) expired(now time.Time) bool {
+    return b.Until != nil && !now.Before(*b.Until)
+}
+
+// Traffic counts what a listener relayed.
+type Traffic struct {
+    Connections int64 `json:"connections"`
+    BytesUp     int64 `json:"bytes_up"`
+    BytesDown   int64 `json:"bytes_down"`
+}
+
+// serverState holds the bans and traffic counters, written through to a
+// store once SetStore provided one.
+type serverState struct {
+    mu      sync.Mutex
+    store   store.Store
+    bans    map[string]Ban
+    nets    map[string]*net.IPNet
+    traffic map[string]*Traffic
+    dirty   map[string]bool
+    saved   time.Time
+    // detached is set while another process owns the store.
+    detached bool
+}
+
+// SetStore loads bans and traffic counters from st and keeps them there.
+// Call it before Start.
+func (s *Server) SetStore(st store.Store) error {
+    bans, err := st.List(bansBucket)
+    if err != nil {
+        return err
+    }
+    traffic, err := st.List(trafficBucket)
+    if err != nil {
+        return err
+    }
+
+    state := &s.state
+    state.mu.Lock()
+    defer state.mu.Unlock()
+    state.init()
+    state.store = st
+    for addr, data := range bans {
+        var b Ban
+        if err := json.Unmarshal(data, &b); err != nil {
+            return fmt.Errorf("ban %s: %w", addr, err)
+        }
+        n, err := b.parse()
+        if err != nil {
+            return fmt.Errorf("ban %s: %w", addr, err)
+        }
+        state.bans[b.Address] = b
+        state.nets[b.Address] = n
+    }
+    for name, data := range traffic {
+        t := &Traffic{}
+        if err := json.Unmarshal(data, t); err != nil {
+            return fmt.Errorf("traffic of %s: %w", name, err)
+        }
+        state.traffic[name] = t
+    }
+    return nil
+}
+
+// DetachStore saves the traffic counters and stops writing to the store,
+// so that the process started by Upgrade can take it over. Until
+// AttachStore is called, bans cannot be changed and traffic is only
+// counted in memory.
+func (s *Server) DetachStore() error {
+    state := &s.state
+    state.mu.Lock()
+    defer state.mu.Unlock()
+    if err := state.flush(); err != nil {
+        return err
+    }
+    state.detached = true
+    return nil
+}
+
+// AttachStore resumes writing to the store, after an upgrade failed.
+func (s *Server) AttachStore() {
+    s.state.mu.Lock()
+    s.state.detached = false
+    s.state.mu.Unlock()
+}
+
+func (st *serverState) init() {
+    if st.bans == nil {
+        st.bans = make(map[string]Ban)
+        st.nets = make(map[string]*net.IPNet)
+        st.traffic = make(map[string]*Traffic)
+        st.dirty = make(map[string]bool)
+    }
+}
+
+// Ban refuses new connections from b.Address. Established ones are left
+// alone.
+func (s *Server) Ban(b Ban) error {
+    n, err := b.parse()
+    if err != nil {
+        return err
+    }
+    state := &s.state
+    state.mu.Lock()
+    defer state.mu.Unlock()
+    state.init()
+    if state.detached {
+        return store.ErrDetached
+    }
+    if state.store != nil {
+        if err := store.PutJSON(state.store, bansBucket, b.Address, b); err != nil {
+            return err
+        }
+    }
+    state.bans[b.Address] = b
+    state.nets[b.Address] = n
+    return nil
+}
+
+func (s *Server) Unban(addr string) error {
+    state := &s.state
+    state.mu.Lock()
+    defer state.mu.Unlock()
+    if _, ok := state.bans[addr]; !ok {
+        return ErrBanNotFound
+    }
+    return state.unban(addr)
+}
+
+func (st *serverState) unban(addr string) error {
+    if st.detached {
+        return store.ErrDetached
+    }
+    if st.store != nil {
+        if err := st.store.Delete(bansBucket, addr); err != nil {
+            return err
+        }
+    }
+    delete(st.bans, addr)
+    delete(st.nets, addr)
+    return nil
+}
+
+// Bans returns the bans in force, sorted by address.
+func (s *Server) Bans() []Ban {
+    state := &s.state
+    state.mu.Lock()
+    defer state.mu.Unlock()
+    now := time.Now()
+    bans := make([]Ban, 0, len(state.bans))
+    for _, b := range state.bans {
+        if !b.expired(now) {
+            bans = append(bans, b)
+        }
+    }
+    sort.Slice(bans, func(i, j int) bool { return bans[i].Address < bans[j].Address })
+    return bans
+}
+
+// banned reports whether addr, a remote address, is banned. Expired bans
+// are dropped on the way.
+func (s *Server) banned(addr net.Addr) bool {
+    var ip net.IP
+    switch a := addr.(type) {
+    case *net.TCPAddr:
+        ip = a.IP
+    case *net.UDPAddr:
+        ip = a.IP
+    default:
+        return false
+    }
+
+    state := &s.state
+    state.mu.Lock()
+    defer state.mu.Unlock()
+    now := time.Now()
+    for key, n := range state.nets {
+        if !n.Contains(ip) {
+            continue
+        }
+        b := state.bans[key]
+        if !b.expired(now) {
+            return true
+        }
+        if err := state.unban(key); err != nil && !errors.Is(err, store.ErrDetached) {
+            log.Printf("Failed to remove expired ban %s: %v", key, err)
+        }
+    }
+    return false
+}
+
+// Traffic returns the traffic counters of every listener that has relayed
+// anything, including ones removed since.
+func (s *Server) Traffic() map[string]Traffic {
+    state := &s.state
+    state.mu.Lock()
+    defer state.mu.Unlock()
+    out := make(map[string]Traffic, len(state.traffic))
+    for name, t := range state.traffic {
+        out[name] = *t
+    }
+    return out
+}
+
+// countTraffic adds a finished connection to its listener's counters.
+func (s *Server) countTraffic(listener string, stats plugin.Stats) {
+    state := &s.state
+    state.mu.Lock()
+    defer state.mu.Unlock()
+    state.init()
+    t, ok := state.traffic[listener]
+    if !ok {
+        t = &Traffic{}
+        state.traffic[listener] = t
+    }
+    t.Connections++
+    t.BytesUp += stats.BytesUp
+    t.BytesDown += stats.BytesDown
+    state.dirty[listener] = true
+    if time.Since(state.saved) >= trafficSaveInterval {
+        if err := state.flush(); err != nil {
+            log.Printf("Failed to save traffic counters: %v", err)
+        }
+    }
+}
+
+func (s *Server) flushState() error {
+    s.state.mu.Lock()
+    defer s.state.mu.Unlock()
+    return s.state.flush()
+}
+
+func (st *serverState) flush() error {
+    st.saved = time.Now()
+    if st.store == nil || st.detached {
+        return nil
+    }
+    for name := range st.dirty {
+        if err := store.PutJSON(st.store, trafficBucket, name, st.traffic[name]); err != nil {
+            return err
+        }
+        delete(st.dirty, name)
+    }
+    return nil
+}
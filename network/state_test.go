@@ -0,0 +1,90 @@
+package network
+
+import (
+    "net"
+    "testing"
+    "time"
+
+    "your_project/plugin"
+    "your_project/store"
+)
+
+func TestBansAndTrafficPersist(t *testing.T) {
+    st := store.NewMemory()
+    s := NewServer(nil)
+    if err := s.SetStore(st); err != nil {
+        t.Fatalf("SetStore failed: %v", err)
+    }
+
+    past := time.Now().Add(-time.Minute)
+    if err := s.Ban(Ban{Address: "10.0.0.0/8"}); err != nil {
+        t.Fatalf("Ban failed: %v", err)
+    }
+    s.Ban(Ban{Address: "192.0.2.1", Until: &past})
+    if err := s.Ban(Ban{Address: "not-an-ip"}); err == nil {
+        t.Error("Expected an invalid address to be rejected")
+    }
+    s.countTraffic("a", plugin.Stats{BytesUp: 10, BytesDown: 20})
+    s.countTraffic("a", plugin.Stats{BytesUp: 1, BytesDown: 2})
+    if err := s.flushState(); err != nil {
+        t.Fatalf("flushState failed: %v", err)
+    }
+
+    s = NewServer(nil)
+    if err := s.SetStore(st); err != nil {
+        t.Fatalf("SetStore failed: %v", err)
+    }
+    if !s.banned(&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}) {
+        t.Error("10.1.2.3 is not banned")
+    }
+    if s.banned(&net.UDPAddr{IP: net.ParseIP("192.0.2.1")}) {
+        t.Error("Expired ban still applies")
+    }
+    if bans := s.Bans(); len(bans) != 1 || bans[0].Address != "10.0.0.0/8" {
+        t.Errorf("Unexpected bans %+v", bans)
+    }
+    if tr := s.Traffic()["a"]; tr != (Traffic{Connections: 2, BytesUp: 11, BytesDown: 22}) {
+        t.Errorf("Unexpected traffic %+v", tr)
+    }
+
+    if err := s.Unban("10.0.0.0/8"); err != nil {
+        t.Fatalf("Unban failed: %v", err)
+    }
+    if all, _ := st.List(bansBucket); len(all) != 0 {
+        t.Errorf("Bans left in store: %v", all)
+    }
+}
+
+func TestDetachedStoreIsNotWritten(t *testing.T) {
+    st := store.NewMemory()
+    s := NewServer(nil)
+    if err := s.SetStore(st); err != nil {
+        t.Fatalf("SetStore failed: %v", err)
+    }
+    s.countTraffic("a", plugin.Stats{BytesUp: 10})
+    if err := s.DetachStore(); err != nil {
+        t.Fatalf("DetachStore failed: %v", err)
+    }
+    if all, _ := st.List(trafficBucket); len(all) != 1 {
+        t.Errorf("Traffic was not saved before detaching: %v", all)
+    }
+
+    s.countTraffic("b", plugin.Stats{BytesUp: 10})
+    if err := s.Ban(Ban{Address: "10.0.0.0/8"}); err != store.ErrDetached {
+        t.Errorf("Expected ErrDetached from Ban, got %v", err)
+    }
+    if err := s.flushState(); err != nil {
+        t.Fatalf("flushState failed: %v", err)
+    }
+    if all, _ := st.List(trafficBucket); len(all) != 1 {
+        t.Errorf("Traffic was saved to a detached store: %v", all)
+    }
+
+    s.AttachStore()
+    if err := s.flushState(); err != nil {
+        t.Fatalf("flushState failed: %v", err)
+    }
+    if all, _ := st.List(trafficBucket); len(all) != 2 {
+        t.Errorf("Traffic was not saved after attaching: %v", all)
+    }
+}
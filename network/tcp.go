@@ -1,24 +1,36 @@
 package network
 
 import (
+    "context"
+    "errors"
     "net"
 
     "lunasocks/internal/protocol"
     "lunasocks/internal/logging"
 )
 
-func StartTCPServer(addr string, ss *protocol.Shadowsocks) error {
+// StartTCPServer accepts Shadowsocks connections on addr until ctx is
+// cancelled. Active relays are left running; drain them with ss.Shutdown.
+func StartTCPServer(ctx context.Context, addr string, ss *protocol.Shadowsocks) error {
     listener, err := net.Listen("tcp", addr)
     if err != nil {
         return err
     }
     defer listener.Close()
 
+    go func() {
+        <-ctx.Done()
+        listener.Close()
+    }()
+
     logging.Info("TCP Server listening on %s", addr)
 
     for {
         conn, err := listener.Accept()
         if err != nil {
+            if errors.Is(err, net.ErrClosed) {
+                return nil
+            }
             logging.Error("Failed to accept connection: %v", err)
             continue
         }
@@ -0,0 +1,293 @@
+package network
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/pem"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "net"
+    "os"
+    "sync"
+    "time"
+
+    "your_project/config"
+)
+
+var errCertRevoked = errors.New("client certificate has been revoked")
+
+// certReloadInterval is how often certificate files are checked for
+// changes. Checks happen during handshakes, so idle listeners cost nothing.
+const certReloadInterval = 5 * time.Second
+
+// keyPair is a certificate loaded from disk. It is reloaded when its files
+// change, so renewed certificates are picked up without a restart.
+type keyPair struct {
+    certFile, keyFile string
+
+    mu      sync.Mutex
+    cert    *tls.Certificate
+    certMod time.Time
+    keyMod  time.Time
+    checked time.Time
+}
+
+func (kp *keyPair) load() error {
+    certInfo, err := os.Stat(kp.certFile)
+    if err != nil {
+        return err
+    }
+    keyInfo, err := os.Stat(kp.keyFile)
+    if err != nil {
+        return err
+    }
+    cert, err := tls.LoadX509KeyPair(kp.certFile, kp.keyFile)
+    if err != nil {
+        return err
+    }
+    if cert.Leaf == nil {
+        if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+            return err
+        }
+    }
+    kp.cert, kp.certMod, kp.keyMod = &cert, certInfo.ModTime(), keyInfo.ModTime()
+    return nil
+}
+
+// get returns the certificate, reloading it first if its files changed.
+// A certificate that fails to load, e.g. halfway through being replaced,
+// is logged and the previous one kept.
+func (kp *keyPair) get() *tls.Certificate {
+    kp.mu.Lock()
+    defer kp.mu.Unlock()
+    if time.Since(kp.checked) < certReloadInterval {
+        return kp.cert
+    }
+    kp.checked = time.Now()
+
+    certInfo, err1 := os.Stat(kp.certFile)
+    keyInfo, err2 := os.Stat(kp.keyFile)
+    if err1 != nil || err2 != nil || (certInfo.ModTime().Equal(kp.certMod) && keyInfo.ModTime().Equal(kp.keyMod)) {
+        return kp.cert
+    }
+    if err := kp.load(); err != nil {
+        log.Printf("Failed to reload certificate %s: %v", kp.certFile, err)
+        return kp.cert
+    }
+    log.Printf("Reloaded certificate %s", kp.certFile)
+    return kp.cert
+}
+
+// certCache shares loaded certificates between listeners, keyed by their
+// file names.
+type certCache struct {
+    mu    sync.Mutex
+    pairs map[[2]string]*keyPair
+}
+
+func (c *certCache) load(certFile, keyFile string) (*keyPair, error) {
+    key := [2]string{certFile, keyFile}
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if kp, ok := c.pairs[key]; ok {
+        return kp, nil
+    }
+    kp := &keyPair{certFile: certFile, keyFile: keyFile, checked: time.Now()}
+    if err := kp.load(); err != nil {
+        return nil, err
+    }
+    if c.pairs == nil {
+        c.pairs = make(map[[2]string]*keyPair)
+    }
+    c.pairs[key] = kp
+    return kp, nil
+}
+
+// serverTLSConfig builds the TLS configuration of a listener: its
+// certificates, picked by SNI and reloaded as they change, protocol
+// versions, cipher suites and ALPN, and client certificate verification
+// when a client CA is configured. With ACME the certificates are left to
+// startACME.
+func (c *certCache) serverTLSConfig(tc config.TLSConfig) (*tls.Config, error) {
+    tlsConfig := &tls.Config{
+        MinVersion: tls.VersionTLS12,
+        NextProtos: append([]string(nil), tc.ALPN...),
+    }
+    if !tc.ACME.Enabled {
+        files := append([]config.CertificateConfig{{CertFile: tc.CertFile, KeyFile: tc.KeyFile}}, tc.Certificates...)
+        pairs := make([]*keyPair, len(files))
+        for i, f := range files {
+            kp, err := c.load(f.CertFile, f.KeyFile)
+            if err != nil {
+                return nil, err
+            }
+            pairs[i] = kp
+        }
+        tlsConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+            if len(pairs) > 1 {
+                for _, kp := range pairs {
+                    if cert := kp.get(); hello.SupportsCertificate(cert) == nil {
+                        return cert, nil
+                    }
+                }
+            }
+            return pairs[0].get(), nil
+        }
+    }
+    if v, ok := config.TLSVersions[tc.MinVersion]; ok {
+        tlsConfig.MinVersion = v
+    }
+    for _, name := range tc.CipherSuites {
+        id, ok := config.CipherSuite(name)
+        if !ok {
+            return nil, fmt.Errorf("unknown cipher suite %q", name)
+        }
+        tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, id)
+    }
+    if tc.ClientCA == "" {
+        return tlsConfig, nil
+    }
+
+    pemData, err := ioutil.ReadFile(tc.ClientCA)
+    if err != nil {
+        return nil, err
+    }
+    pool := x509.NewCertPool()
+    if !pool.AppendCertsFromPEM(pemData) {
+        return nil, fmt.Errorf("%s: no certificates found", tc.ClientCA)
+    }
+    tlsConfig.ClientCAs = pool
+    tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+    if tc.ClientAuth == config.ClientAuthOptional {
+        tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+    }
+
+    if tc.CRLFile != "" {
+        crl := &crlFile{path: tc.CRLFile}
+        if err := crl.load(); err != nil {
+            return nil, err
+        }
+        tlsConfig.VerifyPeerCertificate = crl.verify
+    }
+    return tlsConfig, nil
+}
+
+// crlFile checks client certificates against a certificate revocation
+// list, reloading it when the file changes so that revoking a certificate
+// does not need a restart.
+type crlFile struct {
+    path string
+
+    mu      sync.Mutex
+    modTime time.Time
+    list    *x509.RevocationList
+}
+
+func (c *crlFile) load() error {
+    info, err := os.Stat(c.path)
+    if err != nil {
+        return err
+    }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.list != nil && info.ModTime().Equal(c.modTime) {
+        return nil
+    }
+
+    data, err := ioutil.ReadFile(c.path)
+    if err != nil {
+        return err
+    }
+    if block, _ := pem.Decode(data); block != nil {
+        data = block.Bytes
+    }
+    list, err := x509.ParseRevocationList(data)
+    if err != nil {
+        return fmt.Errorf("%s: %w", c.path, err)
+    }
+    c.list, c.modTime = list, info.ModTime()
+    return nil
+}
+
+// verify rejects a verified chain whose leaf is on the revocation list.
+// The list must be signed by the CA that issued the leaf.
+func (c *crlFile) verify(_ [][]byte, chains [][]*x509.Certificate) error {
+    if err := c.load(); err != nil {
+        // Keep using the last good list while the file is being replaced.
+        if c.current() == nil {
+            return err
+        }
+    }
+    list := c.current()
+    for _, chain := range chains {
+        if len(chain) < 2 {
+            continue
+        }
+        leaf, issuer := chain[0], chain[1]
+        if list.CheckSignatureFrom(issuer) != nil {
+            continue
+        }
+        for _, entry := range list.RevokedCertificateEntries {
+            if entry.SerialNumber.Cmp(leaf.SerialNumber) == 0 {
+                return errCertRevoked
+            }
+        }
+    }
+    return nil
+}
+
+func (c *crlFile) current() *x509.RevocationList {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.list
+}
+
+// clientIdentity completes the TLS handshake of a listener using client
+// certificates and returns the identity of the verified certificate, or
+// "" if the client sent none.
+func clientIdentity(conn net.Conn, tc config.TLSConfig) (string, error) {
+    tlsConn, ok := conn.(*tls.Conn)
+    if !ok || tc.ClientCA == "" {
+        return "", nil
+    }
+    tlsConn.SetDeadline(time.Now().Add(dialTimeout))
+    defer tlsConn.SetDeadline(time.Time{})
+    if err := tlsConn.Handshake(); err != nil {
+        return "", err
+    }
+
+    chains := tlsConn.ConnectionState().VerifiedChains
+    if len(chains) == 0 {
+        return "", nil
+    }
+    return certIdentity(chains[0][0], tc.Identity), nil
+}
+
+// certIdentity picks the user name out of a client certificate: its
+// common name, or with IdentitySAN the first DNS name, email address or
+// URI, falling back to the common name.
+func certIdentity(cert *x509.Certificate, field string) string {
+    if field == config.IdentitySAN {
+        switch {
+        case len(cert.DNSNames) > 0:
+            return cert.DNSNames[0]
+        case len(cert.EmailAddresses) > 0:
+            return cert.EmailAddresses[0]
+        case len(cert.URIs) > 0:
+            return cert.URIs[0].String()
+        }
+    }
+    return cert.Subject.CommonName
+}
+
+// TLSConfig builds a server TLS configuration sharing the listeners'
+// certificate cache, for other servers in the process such as the web
+// admin. ACME is not available here.
+func (s *Server) TLSConfig(tc config.TLSConfig) (*tls.Config, error) {
+    if tc.ACME.Enabled {
+        return nil, errors.New("ACME is only supported on listeners")
+    }
+    return s.certs.serverTLSConfig(tc)
+}
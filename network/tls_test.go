@@ -0,0 +1,197 @@
+package network
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "io/ioutil"
+    "math/big"
+    "net"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "your_project/config"
+)
+
+type testCA struct {
+    cert *x509.Certificate
+    key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+    key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    tmpl := &x509.Certificate{
+        SerialNumber:          big.NewInt(1),
+        Subject:               pkix.Name{CommonName: "test ca"},
+        NotBefore:             time.Now().Add(-time.Hour),
+        NotAfter:              time.Now().Add(time.Hour),
+        IsCA:                  true,
+        BasicConstraintsValid: true,
+        KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+    }
+    der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    cert, _ := x509.ParseCertificate(der)
+    return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) issue(t *testing.T, serial int64, cn string, dns []string, usage x509.ExtKeyUsage) tls.Certificate {
+    key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    tmpl := &x509.Certificate{
+        SerialNumber: big.NewInt(serial),
+        Subject:      pkix.Name{CommonName: cn},
+        DNSNames:     dns,
+        NotBefore:    time.Now().Add(-time.Hour),
+        NotAfter:     time.Now().Add(time.Hour),
+        ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+    }
+    der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+    if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestClientCertificates(t *testing.T) {
+    dir := t.TempDir()
+    ca := newTestCA(t)
+    writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", ca.cert.Raw)
+
+    server := ca.issue(t, 2, "server", []string{"localhost"}, x509.ExtKeyUsageServerAuth)
+    keyDER, _ := x509.MarshalECPrivateKey(server.PrivateKey.(*ecdsa.PrivateKey))
+    writePEM(t, filepath.Join(dir, "cert.pem"), "CERTIFICATE", server.Certificate[0])
+    writePEM(t, filepath.Join(dir, "key.pem"), "EC PRIVATE KEY", keyDER)
+
+    crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+        Number:                    big.NewInt(1),
+        ThisUpdate:                time.Now(),
+        NextUpdate:                time.Now().Add(time.Hour),
+        RevokedCertificateEntries: []x509.RevocationListEntry{{SerialNumber: big.NewInt(4), RevocationTime: time.Now()}},
+    }, ca.cert, ca.key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    writePEM(t, filepath.Join(dir, "crl.pem"), "X509 CRL", crl)
+
+    tc := config.TLSConfig{
+        Enabled:  true,
+        CertFile: filepath.Join(dir, "cert.pem"),
+        KeyFile:  filepath.Join(dir, "key.pem"),
+        ClientCA: filepath.Join(dir, "ca.pem"),
+        Identity: config.IdentitySAN,
+        CRLFile:  filepath.Join(dir, "crl.pem"),
+    }
+    serverConfig, err := (&certCache{}).serverTLSConfig(tc)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    handshake := func(cert tls.Certificate) (string, error) {
+        ln, err := net.Listen("tcp", "127.0.0.1:0")
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer ln.Close()
+        a, err := net.Dial("tcp", ln.Addr().String())
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer a.Close()
+        b, err := ln.Accept()
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer b.Close()
+        roots := x509.NewCertPool()
+        roots.AddCert(ca.cert)
+        go tls.Client(a, &tls.Config{ServerName: "localhost", RootCAs: roots, Certificates: []tls.Certificate{cert}}).Handshake()
+        return clientIdentity(tls.Server(b, serverConfig), tc)
+    }
+
+    id, err := handshake(ca.issue(t, 3, "alice", []string{"alice.clients.example"}, x509.ExtKeyUsageClientAuth))
+    if err != nil || id != "alice.clients.example" {
+        t.Fatalf("valid client: got %q, %v", id, err)
+    }
+    if _, err := handshake(ca.issue(t, 4, "mallory", nil, x509.ExtKeyUsageClientAuth)); err == nil {
+        t.Fatal("revoked client certificate was accepted")
+    }
+    if _, err := handshake(newTestCA(t).issue(t, 5, "eve", nil, x509.ExtKeyUsageClientAuth)); err == nil {
+        t.Fatal("certificate from an unknown CA was accepted")
+    }
+}
+
+func writeKeyPair(t *testing.T, dir, name string, cert tls.Certificate) (string, string) {
+    certFile, keyFile := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+    keyDER, _ := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+    writePEM(t, certFile, "CERTIFICATE", cert.Certificate[0])
+    writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+    return certFile, keyFile
+}
+
+func TestCertificateSNIAndReload(t *testing.T) {
+    dir := t.TempDir()
+    ca := newTestCA(t)
+    certFile, keyFile := writeKeyPair(t, dir, "a", ca.issue(t, 2, "a", []string{"a.example"}, x509.ExtKeyUsageServerAuth))
+    bCert, bKey := writeKeyPair(t, dir, "b", ca.issue(t, 3, "b", []string{"b.example"}, x509.ExtKeyUsageServerAuth))
+
+    certs := &certCache{}
+    tlsConfig, err := certs.serverTLSConfig(config.TLSConfig{
+        Enabled:      true,
+        CertFile:     certFile,
+        KeyFile:      keyFile,
+        Certificates: []config.CertificateConfig{{CertFile: bCert, KeyFile: bKey}},
+        MinVersion:   "1.3",
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if tlsConfig.MinVersion != tls.VersionTLS13 {
+        t.Errorf("MinVersion = %x, want TLS 1.3", tlsConfig.MinVersion)
+    }
+
+    serial := func(name string) int64 {
+        cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{
+            ServerName:        name,
+            SupportedVersions: []uint16{tls.VersionTLS13},
+            SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+        return cert.Leaf.SerialNumber.Int64()
+    }
+    if got := serial("b.example"); got != 3 {
+        t.Errorf("b.example got certificate %d, want 3", got)
+    }
+    if got := serial("unknown.example"); got != 2 {
+        t.Errorf("unknown name got certificate %d, want the default 2", got)
+    }
+
+    // Renew a.example on disk; the next handshake after the check
+    // interval serves the new certificate.
+    writeKeyPair(t, dir, "a", ca.issue(t, 7, "a", []string{"a.example"}, x509.ExtKeyUsageServerAuth))
+    later := time.Now().Add(time.Minute)
+    os.Chtimes(certFile, later, later)
+    os.Chtimes(keyFile, later, later)
+    kp, _ := certs.load(certFile, keyFile)
+    kp.mu.Lock()
+    kp.checked = time.Time{}
+    kp.mu.Unlock()
+    if got := serial("a.example"); got != 7 {
+        t.Errorf("after renewal got certificate %d, want 7", got)
+    }
+}
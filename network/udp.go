@@ -2,11 +2,14 @@ package network
 
 import (
     "encoding/binary"
+    "errors"
     "log"
     "net"
     "time"
 
     "your_project/crypto"
+    "your_project/plugin"
+    "your_project/protocol"
     "your_project/socks"
 )
 
@@ -17,34 +20,32 @@ type UDPConn struct {
     clientID string
 }
 
-func (s *Server) handleUDP() {
-    addr, err := net.ResolveUDPAddr("udp", s.address)
-    if err != nil {
-        log.Fatalf("Failed to resolve UDP address: %v", err)
-    }
-
-    conn, err := net.ListenUDP("udp", addr)
-    if err != nil {
-        log.Fatalf("Failed to listen on UDP: %v", err)
-    }
+func (s *Server) handleUDP(name string, conn *net.UDPConn) {
     defer conn.Close()
 
-    log.Printf("Listening for UDP connections on %s", s.address)
+    log.Printf("Listening for UDP connections on %s", conn.LocalAddr())
 
     for {
         buf := make([]byte, 64*1024)
         n, remoteAddr, err := conn.ReadFromUDP(buf)
         if err != nil {
+            if errors.Is(err, net.ErrClosed) {
+                return
+            }
             log.Printf("Error reading UDP packet: %v", err)
             continue
         }
 
-        go s.handleUDPPacket(conn, remoteAddr, buf[:n])
+        in := s.inbound(name)
+        if in == nil || s.banned(remoteAddr) {
+            continue
+        }
+        go s.handleUDPPacket(conn, remoteAddr, buf[:n], in.cfg.Password.Value())
     }
 }
 
-func (s *Server) handleUDPPacket(conn *net.UDPConn, remoteAddr *net.UDPAddr, data []byte) {
-    cipher, err := crypto.NewCipher([]byte(s.password))
+func (s *Server) handleUDPPacket(conn *net.UDPConn, remoteAddr *net.UDPAddr, data []byte, password string) {
+    cipher, err := crypto.NewCipher([]byte(password))
     if err != nil {
         log.Printf("Failed to create cipher: %v", err)
         return
@@ -84,6 +85,7 @@ func (s *Server) handleUDPPacket(conn *net.UDPConn, remoteAddr *net.UDPAddr, dat
     }
 
     responseBuf := make([]byte, 64*1024)
+    targetConn.SetReadDeadline(time.Now().Add(s.config().UDP.Timeout.Duration()))
     n, _, err := targetConn.ReadFromUDP(responseBuf)
     if err != nil {
         log.Printf("Failed to receive response from target: %v", err)
@@ -108,3 +110,58 @@ func (s *Server) handleUDPPacket(conn *net.UDPConn, remoteAddr *net.UDPAddr, dat
         return
     }
 }
+
+// relayUDPOverTCP serves a UDP-over-TCP association. Datagrams read from
+// conn leave through a NAT socket of their own, and whatever comes back
+// to that socket is framed back to the client with its source address.
+// The association ends when the client has sent nothing for the UDP
+// timeout.
+func (s *Server) relayUDPOverTCP(ctx *plugin.ConnContext, in *inbound, conn net.Conn) {
+    nat, err := net.ListenUDP("udp", nil)
+    if err != nil {
+        log.Printf("Failed to open UDP relay socket: %v", err)
+        return
+    }
+    defer nat.Close()
+
+    go func() {
+        buf := make([]byte, 64*1024)
+        for {
+            n, from, err := nat.ReadFromUDP(buf)
+            if err != nil {
+                return
+            }
+            packet := append(socks.UDPHeader(from), buf[:n]...)
+            if err := protocol.WritePacket(conn, packet); err != nil {
+                nat.Close()
+                return
+            }
+        }
+    }()
+
+    timeout := s.config().UDP.Timeout.Duration()
+    for {
+        conn.SetReadDeadline(time.Now().Add(timeout))
+        packet, err := protocol.ReadPacket(conn)
+        if err != nil {
+            return
+        }
+        addr, payload, err := socks.ParseUDPAddress(packet)
+        if err != nil {
+            log.Printf("Invalid UDP-over-TCP packet: %v", err)
+            continue
+        }
+        dest, err := in.plugins.Dial(ctx, addr)
+        if err != nil {
+            continue
+        }
+        udpAddr, err := net.ResolveUDPAddr("udp", dest)
+        if err != nil {
+            log.Printf("Failed to resolve destination UDP address: %v", err)
+            continue
+        }
+        if _, err := nat.WriteToUDP(payload, udpAddr); err != nil {
+            log.Printf("Failed to send data to target: %v", err)
+        }
+    }
+}
@@ -0,0 +1,280 @@
+package network
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "net"
+    "os"
+    "os/exec"
+    "strconv"
+    "strings"
+    "sync"
+    "your_project/sip"
+)
+
+// Environment variables used to hand listening sockets to an upgraded
+// process. LUNASOCKS_LISTEN_FDS lists "network:address" entries in the
+// order of the inherited descriptors starting at fd 3; LUNASOCKS_READY_FD
+// names the pipe the child writes to once it is serving.
+const (
+    envListenFDs = "LUNASOCKS_LISTEN_FDS"
+    envReadyFD   = "LUNASOCKS_READY_FD"
+)
+
+var (
+    inheritOnce sync.Once
+    inheritMu   sync.Mutex
+    inherited   map[string]*os.File
+    readyOnce   sync.Once
+)
+
+func loadInherited() {
+    inherited = make(map[string]*os.File)
+    spec := os.Getenv(envListenFDs)
+    if spec == "" {
+        return
+    }
+    for i, entry := range strings.Split(spec, ",") {
+        inherited[entry] = os.NewFile(uintptr(3+i), entry)
+    }
+    os.Unsetenv(envListenFDs)
+}
+
+func takeInherited(network, addr string) *os.File {
+    inheritOnce.Do(loadInherited)
+    inheritMu.Lock()
+    defer inheritMu.Unlock()
+    key := network + ":" + addr
+    f := inherited[key]
+    delete(inherited, key)
+    return f
+}
+
+// ListenTCP returns an inherited listener for addr if the parent process
+// handed one over, and binds a fresh socket otherwise.
+func ListenTCP(addr string) (*net.TCPListener, error) {
+    if f := takeInherited("tcp", addr); f != nil {
+        defer f.Close()
+        ln, err := net.FileListener(f)
+        if err != nil {
+            return nil, err
+        }
+        log.Printf("Inherited TCP listener on %s", addr)
+        return ln.(*net.TCPListener), nil
+    }
+    ln, err := net.Listen("tcp", addr)
+    if err != nil {
+        return nil, err
+    }
+    return ln.(*net.TCPListener), nil
+}
+
+func listenUDP(addr string) (*net.UDPConn, error) {
+    if f := takeInherited("udp", addr); f != nil {
+        defer f.Close()
+        pc, err := net.FilePacketConn(f)
+        if err != nil {
+            return nil, err
+        }
+        log.Printf("Inherited UDP socket on %s", addr)
+        return pc.(*net.UDPConn), nil
+    }
+    udpAddr, err := net.ResolveUDPAddr("udp", addr)
+    if err != nil {
+        return nil, err
+    }
+    return net.ListenUDP("udp", udpAddr)
+}
+
+// ShareListener adds a listener that is not owned by the server, such as
+// the web admin's, to the set handed over on Upgrade.
+func (s *Server) ShareListener(addr string, ln *net.TCPListener) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.shared == nil {
+        s.shared = make(map[string]*net.TCPListener)
+    }
+    s.shared[addr] = ln
+}
+
+func (s *Server) sharedListeners() map[string]*net.TCPListener {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    shared := make(map[string]*net.TCPListener, len(s.shared))
+    for addr, ln := range s.shared {
+        shared[addr] = ln
+    }
+    return shared
+}
+
+// notifyReady tells the parent process, if any, that this process has
+// bound its listeners and is accepting connections.
+func notifyReady() {
+    readyOnce.Do(func() {
+        fd := os.Getenv(envReadyFD)
+        if fd == "" {
+            return
+        }
+        os.Unsetenv(envReadyFD)
+        n, err := strconv.Atoi(fd)
+        if err != nil {
+            log.Printf("Invalid %s: %q", envReadyFD, fd)
+            return
+        }
+        f := os.NewFile(uintptr(n), "ready")
+        f.Write([]byte{1})
+        f.Close()
+    })
+}
+
+// Upgrade starts a new instance of the running binary with the same
+// arguments and hands it the server's listening sockets. It returns once
+// the child reports that it is serving; the caller should then Shutdown
+// this server to drain its remaining connections.
+func (s *Server) Upgrade(ctx context.Context) (*os.Process, error) {
+    var files []*os.File
+    var names []string
+    defer func() {
+        for _, f := range files {
+            f.Close()
+        }
+    }()
+
+    s.mu.RLock()
+    for _, in := range s.inbounds {
+        // A SIP003 plugin owns the public address and has no descriptor to
+        // hand over; see pausePlugins.
+        if in.b.tcp != nil && in.b.plugin == nil {
+            f, err := in.b.tcp.File()
+            if err != nil {
+                s.mu.RUnlock()
+                return nil, err
+            }
+            files = append(files, f)
+            names = append(names, "tcp:"+in.cfg.Address)
+        }
+
+        if in.b.udp != nil {
+            f, err := in.b.udp.File()
+            if err != nil {
+                s.mu.RUnlock()
+                return nil, err
+            }
+            files = append(files, f)
+            names = append(names, "udp:"+in.cfg.Address)
+        }
+    }
+    running := len(s.inbounds) > 0
+    s.mu.RUnlock()
+    if !running {
+        return nil, errors.New("server is not running")
+    }
+
+    for shared, ln := range s.sharedListeners() {
+        f, err := ln.File()
+        if err != nil {
+            return nil, err
+        }
+        files = append(files, f)
+        names = append(names, "tcp:"+shared)
+    }
+
+    readyR, readyW, err := os.Pipe()
+    if err != nil {
+        return nil, err
+    }
+    defer readyR.Close()
+    files = append(files, readyW)
+
+    exe, err := os.Executable()
+    if err != nil {
+        return nil, err
+    }
+    cmd := exec.Command(exe, os.Args[1:]...)
+    cmd.Stdin = os.Stdin
+    cmd.Stdout = os.Stdout
+    cmd.Stderr = os.Stderr
+    cmd.ExtraFiles = files
+    cmd.Env = append(os.Environ(),
+        envListenFDs+"="+strings.Join(names, ","),
+        fmt.Sprintf("%s=%d", envReadyFD, 3+len(names)),
+    )
+    // The new process's plugins can only bind once ours let go of the
+    // public addresses. Stop them now rather than leave the new ones to
+    // retry with backoff until this process exits; new connections to
+    // those listeners are refused until the new plugins are up.
+    paused := s.pausePlugins()
+    if err := cmd.Start(); err != nil {
+        s.resumePlugins(paused)
+        return nil, err
+    }
+    readyW.Close()
+    files = files[:len(files)-1]
+
+    ready := make(chan error, 1)
+    go func() {
+        buf := make([]byte, 1)
+        _, err := readyR.Read(buf)
+        ready <- err
+    }()
+
+    select {
+    case err := <-ready:
+        if err != nil {
+            cmd.Process.Kill()
+            cmd.Wait()
+            s.resumePlugins(paused)
+            return nil, fmt.Errorf("child exited before becoming ready: %w", err)
+        }
+    case <-ctx.Done():
+        cmd.Process.Kill()
+        cmd.Wait()
+        s.resumePlugins(paused)
+        return nil, ctx.Err()
+    }
+
+    log.Printf("Upgraded process %d is serving on %s", cmd.Process.Pid, strings.Join(names, ", "))
+    return cmd.Process, nil
+}
+
+// pausePlugins stops the SIP003 plugins in front of the server's
+// listeners and returns their bindings. The loopback listeners behind
+// them keep serving connections that are already open.
+func (s *Server) pausePlugins() []*binding {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    var paused []*binding
+    for _, in := range s.inbounds {
+        if in.b.plugin != nil {
+            log.Printf("Stopping SIP003 plugin %s on %s for the upgrade", in.cfg.Plugin, in.cfg.Address)
+            in.b.plugin.Stop()
+            paused = append(paused, in.b)
+        }
+    }
+    return paused
+}
+
+// resumePlugins restarts the plugins stopped by pausePlugins after a
+// failed upgrade.
+func (s *Server) resumePlugins(paused []*binding) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    live := make(map[*binding]bool, len(s.inbounds))
+    for _, in := range s.inbounds {
+        live[in.b] = true
+    }
+    for _, b := range paused {
+        if !live[b] {
+            // Closed by a reload in the meantime.
+            continue
+        }
+        p, err := sip.StartPlugin(b.plugin.Config())
+        if err != nil {
+            log.Printf("Failed to restart SIP003 plugin: %v", err)
+            continue
+        }
+        b.plugin = p
+    }
+}
@@ -0,0 +1,87 @@
+package network
+
+import (
+    "log"
+    "sync"
+
+    "your_project/config"
+    "your_project/protocol"
+    "your_project/users"
+)
+
+// SetUsers lets listeners with users enabled accept the users managed by
+// m and installs the plugin enforcing their restrictions. Call it before
+// Start.
+func (s *Server) SetUsers(m *users.Manager) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.users = m
+    s.plugins = append(s.plugins, m.Hooks())
+    s.pluginGen++
+}
+
+// authenticateUser checks credentials against the managed users, if the
+// listener accepts them.
+func (s *Server) authenticateUser(in *inbound, username, password string) bool {
+    return in.cfg.Users && s.users != nil && s.users.Authenticate(username, password)
+}
+
+// userCiphers caches the shadowsocks ciphers of the managed users, which
+// are slow to derive, until their credentials or method change.
+type userCiphers struct {
+    mu    sync.Mutex
+    cache map[string]cachedCipher
+}
+
+type cachedCipher struct {
+    secret string
+    cipher protocol.UserCipher
+}
+
+// shadowsocksUsers returns the ciphers of the users a shadowsocks
+// listener accepts. Users without their own method use the listener's.
+func (s *Server) shadowsocksUsers(lc config.ListenerConfig) []protocol.UserCipher {
+    if s.users == nil {
+        return nil
+    }
+    list := s.users.List()
+
+    c := &s.ssUsers
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.cache == nil {
+        c.cache = make(map[string]cachedCipher)
+    }
+    ciphers := make([]protocol.UserCipher, 0, len(list))
+    for _, u := range list {
+        method := u.Method
+        if method == "" {
+            method = lc.Method
+        }
+        id := lc.Name + "/" + u.Name
+        secret := u.Password + "\x00" + u.Key + "\x00" + method
+        if cc, ok := c.cache[id]; ok && cc.secret == secret {
+            ciphers = append(ciphers, cc.cipher)
+            continue
+        }
+
+        uc := protocol.UserCipher{Name: u.Name}
+        var err error
+        if u.Key != "" {
+            key, kerr := u.KeyBytes()
+            if kerr != nil {
+                continue
+            }
+            uc.Cipher, err = protocol.NewKeyCipher(key, method)
+        } else {
+            uc.Cipher, err = protocol.NewCipher(u.Password, method)
+        }
+        if err != nil {
+            log.Printf("User %s on listener %s: %v", u.Name, lc.Name, err)
+            continue
+        }
+        c.cache[id] = cachedCipher{secret: secret, cipher: uc}
+        ciphers = append(ciphers, uc)
+    }
+    return ciphers
+}
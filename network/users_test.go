@@ -0,0 +1,89 @@
+package network
+
+import (
+    "context"
+    "encoding/binary"
+    "net"
+    "testing"
+    "time"
+
+    "your_project/config"
+    "your_project/crypto"
+    "your_project/store"
+    "your_project/users"
+)
+
+func TestShadowsocksUserWithPassword(t *testing.T) {
+    dest, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer dest.Close()
+    accepted := make(chan net.Conn, 1)
+    go func() {
+        if conn, err := dest.Accept(); err == nil {
+            accepted <- conn
+        }
+    }()
+
+    m, err := users.New(store.NewMemory())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := m.Create(users.User{Name: "alice", Password: "alice-password"}); err != nil {
+        t.Fatal(err)
+    }
+
+    cfg := config.Default()
+    cfg.Listeners = []config.ListenerConfig{{
+        Name:     "ss",
+        Address:  freeAddr(t),
+        Protocol: config.ProtocolShadowsocks,
+        Method:   "aes-256-gcm",
+        Users:    true,
+    }}
+    s := NewServer(cfg)
+    s.SetUsers(m)
+    go s.Start()
+    defer s.Shutdown(context.Background())
+    for i := 0; i < 100 && len(s.Listeners()) == 0; i++ {
+        time.Sleep(10 * time.Millisecond)
+    }
+
+    // The client derives its key from the password on its own, the way
+    // shadowsocks clients do.
+    dial := func(password string) {
+        cipher, err := crypto.NewAEADCipher(crypto.EVPBytesToKey(password, 32), "aes-256-gcm")
+        if err != nil {
+            t.Fatal(err)
+        }
+        sealed, err := cipher.Encrypt([]byte(dest.Addr().String()))
+        if err != nil {
+            t.Fatal(err)
+        }
+        conn, err := net.Dial("tcp", cfg.Listeners[0].Address)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer conn.Close()
+        conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(sealed))))
+        conn.Write(sealed)
+        time.Sleep(200 * time.Millisecond)
+    }
+
+    dial("wrong-password")
+    select {
+    case conn := <-accepted:
+        conn.Close()
+        t.Fatal("A client with the wrong password was relayed")
+    default:
+    }
+
+    dial("alice-password")
+    select {
+    case conn := <-accepted:
+        conn.Close()
+    case <-time.After(2 * time.Second):
+        t.Fatal("The user's connection was not relayed")
+    }
+}
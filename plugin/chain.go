@@ -0,0 +1,61 @@
+package plugin
+
+import "fmt"
+
+// Chain runs hooks in order. A nil Chain is valid and does nothing.
+type Chain []Hooks
+
+// Accept stops at the first plugin that rejects the connection.
+func (c Chain) Accept(ctx *ConnContext) error {
+    for _, h := range c {
+        if err := h.OnAccept(ctx); err != nil {
+            return fmt.Errorf("rejected by %s: %w", h.Name(), err)
+        }
+    }
+    return nil
+}
+
+// Auth records user on ctx once every plugin has accepted it.
+func (c Chain) Auth(ctx *ConnContext, user string) error {
+    for _, h := range c {
+        if err := h.OnAuth(ctx, user); err != nil {
+            return fmt.Errorf("rejected by %s: %w", h.Name(), err)
+        }
+    }
+    ctx.User = user
+    return nil
+}
+
+// Dial passes dest through every plugin, each seeing the previous one's
+// rewrite, and records the result on ctx.
+func (c Chain) Dial(ctx *ConnContext, dest string) (string, error) {
+    for _, h := range c {
+        next, err := h.OnDial(ctx, dest)
+        if err != nil {
+            return "", fmt.Errorf("blocked by %s: %w", h.Name(), err)
+        }
+        dest = next
+    }
+    ctx.Destination = dest
+    return dest, nil
+}
+
+func (c Chain) Data(ctx *ConnContext, dir Direction, data []byte) []byte {
+    for _, h := range c {
+        data = h.OnData(ctx, dir, data)
+    }
+    return data
+}
+
+func (c Chain) Close(ctx *ConnContext) {
+    stats := ctx.Stats()
+    for _, h := range c {
+        h.OnClose(ctx, stats)
+    }
+}
+
+func (c Chain) Error(ctx *ConnContext, err error) {
+    for _, h := range c {
+        h.OnError(ctx, err)
+    }
+}
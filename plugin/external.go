@@ -0,0 +1,511 @@
+package plugin
+
+// External plugins run in a separate process and speak a line-based JSON
+// protocol, over the process's stdin/stdout or over a Unix socket. Every
+// line the host writes is a request:
+//
+//	{"id": 7, "hook": "dial", "conn": {...}, "dest": "example.com:443"}
+//
+// and requests with a non-zero id must be answered with a line carrying the
+// same id:
+//
+//	{"id": 7, "dest": "example.net:443"}
+//
+// A non-empty "error" rejects the connection (accept, auth, dial). Hooks
+// are "init", "accept", "auth", "dial", "data", "close" and "error"; close
+// and error are sent with id 0 and get no reply. The first request is
+// always init, carrying the plugin's settings; its reply lists the hooks
+// the plugin implements in "hooks", and no other hook is ever sent. Data
+// is base64 encoded; a data reply without "data" leaves the chunk
+// unchanged.
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net"
+    "os"
+    "os/exec"
+    "sync"
+    "time"
+)
+
+const (
+    defaultExternalTimeout = 2 * time.Second
+    maxRestartDelay        = 30 * time.Second
+    // maxMissedCalls is how many calls in a row may time out before the
+    // plugin is considered hung and restarted.
+    maxMissedCalls = 3
+)
+
+// ExternalSocketEnv tells a plugin started in socket mode where to listen.
+const ExternalSocketEnv = "LUNASOCKS_PLUGIN_SOCKET"
+
+var ErrPluginUnavailable = errors.New("plugin process is not running")
+
+// ExternalConfig describes an out-of-process plugin. With Command set the
+// process is started and restarted by Lunasocks; with only Socket set an
+// already running process is connected to, and reconnected when it goes
+// away.
+type ExternalConfig struct {
+    Name     string
+    Command  string
+    Args     []string
+    Socket   string
+    Timeout  time.Duration
+    FailOpen bool
+    Settings map[string]interface{}
+}
+
+type rpcConnInfo struct {
+    ID          uint64 `json:"id"`
+    Listener    string `json:"listener,omitempty"`
+    Protocol    string `json:"protocol,omitempty"`
+    Remote      string `json:"remote,omitempty"`
+    User        string `json:"user,omitempty"`
+    Destination string `json:"destination,omitempty"`
+}
+
+type rpcStats struct {
+    BytesUp   int64   `json:"bytes_up"`
+    BytesDown int64   `json:"bytes_down"`
+    Duration  float64 `json:"duration"`
+}
+
+type rpcRequest struct {
+    ID        uint64                 `json:"id,omitempty"`
+    Hook      string                 `json:"hook"`
+    Conn      *rpcConnInfo           `json:"conn,omitempty"`
+    User      string                 `json:"user,omitempty"`
+    Dest      string                 `json:"dest,omitempty"`
+    Direction string                 `json:"direction,omitempty"`
+    Data      []byte                 `json:"data,omitempty"`
+    Stats     *rpcStats              `json:"stats,omitempty"`
+    Error     string                 `json:"error,omitempty"`
+    Settings  map[string]interface{} `json:"settings,omitempty"`
+}
+
+type rpcResponse struct {
+    ID    uint64   `json:"id"`
+    Error string   `json:"error,omitempty"`
+    Dest  string   `json:"dest,omitempty"`
+    Data  []byte   `json:"data"`
+    Hooks []string `json:"hooks,omitempty"`
+}
+
+// External is a Hooks implementation backed by a plugin process.
+type External struct {
+    cfg ExternalConfig
+
+    mu     sync.Mutex
+    rc     *rpcConn
+    hooks  map[string]bool
+    closed bool
+}
+
+// NewExternal starts the plugin and performs the init handshake. After
+// that the process is supervised and restarted with backoff whenever it
+// exits, its connection fails or it leaves maxMissedCalls calls in a row
+// unanswered.
+func NewExternal(cfg ExternalConfig) (*External, error) {
+    if cfg.Command == "" && cfg.Socket == "" {
+        return nil, errors.New("external plugin needs a command or a socket")
+    }
+    if cfg.Timeout <= 0 {
+        cfg.Timeout = defaultExternalTimeout
+    }
+
+    e := &External{cfg: cfg}
+    rc, hooks, err := e.start()
+    if err != nil {
+        return nil, err
+    }
+    e.rc, e.hooks = rc, hooks
+    go e.supervise(rc)
+    return e, nil
+}
+
+func (e *External) Name() string {
+    return e.cfg.Name
+}
+
+// Close stops the plugin process. It is not restarted afterwards.
+func (e *External) Close() error {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    e.closed = true
+    if e.rc != nil {
+        e.rc.close()
+        e.rc = nil
+    }
+    return nil
+}
+
+func (e *External) supervise(rc *rpcConn) {
+    delay := time.Second
+    for {
+        <-rc.dead
+
+        e.mu.Lock()
+        if e.closed {
+            e.mu.Unlock()
+            return
+        }
+        e.rc = nil
+        e.mu.Unlock()
+        log.Printf("Plugin %s stopped: %v; restarting in %s", e.cfg.Name, rc.err(), delay)
+
+        for {
+            time.Sleep(delay)
+            if delay < maxRestartDelay {
+                delay *= 2
+            }
+
+            e.mu.Lock()
+            closed := e.closed
+            e.mu.Unlock()
+            if closed {
+                return
+            }
+
+            next, hooks, err := e.start()
+            if err != nil {
+                log.Printf("Plugin %s failed to restart: %v; retrying in %s", e.cfg.Name, err, delay)
+                continue
+            }
+
+            e.mu.Lock()
+            if e.closed {
+                e.mu.Unlock()
+                next.close()
+                return
+            }
+            e.rc, e.hooks = next, hooks
+            e.mu.Unlock()
+            log.Printf("Plugin %s restarted", e.cfg.Name)
+            rc = next
+            delay = time.Second
+            break
+        }
+    }
+}
+
+// start launches or connects to the plugin and runs the init handshake.
+func (e *External) start() (*rpcConn, map[string]bool, error) {
+    var (
+        rc  *rpcConn
+        err error
+    )
+    if e.cfg.Socket == "" {
+        rc, err = e.startStdio()
+    } else {
+        rc, err = e.startSocket()
+    }
+    if err != nil {
+        return nil, nil, err
+    }
+
+    resp, err := rc.call(&rpcRequest{Hook: "init", Settings: e.cfg.Settings}, e.cfg.Timeout)
+    if err == nil && resp.Error != "" {
+        err = errors.New(resp.Error)
+    }
+    if err != nil {
+        rc.close()
+        return nil, nil, fmt.Errorf("plugin %s: init: %w", e.cfg.Name, err)
+    }
+
+    hooks := make(map[string]bool)
+    for _, h := range resp.Hooks {
+        hooks[h] = true
+    }
+    return rc, hooks, nil
+}
+
+func (e *External) startStdio() (*rpcConn, error) {
+    cmd := exec.Command(e.cfg.Command, e.cfg.Args...)
+    cmd.Stderr = os.Stderr
+    stdin, err := cmd.StdinPipe()
+    if err != nil {
+        return nil, err
+    }
+    stdout, err := cmd.StdoutPipe()
+    if err != nil {
+        return nil, err
+    }
+    if err := cmd.Start(); err != nil {
+        return nil, err
+    }
+    return newRPCConn(stdout, stdin, cmd), nil
+}
+
+func (e *External) startSocket() (*rpcConn, error) {
+    var cmd *exec.Cmd
+    if e.cfg.Command != "" {
+        cmd = exec.Command(e.cfg.Command, e.cfg.Args...)
+        cmd.Stdout = os.Stderr
+        cmd.Stderr = os.Stderr
+        cmd.Env = append(os.Environ(), ExternalSocketEnv+"="+e.cfg.Socket)
+        if err := cmd.Start(); err != nil {
+            return nil, err
+        }
+    }
+
+    // Give a freshly started process a moment to create its socket.
+    deadline := time.Now().Add(5 * time.Second)
+    for {
+        conn, err := net.DialTimeout("unix", e.cfg.Socket, e.cfg.Timeout)
+        if err == nil {
+            return newRPCConn(conn, conn, cmd), nil
+        }
+        if time.Now().After(deadline) {
+            if cmd != nil {
+                cmd.Process.Kill()
+                cmd.Wait()
+            }
+            return nil, err
+        }
+        time.Sleep(100 * time.Millisecond)
+    }
+}
+
+// call sends a hook request if the plugin implements the hook. It returns
+// nil, nil when it does not.
+func (e *External) call(hook string, req *rpcRequest) (*rpcResponse, error) {
+    e.mu.Lock()
+    rc, implemented := e.rc, e.hooks[hook]
+    e.mu.Unlock()
+    if rc == nil {
+        return nil, ErrPluginUnavailable
+    }
+    if !implemented {
+        return nil, nil
+    }
+    req.Hook = hook
+    return rc.call(req, e.cfg.Timeout)
+}
+
+func (e *External) notify(hook string, req *rpcRequest) {
+    e.mu.Lock()
+    rc, implemented := e.rc, e.hooks[hook]
+    e.mu.Unlock()
+    if rc == nil || !implemented {
+        return
+    }
+    req.Hook = hook
+    rc.send(req)
+}
+
+// verdict turns the outcome of a call into the error a hook returns. A
+// plugin that cannot be reached rejects the connection unless FailOpen is
+// set.
+func (e *External) verdict(resp *rpcResponse, err error) error {
+    if err != nil {
+        if e.cfg.FailOpen {
+            return nil
+        }
+        return err
+    }
+    if resp != nil && resp.Error != "" {
+        return errors.New(resp.Error)
+    }
+    return nil
+}
+
+func connInfo(ctx *ConnContext) *rpcConnInfo {
+    info := &rpcConnInfo{
+        ID:          ctx.ID,
+        Listener:    ctx.Listener,
+        Protocol:    ctx.Protocol,
+        User:        ctx.User,
+        Destination: ctx.Destination,
+    }
+    if ctx.Conn != nil {
+        info.Remote = ctx.Conn.RemoteAddr().String()
+    }
+    return info
+}
+
+func (e *External) OnAccept(ctx *ConnContext) error {
+    return e.verdict(e.call("accept", &rpcRequest{Conn: connInfo(ctx)}))
+}
+
+func (e *External) OnAuth(ctx *ConnContext, user string) error {
+    return e.verdict(e.call("auth", &rpcRequest{Conn: connInfo(ctx), User: user}))
+}
+
+func (e *External) OnDial(ctx *ConnContext, dest string) (string, error) {
+    resp, err := e.call("dial", &rpcRequest{Conn: connInfo(ctx), Dest: dest})
+    if err := e.verdict(resp, err); err != nil {
+        return "", err
+    }
+    if resp != nil && resp.Dest != "" {
+        return resp.Dest, nil
+    }
+    return dest, nil
+}
+
+// SkipsData reports whether the plugin left "data" out of its hooks, so
+// that relays need not offer it every chunk.
+func (e *External) SkipsData() bool {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    return !e.hooks["data"]
+}
+
+// OnData passes data through unchanged when the plugin cannot be reached;
+// a relay cannot be rejected halfway through.
+func (e *External) OnData(ctx *ConnContext, dir Direction, data []byte) []byte {
+    resp, err := e.call("data", &rpcRequest{Conn: connInfo(ctx), Direction: dir.String(), Data: data})
+    if err != nil || resp == nil || resp.Data == nil {
+        return data
+    }
+    return resp.Data
+}
+
+func (e *External) OnClose(ctx *ConnContext, stats Stats) {
+    e.notify("close", &rpcRequest{Conn: connInfo(ctx), Stats: &rpcStats{
+        BytesUp:   stats.BytesUp,
+        BytesDown: stats.BytesDown,
+        Duration:  stats.Duration.Seconds(),
+    }})
+}
+
+func (e *External) OnError(ctx *ConnContext, err error) {
+    e.notify("error", &rpcRequest{Conn: connInfo(ctx), Error: err.Error()})
+}
+
+// rpcConn is one connection to a plugin process. It is dead once reading
+// from it fails; a new one is made on restart.
+type rpcConn struct {
+    w   io.WriteCloser
+    r   io.Reader
+    cmd *exec.Cmd
+
+    wmu sync.Mutex
+    enc *json.Encoder
+
+    mu      sync.Mutex
+    nextID  uint64
+    pending map[uint64]chan *rpcResponse
+    failure error
+    missed  int
+
+    dead      chan struct{}
+    closeOnce sync.Once
+}
+
+func newRPCConn(r io.Reader, w io.WriteCloser, cmd *exec.Cmd) *rpcConn {
+    rc := &rpcConn{
+        w:       w,
+        r:       r,
+        cmd:     cmd,
+        enc:     json.NewEncoder(w),
+        pending: make(map[uint64]chan *rpcResponse),
+        dead:    make(chan struct{}),
+    }
+    go rc.readLoop()
+    return rc
+}
+
+func (rc *rpcConn) readLoop() {
+    scanner := bufio.NewScanner(rc.r)
+    scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+    for scanner.Scan() {
+        var resp rpcResponse
+        if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+            rc.fail(fmt.Errorf("invalid message: %w", err))
+            return
+        }
+        rc.mu.Lock()
+        ch, ok := rc.pending[resp.ID]
+        delete(rc.pending, resp.ID)
+        rc.mu.Unlock()
+        if ok {
+            ch <- &resp
+        }
+    }
+    err := scanner.Err()
+    if err == nil {
+        err = io.EOF
+    }
+    rc.fail(err)
+}
+
+func (rc *rpcConn) send(req *rpcRequest) error {
+    rc.wmu.Lock()
+    defer rc.wmu.Unlock()
+    return rc.enc.Encode(req)
+}
+
+func (rc *rpcConn) call(req *rpcRequest, timeout time.Duration) (*rpcResponse, error) {
+    ch := make(chan *rpcResponse, 1)
+    rc.mu.Lock()
+    if rc.failure != nil {
+        rc.mu.Unlock()
+        return nil, ErrPluginUnavailable
+    }
+    rc.nextID++
+    req.ID = rc.nextID
+    rc.pending[req.ID] = ch
+    rc.mu.Unlock()
+
+    if err := rc.send(req); err != nil {
+        rc.fail(err)
+        return nil, err
+    }
+
+    timer := time.NewTimer(timeout)
+    defer timer.Stop()
+    select {
+    case resp := <-ch:
+        rc.mu.Lock()
+        rc.missed = 0
+        rc.mu.Unlock()
+        return resp, nil
+    case <-rc.dead:
+        return nil, ErrPluginUnavailable
+    case <-timer.C:
+        rc.mu.Lock()
+        delete(rc.pending, req.ID)
+        rc.missed++
+        hung := rc.missed >= maxMissedCalls
+        rc.mu.Unlock()
+        err := fmt.Errorf("plugin did not answer %s within %s", req.Hook, timeout)
+        if hung {
+            rc.fail(fmt.Errorf("%d calls in a row timed out", maxMissedCalls))
+        }
+        return nil, err
+    }
+}
+
+func (rc *rpcConn) err() error {
+    rc.mu.Lock()
+    defer rc.mu.Unlock()
+    return rc.failure
+}
+
+// fail marks the connection dead and stops the process behind it.
+func (rc *rpcConn) fail(err error) {
+    rc.closeOnce.Do(func() {
+        rc.mu.Lock()
+        rc.failure = err
+        rc.mu.Unlock()
+
+        rc.w.Close()
+        if c, ok := rc.r.(io.Closer); ok {
+            c.Close()
+        }
+        if rc.cmd != nil {
+            rc.cmd.Process.Kill()
+            rc.cmd.Wait()
+        }
+        close(rc.dead)
+    })
+}
+
+func (rc *rpcConn) close() {
+    rc.fail(errors.New("closed"))
+}
@@ -0,0 +1,136 @@
+package plugin
+
+import (
+    "bufio"
+    "encoding/json"
+    "net"
+    "os"
+    "testing"
+    "time"
+)
+
+// TestMain lets the test binary double as an external plugin.
+func TestMain(m *testing.M) {
+    if os.Getenv("LUNASOCKS_TEST_PLUGIN") == "1" {
+        runTestPlugin()
+        os.Exit(0)
+    }
+    os.Exit(m.Run())
+}
+
+// runTestPlugin blocks example.com, rewrites everything else to
+// example.net, exits when asked to dial crash.example and stops answering
+// when asked to dial hang.example.
+func runTestPlugin() {
+    scanner := bufio.NewScanner(os.Stdin)
+    enc := json.NewEncoder(os.Stdout)
+    for scanner.Scan() {
+        var req rpcRequest
+        json.Unmarshal(scanner.Bytes(), &req)
+        resp := rpcResponse{ID: req.ID}
+        switch req.Hook {
+        case "init":
+            resp.Hooks = []string{"dial"}
+        case "dial":
+            switch req.Dest {
+            case "crash.example:80":
+                os.Exit(1)
+            case "hang.example:80":
+                time.Sleep(time.Hour)
+            case "example.com:80":
+                resp.Error = "blocked"
+            default:
+                resp.Dest = "example.net:80"
+            }
+        }
+        enc.Encode(resp)
+    }
+}
+
+func TestExternalPlugin(t *testing.T) {
+    os.Setenv("LUNASOCKS_TEST_PLUGIN", "1")
+    defer os.Unsetenv("LUNASOCKS_TEST_PLUGIN")
+
+    exe, err := os.Executable()
+    if err != nil {
+        t.Fatal(err)
+    }
+    e, err := NewExternal(ExternalConfig{Name: "test", Command: exe, Timeout: time.Second})
+    if err != nil {
+        t.Fatalf("NewExternal failed: %v", err)
+    }
+    defer e.Close()
+
+    client, server := net.Pipe()
+    defer client.Close()
+    defer server.Close()
+    ctx := NewConnContext(server, "test", "socks5")
+
+    // accept is not implemented by the plugin, so it is never called.
+    if err := e.OnAccept(ctx); err != nil {
+        t.Errorf("OnAccept failed: %v", err)
+    }
+    if dest, err := e.OnDial(ctx, "example.org:80"); err != nil || dest != "example.net:80" {
+        t.Errorf("OnDial = %q, %v; want rewrite", dest, err)
+    }
+    if _, err := e.OnDial(ctx, "example.com:80"); err == nil || err.Error() != "blocked" {
+        t.Errorf("OnDial error = %v, want blocked", err)
+    }
+
+    if _, err := e.OnDial(ctx, "crash.example:80"); err == nil {
+        t.Error("Expected an error from a crashing plugin")
+    }
+
+    // The plugin is restarted after a second.
+    deadline := time.Now().Add(5 * time.Second)
+    for {
+        dest, err := e.OnDial(ctx, "example.org:80")
+        if err == nil && dest == "example.net:80" {
+            break
+        }
+        if time.Now().After(deadline) {
+            t.Fatalf("Plugin did not come back: %v", err)
+        }
+        time.Sleep(100 * time.Millisecond)
+    }
+}
+
+func TestExternalPluginHangs(t *testing.T) {
+    os.Setenv("LUNASOCKS_TEST_PLUGIN", "1")
+    defer os.Unsetenv("LUNASOCKS_TEST_PLUGIN")
+
+    exe, err := os.Executable()
+    if err != nil {
+        t.Fatal(err)
+    }
+    e, err := NewExternal(ExternalConfig{Name: "test", Command: exe, Timeout: 100 * time.Millisecond})
+    if err != nil {
+        t.Fatalf("NewExternal failed: %v", err)
+    }
+    defer e.Close()
+
+    client, server := net.Pipe()
+    defer client.Close()
+    defer server.Close()
+    ctx := NewConnContext(server, "test", "socks5")
+
+    // The hung process answers nothing more, so every call times out until
+    // it is killed and restarted.
+    for i := 0; i < maxMissedCalls; i++ {
+        if _, err := e.OnDial(ctx, "hang.example:80"); err == nil {
+            t.Fatal("Expected a timeout from a hung plugin")
+        }
+    }
+
+    deadline := time.Now().Add(5 * time.Second)
+    for {
+        dest, err := e.OnDial(ctx, "example.org:80")
+        if err == nil && dest == "example.net:80" {
+            break
+        }
+        if time.Now().After(deadline) {
+            t.Fatalf("Hung plugin was not restarted: %v", err)
+        }
+        time.Sleep(100 * time.Millisecond)
+    }
+}
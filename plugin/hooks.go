@@ -0,0 +1,182 @@
+package plugin
+
+import (
+    "net"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// Direction tells OnData which way relayed data is flowing.
+type Direction int
+
+const (
+    // Upstream is data from the client towards the destination.
+    Upstream Direction = iota
+    // Downstream is data from the destination back to the client.
+    Downstream
+)
+
+func (d Direction) String() string {
+    if d == Downstream {
+        return "downstream"
+    }
+    return "upstream"
+}
+
+// Stats summarizes a finished connection for OnClose.
+type Stats struct {
+    BytesUp   int64
+    BytesDown int64
+    Duration  time.Duration
+}
+
+var nextConnID uint64
+
+// ConnContext follows one client connection through the hooks. User and
+// Destination are filled in as authentication and dialing succeed.
+// Identity is the verified TLS client certificate's identity, if the
+// listener asks for one; it is known from OnAccept on, and protocols
+// without their own user names authenticate as it.
+type ConnContext struct {
+    ID          uint64
+    Listener    string
+    Protocol    string
+    Conn        net.Conn
+    User        string
+    Identity    string
+    Destination string
+    Start       time.Time
+
+    bytesUp   int64
+    bytesDown int64
+
+    mu     sync.Mutex
+    values map[string]interface{}
+}
+
+// NewConnContext wraps conn so that the bytes read from and written to the
+// client are counted. Handlers must use ctx.Conn from then on.
+func NewConnContext(conn net.Conn, listener, protocol string) *ConnContext {
+    ctx := &ConnContext{
+        ID:       atomic.AddUint64(&nextConnID, 1),
+        Listener: listener,
+        Protocol: protocol,
+        Start:    time.Now(),
+    }
+    ctx.Conn = &countingConn{Conn: conn, ctx: ctx}
+    return ctx
+}
+
+// Set stores a per-connection value for later hooks.
+func (c *ConnContext) Set(key string, value interface{}) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.values == nil {
+        c.values = make(map[string]interface{})
+    }
+    c.values[key] = value
+}
+
+func (c *ConnContext) Get(key string) (interface{}, bool) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    v, ok := c.values[key]
+    return v, ok
+}
+
+// Unwrap returns the client connection without the byte counting, for
+// relays that copy between raw sockets so that the kernel can move the
+// data. They report what they copied with AddBytes.
+func (c *ConnContext) Unwrap() net.Conn {
+    if cc, ok := c.Conn.(*countingConn); ok {
+        return cc.Conn
+    }
+    return c.Conn
+}
+
+// AddBytes counts bytes read from (up) and written to (down) the client
+// outside ctx.Conn.
+func (c *ConnContext) AddBytes(up, down int64) {
+    atomic.AddInt64(&c.bytesUp, up)
+    atomic.AddInt64(&c.bytesDown, down)
+}
+
+func (c *ConnContext) Stats() Stats {
+    return Stats{
+        BytesUp:   atomic.LoadInt64(&c.bytesUp),
+        BytesDown: atomic.LoadInt64(&c.bytesDown),
+        Duration:  time.Since(c.Start),
+    }
+}
+
+type countingConn struct {
+    net.Conn
+    ctx *ConnContext
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+    n, err := c.Conn.Read(b)
+    atomic.AddInt64(&c.ctx.bytesUp, int64(n))
+    return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+    n, err := c.Conn.Write(b)
+    atomic.AddInt64(&c.ctx.bytesDown, int64(n))
+    return n, err
+}
+
+// Hooks is the plugin lifecycle interface. Each connection goes through
+// OnAccept, OnAuth and OnDial in that order, OnData for every chunk relayed
+// in either direction, and OnClose once it is done. OnError reports
+// failures along the way. Embed BaseHooks to implement only some of them.
+type Hooks interface {
+    Name() string
+    // OnAccept is called for every new connection; an error rejects it.
+    OnAccept(ctx *ConnContext) error
+    // OnAuth is called once the client has authenticated as user, which is
+    // empty for protocols or listeners without user names. An error rejects
+    // the connection.
+    OnAuth(ctx *ConnContext, user string) error
+    // OnDial may rewrite the destination or block it by returning an error.
+    OnDial(ctx *ConnContext, dest string) (string, error)
+    OnData(ctx *ConnContext, dir Direction, data []byte) []byte
+    OnClose(ctx *ConnContext, stats Stats)
+    OnError(ctx *ConnContext, err error)
+}
+
+// BaseHooks implements every hook as a no-op.
+type BaseHooks struct{}
+
+func (BaseHooks) OnAccept(ctx *ConnContext) error                      { return nil }
+func (BaseHooks) OnAuth(ctx *ConnContext, user string) error           { return nil }
+func (BaseHooks) OnDial(ctx *ConnContext, dest string) (string, error) { return dest, nil }
+func (BaseHooks) OnData(ctx *ConnContext, dir Direction, data []byte) []byte {
+    return data
+}
+func (BaseHooks) OnClose(ctx *ConnContext, stats Stats) {}
+func (BaseHooks) OnError(ctx *ConnContext, err error)   {}
+
+// Adapt turns a v1 Plugin into Hooks: OnConnect runs on accept and OnData
+// sees upstream data only, as it always did.
+func Adapt(p Plugin) Hooks {
+    return legacy{p}
+}
+
+type legacy struct {
+    p Plugin
+}
+
+func (l legacy) Name() string                                         { return l.p.Name() }
+func (l legacy) OnAccept(ctx *ConnContext) error                      { l.p.OnConnect(ctx.Conn); return nil }
+func (l legacy) OnAuth(ctx *ConnContext, user string) error           { return nil }
+func (l legacy) OnDial(ctx *ConnContext, dest string) (string, error) { return dest, nil }
+func (l legacy) OnData(ctx *ConnContext, dir Direction, data []byte) []byte {
+    if dir != Upstream {
+        return data
+    }
+    return l.p.OnData(data)
+}
+func (l legacy) OnClose(ctx *ConnContext, stats Stats) {}
+func (l legacy) OnError(ctx *ConnContext, err error)   {}
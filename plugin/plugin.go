@@ -0,0 +1,51 @@
+package plugin
+
+import (
+    "log"
+    "net"
+    "time"
+)
+
+// Plugin is the original plugin interface. It is still accepted everywhere
+// hooks are; see Adapt for how its methods map onto Hooks.
+type Plugin interface {
+    Name() string
+    OnConnect(conn net.Conn)
+    OnData(data []byte) []byte
+}
+
+type LoggingPlugin struct{}
+
+func (p *LoggingPlugin) Name() string {
+    return "LoggingPlugin"
+}
+
+func (p *LoggingPlugin) OnConnect(conn net.Conn) {
+    log.Printf("New connection from: %s", conn.RemoteAddr())
+}
+
+func (p *LoggingPlugin) OnData(data []byte) []byte {
+    log.Printf("Data received: %d bytes", len(data))
+    return data
+}
+
+// connLogger is the "logging" plugin: it logs every connection as it is
+// accepted and again with its traffic when it closes. Unlike
+// LoggingPlugin it never looks at the data, so relays keep their fast
+// path.
+type connLogger struct {
+    BaseHooks
+}
+
+func (connLogger) Name() string    { return "logging" }
+func (connLogger) SkipsData() bool { return true }
+
+func (connLogger) OnAccept(ctx *ConnContext) error {
+    log.Printf("New connection from: %s", ctx.Conn.RemoteAddr())
+    return nil
+}
+
+func (connLogger) OnClose(ctx *ConnContext, stats Stats) {
+    log.Printf("Connection from %s closed: %d bytes up, %d bytes down in %s",
+        ctx.Conn.RemoteAddr(), stats.BytesUp, stats.BytesDown, stats.Duration.Round(time.Millisecond))
+}
@@ -0,0 +1,59 @@
+package plugin
+
+import (
+    "bytes"
+    "io"
+    "strings"
+    "testing"
+)
+
+// tagger appends its tag to everything it reads and writes.
+type tagger struct {
+    BaseHooks
+    tag string
+}
+
+func (t *tagger) Name() string { return t.tag }
+
+func (t *tagger) WrapReader(ctx *ConnContext, dir Direction, r io.Reader) io.Reader {
+    return io.MultiReader(r, strings.NewReader(t.tag))
+}
+
+func (t *tagger) WrapWriter(ctx *ConnContext, dir Direction, w io.Writer) io.Writer {
+    return writerFunc(func(b []byte) (int, error) {
+        if _, err := w.Write(append(append([]byte{}, b...), t.tag...)); err != nil {
+            return 0, err
+        }
+        return len(b), nil
+    })
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(b []byte) (int, error) { return f(b) }
+
+func TestWrapOrder(t *testing.T) {
+    chain := Chain{&tagger{tag: "1"}, Adapt(&LoggingPlugin{}), &tagger{tag: "2"}}
+    if !chain.Transforms(&ConnContext{}) {
+        t.Fatal("Expected chain to transform")
+    }
+
+    var out bytes.Buffer
+    r, w := chain.Wrap(&ConnContext{}, Upstream, strings.NewReader("x"), &out)
+    data, err := io.ReadAll(r)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if string(data) != "x12" {
+        t.Errorf("Reader produced %q, want %q", data, "x12")
+    }
+
+    w.Write([]byte("y"))
+    if out.String() != "y12" {
+        t.Errorf("Writer produced %q, want %q", out.String(), "y12")
+    }
+
+    if (Chain{Adapt(&LoggingPlugin{})}).Transforms(&ConnContext{}) {
+        t.Error("v1 plugins must not disable zero-copy relaying")
+    }
+}
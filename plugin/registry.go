@@ -0,0 +1,77 @@
+package plugin
+
+import (
+    "encoding/json"
+    "fmt"
+    "sort"
+    "sync"
+)
+
+// Factory creates a plugin instance from its settings in the config file.
+// settings is nil when the config has none.
+type Factory func(settings map[string]interface{}) (Hooks, error)
+
+var (
+    registryMu sync.RWMutex
+    registry   = make(map[string]Factory)
+)
+
+// Register makes a plugin available under name. It is meant to be called
+// from init functions and panics if name is taken.
+func Register(name string, factory Factory) {
+    registryMu.Lock()
+    defer registryMu.Unlock()
+    if factory == nil {
+        panic("plugin: Register factory is nil")
+    }
+    if _, dup := registry[name]; dup {
+        panic("plugin: Register called twice for " + name)
+    }
+    registry[name] = factory
+}
+
+// New creates an instance of the plugin registered as name.
+func New(name string, settings map[string]interface{}) (Hooks, error) {
+    registryMu.RLock()
+    factory, ok := registry[name]
+    registryMu.RUnlock()
+    if !ok {
+        return nil, fmt.Errorf("plugin %q is not registered", name)
+    }
+    h, err := factory(settings)
+    if err != nil {
+        return nil, fmt.Errorf("plugin %s: %w", name, err)
+    }
+    return h, nil
+}
+
+// Registered returns the names of all registered plugins, sorted.
+func Registered() []string {
+    registryMu.RLock()
+    defer registryMu.RUnlock()
+    names := make([]string, 0, len(registry))
+    for name := range registry {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// DecodeSettings fills v, a pointer to a struct with json tags, from the
+// settings passed to a Factory.
+func DecodeSettings(settings map[string]interface{}, v interface{}) error {
+    if settings == nil {
+        return nil
+    }
+    data, err := json.Marshal(settings)
+    if err != nil {
+        return err
+    }
+    return json.Unmarshal(data, v)
+}
+
+func init() {
+    Register("logging", func(map[string]interface{}) (Hooks, error) {
+        return connLogger{}, nil
+    })
+}
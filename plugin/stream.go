@@ -0,0 +1,75 @@
+package plugin
+
+import "io"
+
+// Transformer is implemented by plugins that act on relayed data as a
+// stream instead of chunk by chunk. For each direction, WrapReader wraps
+// the side data is read from and WrapWriter the side it is written to;
+// either may return its argument unchanged.
+type Transformer interface {
+    WrapReader(ctx *ConnContext, dir Direction, r io.Reader) io.Reader
+    WrapWriter(ctx *ConnContext, dir Direction, w io.Writer) io.Writer
+}
+
+// ConnTransformer is a Transformer that only acts on some connections,
+// such as those of users with limits. Relays leave the others unwrapped.
+type ConnTransformer interface {
+    Transformer
+    TransformsConn(ctx *ConnContext) bool
+}
+
+// transforms reports whether h wraps the relay of ctx.
+func transforms(h Hooks, ctx *ConnContext) bool {
+    if t, ok := h.(ConnTransformer); ok {
+        return t.TransformsConn(ctx)
+    }
+    _, ok := h.(Transformer)
+    return ok
+}
+
+// Transforms reports whether any plugin in c transforms the relay of ctx.
+// Relays skip wrapping altogether when it is false.
+func (c Chain) Transforms(ctx *ConnContext) bool {
+    for _, h := range c {
+        if transforms(h, ctx) {
+            return true
+        }
+    }
+    return false
+}
+
+// DataSkipper is implemented by plugins whose OnData leaves data as it is,
+// typically by keeping the one from BaseHooks. Relays that only meet such
+// plugins need not look at the data at all.
+type DataSkipper interface {
+    SkipsData() bool
+}
+
+// InspectsData reports whether any plugin in c wants OnData called for
+// every chunk. Plugins that do not implement DataSkipper are assumed to.
+func (c Chain) InspectsData() bool {
+    for _, h := range c {
+        if d, ok := h.(DataSkipper); !ok || !d.SkipsData() {
+            return true
+        }
+    }
+    return false
+}
+
+// Wrap applies the transformers in c to one direction of a relay. Data
+// passes through them in chain order on both sides: the first plugin's
+// reader sees the raw input first and the first plugin's writer sees the
+// output first.
+func (c Chain) Wrap(ctx *ConnContext, dir Direction, r io.Reader, w io.Writer) (io.Reader, io.Writer) {
+    for _, h := range c {
+        if transforms(h, ctx) {
+            r = h.(Transformer).WrapReader(ctx, dir, r)
+        }
+    }
+    for i := len(c) - 1; i >= 0; i-- {
+        if transforms(c[i], ctx) {
+            w = c[i].(Transformer).WrapWriter(ctx, dir, w)
+        }
+    }
+    return r, w
+}
@@ -0,0 +1,111 @@
+package protocol
+
+import (
+    "encoding/binary"
+    "errors"
+    "io"
+
+    "lunasocks/internal/logging"
+    "your_project/mux"
+    "your_project/plugin"
+    "your_project/transport"
+)
+
+// MuxAddress is the destination a client asks for to turn its connection
+// into a multiplexed session. The rest of the connection is a mux session
+// carried in sealed chunks, and every stream starts with its own
+// destination (see WriteStreamAddress).
+const MuxAddress = "mux.lunasocks.invalid:0"
+
+// SetMux sets the limits of the mux sessions clients open.
+func (s *Shadowsocks) SetMux(cfg mux.Config) error {
+    if err := cfg.Validate(); err != nil {
+        return err
+    }
+    s.mux = cfg
+    return nil
+}
+
+// DisableMux refuses the mux sessions clients open.
+func (s *Shadowsocks) DisableMux() {
+    s.noMux = true
+}
+
+// serveMux runs a session on ctx.Conn and relays each of its streams. The
+// session itself already passed the accept and auth hooks; streams get
+// their own context so plugins see one connection per proxied request.
+func (s *Shadowsocks) serveMux(ctx *plugin.ConnContext, cipher transport.Sealer) {
+    if s.noMux {
+        logging.Info("Mux session from %s refused: not enabled", ctx.Conn.RemoteAddr())
+        return
+    }
+    sess, err := mux.Server(transport.Seal(ctx.Conn, cipher), s.mux)
+    if err != nil {
+        logging.Error("Failed to start mux session: %v", err)
+        return
+    }
+    defer sess.Close()
+
+    for {
+        stream, err := sess.AcceptStream()
+        if err != nil {
+            logging.Info("Mux session from %s closed: %v", ctx.Conn.RemoteAddr(), err)
+            return
+        }
+        go s.serveStream(ctx, stream)
+    }
+}
+
+func (s *Shadowsocks) serveStream(session *plugin.ConnContext, stream *mux.Stream) {
+    defer stream.Close()
+    if !s.track(stream) {
+        return
+    }
+    defer s.untrack(stream)
+
+    ctx := plugin.NewConnContext(stream, session.Listener, session.Protocol)
+    ctx.Identity = session.Identity
+    if err := s.hooks.Accept(ctx); err != nil {
+        logging.Info("Stream from %s %v", stream.RemoteAddr(), err)
+        return
+    }
+    defer s.hooks.Close(ctx)
+    if err := s.hooks.Auth(ctx, session.User); err != nil {
+        logging.Info("Stream from %s %v", stream.RemoteAddr(), err)
+        return
+    }
+
+    addr, err := ReadStreamAddress(ctx.Conn)
+    if err != nil {
+        logging.Error("Failed to read stream address: %v", err)
+        s.hooks.Error(ctx, err)
+        return
+    }
+    // The session is already encrypted as a whole.
+    s.relay(ctx, addr, ctx.Conn)
+}
+
+// WriteStreamAddress sends the destination of a mux stream: a 2 byte
+// length followed by the address.
+func WriteStreamAddress(w io.Writer, addr string) error {
+    if len(addr) > 0xffff {
+        return errors.New("address too long")
+    }
+    buf := make([]byte, 2, 2+len(addr))
+    binary.BigEndian.PutUint16(buf, uint16(len(addr)))
+    _, err := w.Write(append(buf, addr...))
+    return err
+}
+
+// ReadStreamAddress reads a destination written by WriteStreamAddress.
+func ReadStreamAddress(r io.Reader) (string, error) {
+    var size [2]byte
+    if _, err := io.ReadFull(r, size[:]); err != nil {
+        return "", err
+    }
+    addr := make([]byte, binary.BigEndian.Uint16(size[:]))
+    if _, err := io.ReadFull(r, addr); err != nil {
+        return "", err
+    }
+    return string(addr), nil
+}
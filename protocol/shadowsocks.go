@@ -1,100 +1,229 @@
 package protocol
 
 import (
+    "context"
     "encoding/binary"
     "errors"
     "io"
     "net"
+    "sync"
     "time"
 
     "lunasocks/internal/crypto"
     "lunasocks/internal/logging"
     "lunasocks/pkg/utils"
+    "your_project/mux"
+    "your_project/plugin"
+    "your_project/transport"
 )
 
 type Shadowsocks struct {
     cipher  *crypto.AEADCipher
     timeout time.Duration
     pool    *utils.Pool
+    hooks   plugin.Chain
+    mux     mux.Config
+    noMux   bool
+    udp     UDPRelay
+    users   func() []UserCipher
+
+    mu      sync.Mutex
+    active  map[net.Conn]struct{}
+    wg      sync.WaitGroup
+    closing bool
 }
 
+// NewShadowsocks creates a handler for clients using password. With an
+// empty password only the users set with SetUsers are accepted.
 func NewShadowsocks(password, method string, timeout time.Duration) (*Shadowsocks, error) {
-    salt := make([]byte, 16)
-    if _, err := io.ReadFull(rand.Reader, salt); err != nil {
-        return nil, err
-    }
-
-    key, err := crypto.DeriveKey(password, salt, 32) // AES-256 key size
-    if err != nil {
-        return nil, err
-    }
-
-    cipher, err := crypto.NewAEADCipher(key, method)
-    if err != nil {
-        return nil, err
+    var cipher *crypto.AEADCipher
+    if password != "" {
+        var err error
+        if cipher, err = NewCipher(password, method); err != nil {
+            return nil, err
+        }
     }
 
     return &Shadowsocks{
         cipher:  cipher,
         timeout: timeout,
         pool:    utils.NewPool(4096),
+        mux:     mux.DefaultConfig(),
+        active:  make(map[net.Conn]struct{}),
     }, nil
 }
 
+// SetHooks installs the plugins run for every relayed connection. It must
+// be called before the first connection is handled.
+func (s *Shadowsocks) SetHooks(hooks plugin.Chain) {
+    s.hooks = hooks
+}
+
+// HandleConnection serves a connection accepted outside network.Server,
+// running the accept and close hooks itself.
 func (s *Shadowsocks) HandleConnection(clientConn net.Conn) {
     defer clientConn.Close()
 
+    ctx := plugin.NewConnContext(clientConn, "", "shadowsocks")
+    if err := s.hooks.Accept(ctx); err != nil {
+        logging.Info("Connection from %s %v", clientConn.RemoteAddr(), err)
+        return
+    }
+    defer s.hooks.Close(ctx)
+
+    s.Serve(ctx)
+}
+
+// Serve relays a connection whose OnAccept hooks already ran. The caller
+// owns ctx.Conn and runs the close hooks.
+func (s *Shadowsocks) Serve(ctx *plugin.ConnContext) {
+    clientConn := ctx.Conn
+    if !s.track(clientConn) {
+        return
+    }
+    defer s.untrack(clientConn)
+
     // Read the destination address
     encryptedAddr, err := s.ReadEncrypted(clientConn)
     if err != nil {
         logging.Error("Failed to read encrypted address: %v", err)
+        s.hooks.Error(ctx, err)
         return
     }
 
-    addr, err := s.cipher.Decrypt(encryptedAddr)
+    cipher, user, addr, err := s.open(encryptedAddr)
     if err != nil {
         logging.Error("Failed to decrypt address: %v", err)
+        s.hooks.Error(ctx, err)
+        return
+    }
+
+    // A successfully decrypted request proves knowledge of the key.
+    if user == "" {
+        user = ctx.Identity
+    }
+    if err := s.hooks.Auth(ctx, user); err != nil {
+        logging.Info("Connection from %s %v", clientConn.RemoteAddr(), err)
+        return
+    }
+
+    switch string(addr) {
+    case MuxAddress:
+        s.serveMux(ctx, cipher)
+        return
+    case UDPOverTCPAddress:
+        s.serveUDP(ctx, cipher)
+        return
+    }
+    // The rest of the stream is carried in sealed chunks both ways, framed
+    // like mux sessions so that chunk boundaries survive TCP.
+    s.relay(ctx, string(addr), transport.Seal(clientConn, cipher))
+}
+
+// relay dials addr and proxies between it and client, which is ctx.Conn
+// or an encrypted view of it.
+func (s *Shadowsocks) relay(ctx *plugin.ConnContext, addr string, client net.Conn) {
+    dest, err := s.hooks.Dial(ctx, addr)
+    if err != nil {
+        logging.Info("Connection to %s %v", addr, err)
         return
     }
 
     // Connect to the destination
-    destConn, err := net.DialTimeout("tcp", string(addr), s.timeout)
+    destConn, err := net.DialTimeout("tcp", dest, s.timeout)
     if err != nil {
         logging.Error("Failed to connect to destination: %v", err)
+        s.hooks.Error(ctx, err)
         return
     }
     defer destConn.Close()
 
     // Start proxying data
     errChan := make(chan error, 2)
-    go s.proxyData(clientConn, destConn, errChan)
-    go s.proxyData(destConn, clientConn, errChan)
+    go s.proxyData(ctx, client, destConn, plugin.Upstream, errChan)
+    go s.proxyData(ctx, destConn, client, plugin.Downstream, errChan)
 
     // Wait for any error
     err = <-errChan
+    if err != io.EOF {
+        s.hooks.Error(ctx, err)
+    }
     logging.Info("Connection closed: %v", err)
 }
 
-func (s *Shadowsocks) proxyData(src, dst net.Conn, errChan chan<- error) {
+func (s *Shadowsocks) track(conn net.Conn) bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.closing {
+        return false
+    }
+    s.active[conn] = struct{}{}
+    s.wg.Add(1)
+    return true
+}
+
+func (s *Shadowsocks) untrack(conn net.Conn) {
+    s.mu.Lock()
+    delete(s.active, conn)
+    s.mu.Unlock()
+    s.wg.Done()
+}
+
+// Shutdown stops accepting new relays and waits for the active ones to
+// finish. Relays still running when ctx expires are closed.
+func (s *Shadowsocks) Shutdown(ctx context.Context) error {
+    s.mu.Lock()
+    s.closing = true
+    s.mu.Unlock()
+
+    done := make(chan struct{})
+    go func() {
+        s.wg.Wait()
+        close(done)
+    }()
+
+    select {
+    case <-done:
+        return nil
+    case <-ctx.Done():
+        s.mu.Lock()
+        for conn := range s.active {
+            conn.Close()
+        }
+        s.mu.Unlock()
+        <-done
+        return ctx.Err()
+    }
+}
+
+// proxyData relays one direction. Streaming plugins wrap the read and write
+// sides; without them the connections are used directly.
+func (s *Shadowsocks) proxyData(ctx *plugin.ConnContext, src, dst net.Conn, dir plugin.Direction, errChan chan<- error) {
     buf := s.pool.Get()
     defer s.pool.Put(buf)
 
+    var r io.Reader = src
+    var w io.Writer = dst
+    if s.hooks.Transforms(ctx) {
+        r, w = s.hooks.Wrap(ctx, dir, src, dst)
+    }
+    inspect := s.hooks.InspectsData()
+
     for {
         src.SetReadDeadline(time.Now().Add(s.timeout))
-        n, err := src.Read(buf)
+        n, err := r.Read(buf)
         if err != nil {
             errChan <- err
             return
         }
 
-        data, err := s.cipher.Encrypt(buf[:n])
-        if err != nil {
-            errChan <- err
-            return
+        data := buf[:n]
+        if inspect {
+            data = s.hooks.Data(ctx, dir, data)
         }
 
         dst.SetWriteDeadline(time.Now().Add(s.timeout))
-        _, err = dst.Write(data)
+        _, err = w.Write(data)
         if err != nil {
             errChan <- err
             return
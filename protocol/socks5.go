@@ -12,6 +12,9 @@ import (
 
 const (
     Version5       = 0x05
+    MethodNoAuth   = 0x00
+    MethodUserPass = 0x02
+    MethodNone     = 0xFF
     CmdConnect     = 0x01
     AtypIPv4       = 0x01
     AtypDomainName = 0x03
@@ -19,7 +22,13 @@ const (
 )
 
 func HandleSocks5(conn net.Conn) (string, error) {
-    if err := socks5Handshake(conn); err != nil {
+    return HandleSocks5WithAuth(conn, nil)
+}
+
+// HandleSocks5WithAuth is like HandleSocks5 but requires username/password
+// authentication (RFC 1929) checked by auth. A nil auth accepts any client.
+func HandleSocks5WithAuth(conn net.Conn, auth func(username, password string) bool) (string, error) {
+    if err := socks5Handshake(conn, auth); err != nil {
         return "", err
     }
 
@@ -39,7 +48,7 @@ func HandleSocks5(conn net.Conn) (string, error) {
     return addr, nil
 }
 
-func socks5Handshake(conn net.Conn) error {
+func socks5Handshake(conn net.Conn, auth func(username, password string) bool) error {
     buf := make([]byte, 2)
     if _, err := io.ReadFull(conn, buf); err != nil {
         return err
@@ -55,7 +64,52 @@ func socks5Handshake(conn net.Conn) error {
         return err
     }
 
-    _, err := conn.Write([]byte{Version5, 0x00})
+    if auth == nil {
+        _, err := conn.Write([]byte{Version5, MethodNoAuth})
+        return err
+    }
+
+    offered := false
+    for _, m := range methods {
+        if m == MethodUserPass {
+            offered = true
+        }
+    }
+    if !offered {
+        conn.Write([]byte{Version5, MethodNone})
+        return errors.New("client does not support username/password authentication")
+    }
+    if _, err := conn.Write([]byte{Version5, MethodUserPass}); err != nil {
+        return err
+    }
+    return socks5UserPassAuth(conn, auth)
+}
+
+func socks5UserPassAuth(conn net.Conn, auth func(username, password string) bool) error {
+    buf := make([]byte, 2)
+    if _, err := io.ReadFull(conn, buf); err != nil {
+        return err
+    }
+    if buf[0] != 0x01 {
+        return errors.New("invalid auth version")
+    }
+    username := make([]byte, buf[1])
+    if _, err := io.ReadFull(conn, username); err != nil {
+        return err
+    }
+    if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+        return err
+    }
+    password := make([]byte, buf[0])
+    if _, err := io.ReadFull(conn, password); err != nil {
+        return err
+    }
+
+    if !auth(string(username), string(password)) {
+        conn.Write([]byte{0x01, 0x01})
+        return errors.New("invalid username or password")
+    }
+    _, err := conn.Write([]byte{0x01, 0x00})
     return err
 }
 
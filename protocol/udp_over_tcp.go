@@ -0,0 +1,60 @@
+package protocol
+
+import (
+    "encoding/binary"
+    "errors"
+    "io"
+    "net"
+
+    "lunasocks/internal/logging"
+    "your_project/plugin"
+    "your_project/transport"
+)
+
+// UDPOverTCPAddress is the destination a client asks for to send UDP over
+// the TCP connection. The rest of the connection is carried in sealed
+// chunks and holds datagrams framed by WritePacket, each a SOCKS5 UDP
+// request: the destination in one direction, the source in the other.
+const UDPOverTCPAddress = "udp.lunasocks.invalid:0"
+
+// UDPRelay serves a UDP-over-TCP association on conn, which reads and
+// writes framed datagrams.
+type UDPRelay func(ctx *plugin.ConnContext, conn net.Conn)
+
+// SetUDPOverTCP accepts UDP-over-TCP associations and hands them to relay.
+// A nil relay refuses them.
+func (s *Shadowsocks) SetUDPOverTCP(relay UDPRelay) {
+    s.udp = relay
+}
+
+func (s *Shadowsocks) serveUDP(ctx *plugin.ConnContext, cipher transport.Sealer) {
+    if s.udp == nil {
+        logging.Info("UDP over TCP from %s refused: not enabled", ctx.Conn.RemoteAddr())
+        return
+    }
+    s.udp(ctx, transport.Seal(ctx.Conn, cipher))
+}
+
+// WritePacket writes one datagram with a 2 byte length prefix.
+func WritePacket(w io.Writer, p []byte) error {
+    if len(p) > 0xffff {
+        return errors.New("packet too large")
+    }
+    buf := make([]byte, 2, 2+len(p))
+    binary.BigEndian.PutUint16(buf, uint16(len(p)))
+    _, err := w.Write(append(buf, p...))
+    return err
+}
+
+// ReadPacket reads a datagram written by WritePacket.
+func ReadPacket(r io.Reader) ([]byte, error) {
+    var size [2]byte
+    if _, err := io.ReadFull(r, size[:]); err != nil {
+        return nil, err
+    }
+    p := make([]byte, binary.BigEndian.Uint16(size[:]))
+    if _, err := io.ReadFull(r, p); err != nil {
+        return nil, err
+    }
+    return p, nil
+}
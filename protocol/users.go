@@ -0,0 +1,55 @@
+package protocol
+
+import (
+    "errors"
+
+    "lunasocks/internal/crypto"
+)
+
+// UserCipher is the key of one user a shadowsocks listener accepts.
+type UserCipher struct {
+    Name   string
+    Cipher *crypto.AEADCipher
+}
+
+var errNoKey = errors.New("no key decrypts the request")
+
+// NewCipher derives a cipher from password with EVP_BytesToKey, so that
+// clients configured with the same password derive the same key.
+func NewCipher(password, method string) (*crypto.AEADCipher, error) {
+    return crypto.NewAEADCipher(crypto.EVPBytesToKey(password, 32), method) // AES-256 key size
+}
+
+// NewKeyCipher creates a cipher using key as is.
+func NewKeyCipher(key []byte, method string) (*crypto.AEADCipher, error) {
+    return crypto.NewAEADCipher(key, method)
+}
+
+// SetUsers makes the listener accept the users returned by users as well
+// as its own password. It is called for every connection the listener's
+// own key cannot decrypt, and its keys are tried in order.
+func (s *Shadowsocks) SetUsers(users func() []UserCipher) {
+    s.users = users
+}
+
+// open decrypts the first chunk of a connection and returns the cipher
+// that did it, the user it belongs to ("" for the listener's own key) and
+// the plaintext.
+func (s *Shadowsocks) open(data []byte) (*crypto.AEADCipher, string, []byte, error) {
+    err := errNoKey
+    if s.cipher != nil {
+        addr, derr := s.cipher.Decrypt(data)
+        if derr == nil {
+            return s.cipher, "", addr, nil
+        }
+        err = derr
+    }
+    if s.users != nil {
+        for _, u := range s.users() {
+            if addr, derr := u.Cipher.Decrypt(data); derr == nil {
+                return u.Cipher, u.Name, addr, nil
+            }
+        }
+    }
+    return nil, "", nil, err
+}
@@ -0,0 +1,174 @@
+package sip
+
+import (
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "net"
+    "net/url"
+    "strconv"
+    "strings"
+)
+
+var (
+    ErrInvalidScheme   = errors.New("not an ss:// URI")
+    ErrInvalidUserInfo = errors.New("invalid method:password in URI")
+    ErrMissingPort     = errors.New("missing server port in URI")
+)
+
+// Server describes one shadowsocks server. The JSON form is a SIP008
+// server object.
+type Server struct {
+    ID         string `json:"id"`
+    Remarks    string `json:"remarks,omitempty"`
+    Server     string `json:"server"`
+    ServerPort int    `json:"server_port"`
+    Password   string `json:"password"`
+    Method     string `json:"method"`
+    Plugin     string `json:"plugin,omitempty"`
+    PluginOpts string `json:"plugin_opts,omitempty"`
+}
+
+// standardMethods maps our cipher names to the names shadowsocks clients
+// expect, and back.
+var standardMethods = map[string]string{
+    "chacha20-poly1305": "chacha20-ietf-poly1305",
+}
+
+func StandardMethod(method string) string {
+    if m, ok := standardMethods[method]; ok {
+        return m
+    }
+    return method
+}
+
+func LocalMethod(method string) string {
+    for local, std := range standardMethods {
+        if std == method {
+            return local
+        }
+    }
+    return method
+}
+
+// URI returns the SIP002 ss:// URI for the server.
+func (s *Server) URI() string {
+    userInfo := base64.RawURLEncoding.EncodeToString([]byte(StandardMethod(s.Method) + ":" + s.Password))
+
+    var b strings.Builder
+    b.WriteString("ss://")
+    b.WriteString(userInfo)
+    b.WriteString("@")
+    b.WriteString(net.JoinHostPort(s.Server, strconv.Itoa(s.ServerPort)))
+    if s.Plugin != "" {
+        plugin := s.Plugin
+        if s.PluginOpts != "" {
+            plugin += ";" + s.PluginOpts
+        }
+        b.WriteString("/?plugin=")
+        b.WriteString(url.QueryEscape(plugin))
+    }
+    if s.Remarks != "" {
+        b.WriteString("#")
+        b.WriteString(url.PathEscape(s.Remarks))
+    }
+    return b.String()
+}
+
+// ParseURI parses a SIP002 ss:// URI. The legacy form, where everything
+// before the fragment is base64 encoded, is accepted as well.
+func ParseURI(uri string) (*Server, error) {
+    if !strings.HasPrefix(uri, "ss://") {
+        return nil, ErrInvalidScheme
+    }
+    if !strings.Contains(uri, "@") {
+        legacy, err := decodeLegacy(uri)
+        if err != nil {
+            return nil, err
+        }
+        uri = legacy
+    }
+
+    u, err := url.Parse(uri)
+    if err != nil {
+        return nil, err
+    }
+    if u.User == nil {
+        return nil, ErrInvalidUserInfo
+    }
+
+    s := &Server{
+        Server:  u.Hostname(),
+        Remarks: u.Fragment,
+    }
+    if u.Port() == "" {
+        return nil, ErrMissingPort
+    }
+    s.ServerPort, err = strconv.Atoi(u.Port())
+    if err != nil || s.ServerPort <= 0 || s.ServerPort > 65535 {
+        return nil, fmt.Errorf("invalid server port %q", u.Port())
+    }
+
+    method, password, err := parseUserInfo(u.User)
+    if err != nil {
+        return nil, err
+    }
+    s.Method = LocalMethod(method)
+    s.Password = password
+
+    if plugin := u.Query().Get("plugin"); plugin != "" {
+        parts := strings.SplitN(plugin, ";", 2)
+        s.Plugin = parts[0]
+        if len(parts) == 2 {
+            s.PluginOpts = parts[1]
+        }
+    }
+    return s, nil
+}
+
+// parseUserInfo accepts both the base64 user info used by SIP002 and the
+// percent-encoded method:password used by AEAD-2022 style URIs.
+func parseUserInfo(info *url.Userinfo) (string, string, error) {
+    if password, ok := info.Password(); ok {
+        return info.Username(), password, nil
+    }
+    decoded, err := decodeBase64(info.Username())
+    if err != nil {
+        return "", "", ErrInvalidUserInfo
+    }
+    parts := strings.SplitN(decoded, ":", 2)
+    if len(parts) != 2 || parts[0] == "" {
+        return "", "", ErrInvalidUserInfo
+    }
+    return parts[0], parts[1], nil
+}
+
+// decodeLegacy rewrites ss://BASE64(method:password@host:port)#tag into
+// the SIP002 form.
+func decodeLegacy(uri string) (string, error) {
+    body := strings.TrimPrefix(uri, "ss://")
+    fragment := ""
+    if i := strings.IndexByte(body, '#'); i >= 0 {
+        body, fragment = body[:i], body[i:]
+    }
+    decoded, err := decodeBase64(body)
+    if err != nil {
+        return "", ErrInvalidUserInfo
+    }
+    at := strings.LastIndexByte(decoded, '@')
+    if at < 0 {
+        return "", ErrInvalidUserInfo
+    }
+    userInfo := base64.RawURLEncoding.EncodeToString([]byte(decoded[:at]))
+    return "ss://" + userInfo + "@" + decoded[at+1:] + fragment, nil
+}
+
+func decodeBase64(s string) (string, error) {
+    s = strings.TrimRight(s, "=")
+    for _, enc := range []*base64.Encoding{base64.RawURLEncoding, base64.RawStdEncoding} {
+        if b, err := enc.DecodeString(s); err == nil {
+            return string(b), nil
+        }
+    }
+    return "", errors.New("invalid base64")
+}
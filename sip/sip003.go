@@ -0,0 +1,214 @@
+package sip
+
+import (
+    "fmt"
+    "log"
+    "net"
+    "os"
+    "os/exec"
+    "strings"
+    "sync"
+    "time"
+)
+
+const maxPluginRestartDelay = 30 * time.Second
+
+// PluginConfig describes a SIP003 plugin: a binary that forwards TCP
+// between a local and a remote address, transforming the traffic on the
+// way. On the server the plugin listens on Remote and forwards to Local;
+// on the client it listens on Local and forwards to Remote.
+type PluginConfig struct {
+    Plugin  string
+    Options string
+    Remote  string
+    Local   string
+}
+
+// Plugin is a running, supervised SIP003 plugin process.
+type Plugin struct {
+    cfg PluginConfig
+    env []string
+
+    mu      sync.Mutex
+    cmd     *exec.Cmd
+    stopped bool
+    exited  chan struct{}
+}
+
+// StartPlugin starts the plugin and restarts it with backoff whenever it
+// exits, until Stop is called. Only failing to execute the binary is an
+// error; a plugin that exits right away, e.g. because its port is still
+// taken, is simply retried.
+func StartPlugin(cfg PluginConfig) (*Plugin, error) {
+    env, err := pluginEnv(cfg)
+    if err != nil {
+        return nil, err
+    }
+    p := &Plugin{cfg: cfg, env: env}
+    if err := p.start(); err != nil {
+        return nil, err
+    }
+    go p.supervise()
+    return p, nil
+}
+
+func pluginEnv(cfg PluginConfig) ([]string, error) {
+    remoteHost, remotePort, err := net.SplitHostPort(cfg.Remote)
+    if err != nil {
+        return nil, fmt.Errorf("plugin remote address: %w", err)
+    }
+    localHost, localPort, err := net.SplitHostPort(cfg.Local)
+    if err != nil {
+        return nil, fmt.Errorf("plugin local address: %w", err)
+    }
+    if remoteHost == "" {
+        remoteHost = "0.0.0.0"
+    }
+    return append(os.Environ(),
+        "SS_REMOTE_HOST="+remoteHost,
+        "SS_REMOTE_PORT="+remotePort,
+        "SS_LOCAL_HOST="+localHost,
+        "SS_LOCAL_PORT="+localPort,
+        "SS_PLUGIN_OPTIONS="+cfg.Options,
+    ), nil
+}
+
+func (p *Plugin) start() error {
+    cmd := exec.Command(p.cfg.Plugin)
+    cmd.Env = p.env
+    cmd.Stdout = os.Stderr
+    cmd.Stderr = os.Stderr
+    if err := cmd.Start(); err != nil {
+        return fmt.Errorf("start plugin %s: %w", p.cfg.Plugin, err)
+    }
+
+    exited := make(chan struct{})
+    go func() {
+        cmd.Wait()
+        close(exited)
+    }()
+
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if p.stopped {
+        // Stop ran while we were starting; don't leave this one behind.
+        cmd.Process.Kill()
+        return nil
+    }
+    p.cmd, p.exited = cmd, exited
+    return nil
+}
+
+func (p *Plugin) supervise() {
+    delay := time.Second
+    started := time.Now()
+    for {
+        p.mu.Lock()
+        exited := p.exited
+        p.mu.Unlock()
+        <-exited
+
+        if time.Since(started) > maxPluginRestartDelay {
+            delay = time.Second
+        }
+        for {
+            if p.isStopped() {
+                return
+            }
+            log.Printf("SIP003 plugin %s exited; restarting in %s", p.cfg.Plugin, delay)
+            time.Sleep(delay)
+            if delay < maxPluginRestartDelay {
+                delay *= 2
+            }
+            if p.isStopped() {
+                return
+            }
+            if err := p.start(); err != nil {
+                log.Printf("SIP003 plugin %s: %v", p.cfg.Plugin, err)
+                continue
+            }
+            started = time.Now()
+            break
+        }
+    }
+}
+
+func (p *Plugin) isStopped() bool {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    return p.stopped
+}
+
+// Stop kills the plugin process and waits for it to exit.
+func (p *Plugin) Stop() {
+    p.mu.Lock()
+    p.stopped = true
+    cmd, exited := p.cmd, p.exited
+    p.mu.Unlock()
+
+    cmd.Process.Kill()
+    <-exited
+}
+
+// Config returns the configuration the plugin was started with.
+func (p *Plugin) Config() PluginConfig {
+    return p.cfg
+}
+
+// WaitListening waits until the plugin accepts connections on its remote
+// address, or timeout passes. A plugin restarted while another process
+// still holds the port only gets there after its backoff.
+func (p *Plugin) WaitListening(timeout time.Duration) error {
+    host, port, err := net.SplitHostPort(p.cfg.Remote)
+    if err != nil {
+        return err
+    }
+    if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+        host = "127.0.0.1"
+    }
+    addr := net.JoinHostPort(host, port)
+    deadline := time.Now().Add(timeout)
+    for {
+        conn, err := net.DialTimeout("tcp", addr, time.Second)
+        if err == nil {
+            conn.Close()
+            return nil
+        }
+        if p.isStopped() || time.Now().After(deadline) {
+            return err
+        }
+        time.Sleep(50 * time.Millisecond)
+    }
+}
+
+// FreeLocalAddr returns a loopback address with a port that is free right
+// now, for the side of a plugin that only Lunasocks connects to.
+func FreeLocalAddr() (string, error) {
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        return "", err
+    }
+    defer ln.Close()
+    return ln.Addr().String(), nil
+}
+
+// ClientPlugin returns the name clients use for a server-side plugin.
+func ClientPlugin(plugin string) string {
+    if plugin == "obfs-server" {
+        return "obfs-local"
+    }
+    return plugin
+}
+
+// ClientPluginOpts drops the bare "server" option that server-side
+// plugins such as v2ray-plugin take, so the options can be handed to
+// clients.
+func ClientPluginOpts(opts string) string {
+    var kept []string
+    for _, opt := range strings.Split(opts, ";") {
+        if opt != "" && opt != "server" {
+            kept = append(kept, opt)
+        }
+    }
+    return strings.Join(kept, ";")
+}
@@ -0,0 +1,120 @@
+package sip
+
+import (
+    "crypto/sha1"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net"
+    "strconv"
+
+    "your_project/config"
+)
+
+// DocumentVersion is the SIP008 online configuration version.
+const DocumentVersion = 1
+
+// Document is a SIP008 online configuration document.
+type Document struct {
+    Version        int      `json:"version"`
+    Servers        []Server `json:"servers"`
+    BytesUsed      *uint64  `json:"bytes_used,omitempty"`
+    BytesRemaining *uint64  `json:"bytes_remaining,omitempty"`
+}
+
+func NewDocument(servers ...Server) *Document {
+    if servers == nil {
+        servers = []Server{}
+    }
+    return &Document{Version: DocumentVersion, Servers: servers}
+}
+
+// ParseDocument parses and checks a SIP008 document.
+func ParseDocument(data []byte) (*Document, error) {
+    var doc Document
+    if err := json.Unmarshal(data, &doc); err != nil {
+        return nil, err
+    }
+    if doc.Version != DocumentVersion {
+        return nil, fmt.Errorf("unsupported SIP008 version %d", doc.Version)
+    }
+    for i := range doc.Servers {
+        s := &doc.Servers[i]
+        if s.Server == "" || s.ServerPort == 0 || s.Method == "" {
+            return nil, fmt.Errorf("server %d: server, server_port and method are required", i)
+        }
+        s.Method = LocalMethod(s.Method)
+    }
+    return &doc, nil
+}
+
+// MarshalJSON writes servers with the cipher names clients expect.
+func (d *Document) MarshalJSON() ([]byte, error) {
+    type document Document
+    out := *d
+    out.Servers = make([]Server, len(d.Servers))
+    for i, s := range d.Servers {
+        s.Method = StandardMethod(s.Method)
+        out.Servers[i] = s
+    }
+    return json.Marshal((*document)(&out))
+}
+
+// StableID derives a UUID-formatted identifier from name, so a listener
+// keeps the same SIP008 id across restarts.
+func StableID(name string) string {
+    h := sha1.Sum([]byte("lunasocks:" + name))
+    h[6] = (h[6] & 0x0f) | 0x50 // version 5
+    h[8] = (h[8] & 0x3f) | 0x80 // RFC 4122 variant
+    return fmt.Sprintf("%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
+
+// FromListener describes a shadowsocks listener as clients reach it at
+// host. If host is empty the listen address is used, provided it is not a
+// wildcard address.
+func FromListener(lc config.ListenerConfig, host string) (Server, error) {
+    if lc.Protocol != config.ProtocolShadowsocks {
+        return Server{}, fmt.Errorf("listener %s is not a shadowsocks listener", lc.Name)
+    }
+    listenHost, portStr, err := net.SplitHostPort(lc.Address)
+    if err != nil {
+        return Server{}, err
+    }
+    port, err := strconv.Atoi(portStr)
+    if err != nil {
+        return Server{}, err
+    }
+    if host == "" {
+        if ip := net.ParseIP(listenHost); listenHost == "" || (ip != nil && ip.IsUnspecified()) {
+            return Server{}, errors.New("listener binds a wildcard address; a public host is required")
+        }
+        host = listenHost
+    }
+
+    return Server{
+        ID:         StableID(lc.Name),
+        Remarks:    lc.Name,
+        Server:     host,
+        ServerPort: port,
+        Password:   lc.Password.Value(),
+        Method:     lc.Method,
+        Plugin:     ClientPlugin(lc.Plugin),
+        PluginOpts: ClientPluginOpts(lc.PluginOpts),
+    }, nil
+}
+
+// FromConfig returns a document with every shadowsocks listener in cfg.
+func FromConfig(cfg *config.Config, host string) (*Document, error) {
+    var servers []Server
+    for _, lc := range cfg.Inbounds() {
+        if lc.Protocol != config.ProtocolShadowsocks {
+            continue
+        }
+        s, err := FromListener(lc, host)
+        if err != nil {
+            return nil, err
+        }
+        servers = append(servers, s)
+    }
+    return NewDocument(servers...), nil
+}
@@ -0,0 +1,103 @@
+package sip
+
+import (
+    "encoding/json"
+    "net"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestURIRoundTrip(t *testing.T) {
+    s := &Server{
+        Server:     "2001:db8::1",
+        ServerPort: 8388,
+        Password:   "p@ss:word",
+        Method:     "chacha20-poly1305",
+        Plugin:     "obfs-local",
+        PluginOpts: "obfs=http;obfs-host=example.com",
+        Remarks:    "my server",
+    }
+
+    uri := s.URI()
+    want := "ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpwQHNzOndvcmQ@[2001:db8::1]:8388/?plugin=obfs-local%3Bobfs%3Dhttp%3Bobfs-host%3Dexample.com#my%20server"
+    if uri != want {
+        t.Fatalf("Unexpected URI %s", uri)
+    }
+
+    parsed, err := ParseURI(uri)
+    if err != nil {
+        t.Fatalf("ParseURI failed: %v", err)
+    }
+    if *parsed != *s {
+        t.Errorf("Round trip mismatch:\n got %+v\nwant %+v", parsed, s)
+    }
+}
+
+func TestParseURIForms(t *testing.T) {
+    tests := []struct {
+        uri    string
+        method string
+        host   string
+        err    bool
+    }{
+        {"ss://YWVzLTI1Ni1nY206dGVzdA@192.168.100.1:8888#Example1", "aes-256-gcm", "192.168.100.1", false},
+        {"ss://2022-blake3-aes-256-gcm:YctPZ6U7xPPcU%2Bgp3u%2B0tx%2FtRizJN9K8y%2BuKlW2qjlI%3D@192.168.100.1:8888", "2022-blake3-aes-256-gcm", "192.168.100.1", false},
+        {"ss://YWVzLTI1Ni1nY206dGVzdEAxOTIuMTY4LjEwMC4xOjg4ODg#legacy", "aes-256-gcm", "192.168.100.1", false},
+        {"ss://YWVzLTI1Ni1nY206dGVzdA@192.168.100.1", "", "", true},
+        {"http://example.com", "", "", true},
+    }
+
+    for _, test := range tests {
+        s, err := ParseURI(test.uri)
+        if test.err {
+            if err == nil {
+                t.Errorf("Expected error for %s, got nil", test.uri)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("Unexpected error for %s: %v", test.uri, err)
+            continue
+        }
+        if s.Method != test.method || s.Server != test.host || s.ServerPort != 8888 {
+            t.Errorf("For %s got %+v", test.uri, s)
+        }
+    }
+}
+
+func TestDocumentUsesStandardMethodNames(t *testing.T) {
+    doc := NewDocument(Server{ID: StableID("a"), Server: "example.com", ServerPort: 8388, Password: "x", Method: "chacha20-poly1305"})
+    out, err := json.Marshal(doc)
+    if err != nil {
+        t.Fatalf("Marshal failed: %v", err)
+    }
+    if !strings.Contains(string(out), `"method":"chacha20-ietf-poly1305"`) {
+        t.Errorf("Expected standard method name in %s", out)
+    }
+
+    parsed, err := ParseDocument(out)
+    if err != nil {
+        t.Fatalf("ParseDocument failed: %v", err)
+    }
+    if parsed.Servers[0].Method != "chacha20-poly1305" {
+        t.Errorf("Expected local method name, got %s", parsed.Servers[0].Method)
+    }
+}
+
+func TestPluginWaitListening(t *testing.T) {
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, port, _ := net.SplitHostPort(ln.Addr().String())
+    p := &Plugin{cfg: PluginConfig{Remote: "0.0.0.0:" + port}}
+    if err := p.WaitListening(time.Second); err != nil {
+        t.Errorf("WaitListening with a bound address: %v", err)
+    }
+
+    ln.Close()
+    if err := p.WaitListening(200 * time.Millisecond); err == nil {
+        t.Error("WaitListening succeeded with nothing listening")
+    }
+}
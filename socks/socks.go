@@ -77,10 +77,24 @@ func ParseUDPAddress(b []byte) (string, []byte, error) {
         return "", nil, ErrAddressTooShort
     }
 
-    addr, err := ParseAddress(b[3:])
+    addr, err := ParseAddress(b[3 : 4+addrLen+2])
     if err != nil {
         return "", nil, err
     }
 
-    return addr, b[4+addrLen:], nil
+    return addr, b[4+addrLen+2:], nil
+}
+
+// UDPHeader returns the SOCKS5 UDP request header (RSV, FRAG, ATYP,
+// DST.ADDR, DST.PORT) for addr.
+func UDPHeader(addr *net.UDPAddr) []byte {
+    b := []byte{0, 0, 0}
+    if ip4 := addr.IP.To4(); ip4 != nil {
+        b = append(b, 1)
+        b = append(b, ip4...)
+    } else {
+        b = append(b, 4)
+        b = append(b, addr.IP.To16()...)
+    }
+    return append(b, byte(addr.Port>>8), byte(addr.Port))
 }
@@ -33,3 +33,17 @@ func TestParseAddress(t *testing.T) {
         }
     }
 }
+
+func TestParseUDPAddress(t *testing.T) {
+    packet := []byte{0, 0, 0, 1, 10, 0, 0, 1, 0x00, 0x35, 'd', 'n', 's'}
+    addr, payload, err := ParseUDPAddress(packet)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if addr != "10.0.0.1:53" {
+        t.Errorf("Expected 10.0.0.1:53, got %s", addr)
+    }
+    if !bytes.Equal(payload, []byte("dns")) {
+        t.Errorf("Expected payload %q, got %q", "dns", payload)
+    }
+}
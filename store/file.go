@@ -0,0 +1,282 @@
+package store
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sync"
+)
+
+// compactMinGarbage is how many superseded records the log may hold
+// before it is compacted, as long as they are also outnumbering the live
+// ones.
+const compactMinGarbage = 1024
+
+var ErrClosed = errors.New("store is closed")
+
+// record is one line of the log: a put, or a delete when Delete is set.
+type record struct {
+    Bucket string `json:"b"`
+    Key    string `json:"k"`
+    Value  []byte `json:"v,omitempty"`
+    Delete bool   `json:"d,omitempty"`
+}
+
+// File is a Store kept in a single file as a log of JSON lines, one per
+// change, replayed into memory on open. Once superseded records make up
+// most of the log it is rewritten with only the live values.
+type File struct {
+    mu      sync.Mutex
+    path    string
+    f       *os.File
+    data    *Memory
+    live    int
+    records int
+    // valid is the length of the log up to the end of its last complete
+    // record, and torn is set if a record cut short follows it.
+    valid int64
+    torn  bool
+}
+
+// OpenFile opens or creates the store at path.
+func OpenFile(path string) (*File, error) {
+    fs := &File{path: path, data: NewMemory()}
+    if err := fs.replay(); err != nil {
+        return nil, err
+    }
+    // Drop a record cut short by a crash, so that it does not end up in
+    // the middle of the log once more records are appended.
+    if fs.torn {
+        if err := os.Truncate(path, fs.valid); err != nil {
+            return nil, err
+        }
+    }
+    f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+    if err != nil {
+        return nil, err
+    }
+    fs.f = f
+    if err := fs.terminate(); err != nil {
+        f.Close()
+        return nil, err
+    }
+    return fs, nil
+}
+
+// LoadFile reads the store at path into memory without opening it for
+// writing, for tools that look at it while the server runs. A missing
+// file gives an empty store.
+func LoadFile(path string) (*Memory, error) {
+    fs := &File{path: path, data: NewMemory()}
+    if err := fs.replay(); err != nil {
+        return nil, err
+    }
+    return fs.data, nil
+}
+
+// replay loads the log. A last line cut short by a crash is ignored; it
+// was never acknowledged to the writer. A malformed line anywhere else
+// means the log is damaged and is an error.
+func (fs *File) replay() error {
+    f, err := os.Open(fs.path)
+    if os.IsNotExist(err) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    sc := bufio.NewScanner(f)
+    sc.Buffer(make([]byte, 64*1024), 64<<20)
+    line := 0
+    for sc.Scan() {
+        line++
+        if fs.torn {
+            return fmt.Errorf("%s: malformed record on line %d", fs.path, line-1)
+        }
+        var r record
+        if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+            fs.torn = true
+            continue
+        }
+        fs.apply(r)
+        fs.records++
+        fs.valid += int64(len(sc.Bytes())) + 1
+    }
+    return sc.Err()
+}
+
+func (fs *File) apply(r record) {
+    _, err := fs.data.Get(r.Bucket, r.Key)
+    exists := err == nil
+    if r.Delete {
+        fs.data.Delete(r.Bucket, r.Key)
+        if exists {
+            fs.live--
+        }
+        return
+    }
+    fs.data.Put(r.Bucket, r.Key, r.Value)
+    if !exists {
+        fs.live++
+    }
+}
+
+// terminate ends a line cut short by a crash, so that the next record
+// starts on a line of its own.
+func (fs *File) terminate() error {
+    fi, err := fs.f.Stat()
+    if err != nil || fi.Size() == 0 {
+        return err
+    }
+    r, err := os.Open(fs.path)
+    if err != nil {
+        return err
+    }
+    defer r.Close()
+    last := make([]byte, 1)
+    if _, err := r.ReadAt(last, fi.Size()-1); err != nil {
+        return err
+    }
+    if last[0] != '\n' {
+        _, err = fs.f.Write([]byte{'\n'})
+    }
+    return err
+}
+
+func (fs *File) Get(bucket, key string) ([]byte, error) {
+    return fs.data.Get(bucket, key)
+}
+
+func (fs *File) List(bucket string) (map[string][]byte, error) {
+    return fs.data.List(bucket)
+}
+
+func (fs *File) Put(bucket, key string, value []byte) error {
+    fs.mu.Lock()
+    defer fs.mu.Unlock()
+    return fs.write(record{Bucket: bucket, Key: key, Value: value})
+}
+
+func (fs *File) Delete(bucket, key string) error {
+    // Check under the lock, or a concurrent Put could land between the
+    // check and the write and outlive the Delete.
+    fs.mu.Lock()
+    defer fs.mu.Unlock()
+    if _, err := fs.data.Get(bucket, key); err == ErrNotFound {
+        return nil
+    }
+    return fs.write(record{Bucket: bucket, Key: key, Delete: true})
+}
+
+// write appends r to the log, syncs it and only then applies it. It must
+// be called with fs.mu held.
+func (fs *File) write(r record) error {
+    line, err := json.Marshal(r)
+    if err != nil {
+        return err
+    }
+
+    if fs.f == nil {
+        return ErrClosed
+    }
+    if _, err := fs.f.Write(append(line, '\n')); err != nil {
+        return err
+    }
+    if err := fs.f.Sync(); err != nil {
+        return err
+    }
+    fs.apply(r)
+    fs.records++
+
+    // The record is saved either way; a failed compaction is tried again
+    // on the next write.
+    if garbage := fs.records - fs.live; garbage >= compactMinGarbage && garbage > fs.live {
+        fs.compact()
+    }
+    return nil
+}
+
+// Compact rewrites the log with only the live values.
+func (fs *File) Compact() error {
+    fs.mu.Lock()
+    defer fs.mu.Unlock()
+    if fs.f == nil {
+        return ErrClosed
+    }
+    return fs.compact()
+}
+
+// compact writes the live values to a temporary file and renames it over
+// the log, so a crash leaves either the old log or the new one. It must
+// be called with fs.mu held.
+func (fs *File) compact() error {
+    tmp, err := ioutil.TempFile(filepath.Dir(fs.path), ".store-*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+
+    w := bufio.NewWriter(tmp)
+    fs.data.mu.RLock()
+    for bucket, b := range fs.data.buckets {
+        for key, value := range b {
+            line, _ := json.Marshal(record{Bucket: bucket, Key: key, Value: value})
+            w.Write(append(line, '\n'))
+        }
+    }
+    fs.data.mu.RUnlock()
+    if err := w.Flush(); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Sync(); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+
+    // Open the new log before it replaces the old one, so that a failure
+    // at any point leaves fs appending to the file at fs.path.
+    f, err := os.OpenFile(tmp.Name(), os.O_WRONLY|os.O_APPEND, 0600)
+    if err != nil {
+        return err
+    }
+    if err := os.Rename(tmp.Name(), fs.path); err != nil {
+        f.Close()
+        return err
+    }
+    fs.f.Close()
+    fs.f = f
+    fs.records = fs.live
+    // Make the rename itself durable; until then a crash may bring back
+    // the old log, which still holds everything.
+    return syncDir(filepath.Dir(fs.path))
+}
+
+func syncDir(dir string) error {
+    d, err := os.Open(dir)
+    if err != nil {
+        return err
+    }
+    defer d.Close()
+    return d.Sync()
+}
+
+func (fs *File) Close() error {
+    fs.mu.Lock()
+    defer fs.mu.Unlock()
+    if fs.f == nil {
+        return nil
+    }
+    err := fs.f.Close()
+    fs.f = nil
+    return err
+}
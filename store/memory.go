@@ -0,0 +1,56 @@
+package store
+
+import "sync"
+
+// Memory is a Store that keeps everything in memory, for tests.
+type Memory struct {
+    mu      sync.RWMutex
+    buckets map[string]map[string][]byte
+}
+
+func NewMemory() *Memory {
+    return &Memory{buckets: make(map[string]map[string][]byte)}
+}
+
+func (m *Memory) Get(bucket, key string) ([]byte, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    v, ok := m.buckets[bucket][key]
+    if !ok {
+        return nil, ErrNotFound
+    }
+    return append([]byte(nil), v...), nil
+}
+
+func (m *Memory) Put(bucket, key string, value []byte) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    b, ok := m.buckets[bucket]
+    if !ok {
+        b = make(map[string][]byte)
+        m.buckets[bucket] = b
+    }
+    b[key] = append([]byte(nil), value...)
+    return nil
+}
+
+func (m *Memory) Delete(bucket, key string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    delete(m.buckets[bucket], key)
+    return nil
+}
+
+func (m *Memory) List(bucket string) (map[string][]byte, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    out := make(map[string][]byte, len(m.buckets[bucket]))
+    for k, v := range m.buckets[bucket] {
+        out[k] = append([]byte(nil), v...)
+    }
+    return out, nil
+}
+
+func (m *Memory) Close() error {
+    return nil
+}
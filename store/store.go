@@ -0,0 +1,48 @@
+// Package store keeps runtime state such as users, traffic counters and
+// bans. Values are opaque byte strings filed under a bucket and a key.
+package store
+
+import (
+    "encoding/json"
+    "errors"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// ErrDetached is returned by owners of a store that stopped writing to it
+// because another process, such as the one started by an upgrade, took
+// it over.
+var ErrDetached = errors.New("store handed to another process")
+
+// Store is a persistent map of buckets of key/value pairs. Every method
+// is safe for concurrent use, and a Put or Delete that returned is
+// durable.
+type Store interface {
+    // Get returns the value of key in bucket, or ErrNotFound.
+    Get(bucket, key string) ([]byte, error)
+    Put(bucket, key string, value []byte) error
+    // Delete removes key from bucket. Deleting a missing key is not an
+    // error.
+    Delete(bucket, key string) error
+    // List returns every key and value in bucket.
+    List(bucket string) (map[string][]byte, error)
+    Close() error
+}
+
+// GetJSON decodes the value of key in bucket into v.
+func GetJSON(s Store, bucket, key string, v interface{}) error {
+    data, err := s.Get(bucket, key)
+    if err != nil {
+        return err
+    }
+    return json.Unmarshal(data, v)
+}
+
+// PutJSON stores v encoded as JSON.
+func PutJSON(s Store, bucket, key string, v interface{}) error {
+    data, err := json.Marshal(v)
+    if err != nil {
+        return err
+    }
+    return s.Put(bucket, key, data)
+}
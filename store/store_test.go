@@ -0,0 +1,130 @@
+package store
+
+import (
+    "os"
+    "path/filepath"
+    "strconv"
+    "testing"
+)
+
+func testStore(t *testing.T, s Store) {
+    if _, err := s.Get("users", "alice"); err != ErrNotFound {
+        t.Errorf("Get of a missing key = %v, want ErrNotFound", err)
+    }
+    if err := PutJSON(s, "users", "alice", map[string]int{"quota": 1}); err != nil {
+        t.Fatalf("Put failed: %v", err)
+    }
+    s.Put("users", "bob", []byte("2"))
+    s.Put("bans", "alice", []byte("3"))
+
+    var v map[string]int
+    if err := GetJSON(s, "users", "alice", &v); err != nil || v["quota"] != 1 {
+        t.Errorf("GetJSON = %v, %v", v, err)
+    }
+    if err := s.Delete("users", "bob"); err != nil {
+        t.Fatalf("Delete failed: %v", err)
+    }
+    if err := s.Delete("users", "nobody"); err != nil {
+        t.Errorf("Delete of a missing key failed: %v", err)
+    }
+    all, err := s.List("users")
+    if err != nil || len(all) != 1 || all["alice"] == nil {
+        t.Errorf("List = %v, %v", all, err)
+    }
+}
+
+func TestMemory(t *testing.T) {
+    testStore(t, NewMemory())
+}
+
+func TestFile(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "state.db")
+    s, err := OpenFile(path)
+    if err != nil {
+        t.Fatalf("OpenFile failed: %v", err)
+    }
+    testStore(t, s)
+    s.Close()
+
+    // A record cut short by a crash is dropped and later writes still land.
+    f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+    f.WriteString(`{"b":"users","k":"carol","v":"`)
+    f.Close()
+
+    s, err = OpenFile(path)
+    if err != nil {
+        t.Fatalf("Reopen failed: %v", err)
+    }
+    s.Put("users", "dave", []byte("4"))
+    s.Close()
+
+    s, err = OpenFile(path)
+    if err != nil {
+        t.Fatalf("Reopen failed: %v", err)
+    }
+    defer s.Close()
+    all, _ := s.List("users")
+    if len(all) != 2 || all["alice"] == nil || string(all["dave"]) != "4" {
+        t.Errorf("Unexpected users after reopen: %v", all)
+    }
+}
+
+func TestFileRejectsDamagedLog(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "state.db")
+    os.WriteFile(path, []byte(`{"b":"users","k":"alice","v":"MQ=="}
+{"b":"users","k":"bob",
+{"b":"users","k":"carol","v":"Mw=="}
+`), 0600)
+    if s, err := OpenFile(path); err == nil {
+        s.Close()
+        t.Fatal("OpenFile accepted a malformed record before the last line")
+    }
+}
+
+func TestLoadFile(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "state.db")
+    if m, err := LoadFile(path); err != nil || m == nil {
+        t.Fatalf("LoadFile of a missing file: %v", err)
+    }
+
+    s, err := OpenFile(path)
+    if err != nil {
+        t.Fatalf("OpenFile failed: %v", err)
+    }
+    defer s.Close()
+    s.Put("users", "alice", []byte("1"))
+
+    m, err := LoadFile(path)
+    if err != nil {
+        t.Fatalf("LoadFile failed: %v", err)
+    }
+    if v, _ := m.Get("users", "alice"); string(v) != "1" {
+        t.Errorf("Got %q, want the value written by the open store", v)
+    }
+}
+
+func TestFileCompaction(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "state.db")
+    s, err := OpenFile(path)
+    if err != nil {
+        t.Fatalf("OpenFile failed: %v", err)
+    }
+    for i := 0; i < 3*compactMinGarbage; i++ {
+        if err := s.Put("traffic", "alice", []byte(strconv.Itoa(i))); err != nil {
+            t.Fatalf("Put failed: %v", err)
+        }
+    }
+    if s.records > compactMinGarbage+1 {
+        t.Errorf("Log holds %d records for 1 live value", s.records)
+    }
+    s.Close()
+
+    s, err = OpenFile(path)
+    if err != nil {
+        t.Fatalf("Reopen failed: %v", err)
+    }
+    defer s.Close()
+    if v, _ := s.Get("traffic", "alice"); string(v) != strconv.Itoa(3*compactMinGarbage-1) {
+        t.Errorf("Got %q after compaction", v)
+    }
+}
@@ -0,0 +1,96 @@
+// Package transport provides net.Conn wrappers that disguise proxy
+// traffic on the wire.
+package transport
+
+import (
+    "fmt"
+    "net"
+    "strings"
+)
+
+// Obfuscation modes, as in simple-obfs.
+const (
+    ObfsHTTP = "http"
+    ObfsTLS  = "tls"
+)
+
+// ObfsConfig configures a simple-obfs compatible transport. Host is the
+// HTTP Host or TLS server name the client presents; Path is the request
+// path in HTTP mode.
+type ObfsConfig struct {
+    Mode string
+    Host string
+    Path string
+}
+
+// obfsPlugins are the SIP003 plugin names implemented natively.
+var obfsPlugins = map[string]bool{
+    "obfs-local":  true,
+    "obfs-server": true,
+    "simple-obfs": true,
+}
+
+// ParseObfsOpts recognizes a simple-obfs SIP003 plugin and its options,
+// e.g. "obfs=http;obfs-host=example.com", so it can be served natively.
+func ParseObfsOpts(plugin, opts string) (ObfsConfig, bool, error) {
+    if !obfsPlugins[plugin] {
+        return ObfsConfig{}, false, nil
+    }
+    cfg := ObfsConfig{Mode: ObfsHTTP, Host: "cloudfront.net", Path: "/"}
+    for _, opt := range strings.Split(opts, ";") {
+        key, value := opt, ""
+        if i := strings.IndexByte(opt, '='); i >= 0 {
+            key, value = opt[:i], opt[i+1:]
+        }
+        switch key {
+        case "obfs":
+            cfg.Mode = value
+        case "obfs-host":
+            cfg.Host = value
+        case "obfs-uri":
+            cfg.Path = value
+        case "", "server", "fast-open", "failover":
+        default:
+            return ObfsConfig{}, true, fmt.Errorf("obfs: unsupported option %q", key)
+        }
+    }
+    if cfg.Mode != ObfsHTTP && cfg.Mode != ObfsTLS {
+        return ObfsConfig{}, true, fmt.Errorf("obfs: unknown mode %q", cfg.Mode)
+    }
+    return cfg, true, nil
+}
+
+// ObfsClient wraps a connection to an obfs server. The first write is sent
+// along with the handshake.
+func ObfsClient(conn net.Conn, cfg ObfsConfig) net.Conn {
+    if cfg.Mode == ObfsTLS {
+        return newTLSObfsConn(conn, cfg, false)
+    }
+    return newHTTPObfsConn(conn, cfg, false)
+}
+
+// ObfsServer wraps a connection accepted from an obfs client.
+func ObfsServer(conn net.Conn, cfg ObfsConfig) net.Conn {
+    if cfg.Mode == ObfsTLS {
+        return newTLSObfsConn(conn, cfg, true)
+    }
+    return newHTTPObfsConn(conn, cfg, true)
+}
+
+// ObfsListener wraps every connection accepted from ln with ObfsServer.
+func ObfsListener(ln net.Listener, cfg ObfsConfig) net.Listener {
+    return &obfsListener{Listener: ln, cfg: cfg}
+}
+
+type obfsListener struct {
+    net.Listener
+    cfg ObfsConfig
+}
+
+func (l *obfsListener) Accept() (net.Conn, error) {
+    conn, err := l.Listener.Accept()
+    if err != nil {
+        return nil, err
+    }
+    return ObfsServer(conn, l.cfg), nil
+}
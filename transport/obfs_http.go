@@ -0,0 +1,129 @@
+package transport
+
+import (
+    "bufio"
+    "crypto/rand"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "io"
+    mrand "math/rand"
+    "net"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+var errBadObfsHeader = errors.New("obfs: malformed HTTP header")
+
+// httpObfsConn looks like a WebSocket upgrade: the client's first write
+// goes out as the body of a GET with Upgrade: websocket, the server's as
+// the body of a 101 response, and everything after is sent as is.
+type httpObfsConn struct {
+    net.Conn
+    cfg    ObfsConfig
+    server bool
+    br     *bufio.Reader
+
+    rmu      sync.Mutex
+    readHead bool
+
+    wmu       sync.Mutex
+    wroteHead bool
+}
+
+func newHTTPObfsConn(conn net.Conn, cfg ObfsConfig, server bool) *httpObfsConn {
+    return &httpObfsConn{Conn: conn, cfg: cfg, server: server, br: bufio.NewReader(conn)}
+}
+
+func (c *httpObfsConn) Read(b []byte) (int, error) {
+    c.rmu.Lock()
+    defer c.rmu.Unlock()
+    if !c.readHead {
+        if err := c.readHeader(); err != nil {
+            return 0, err
+        }
+        c.readHead = true
+    }
+    return c.br.Read(b)
+}
+
+// readHeader consumes the request or response header. The body that
+// follows is the start of the stream.
+func (c *httpObfsConn) readHeader() error {
+    line, err := c.br.ReadString('\n')
+    if err != nil {
+        return err
+    }
+    if c.server {
+        if !strings.HasPrefix(line, "GET ") && !strings.HasPrefix(line, "POST ") {
+            return errBadObfsHeader
+        }
+    } else if !strings.HasPrefix(line, "HTTP/1.1 101") {
+        return errBadObfsHeader
+    }
+    for {
+        line, err := c.br.ReadString('\n')
+        if err != nil {
+            return err
+        }
+        if line == "\r\n" || line == "\n" {
+            return nil
+        }
+    }
+}
+
+func (c *httpObfsConn) Write(b []byte) (int, error) {
+    c.wmu.Lock()
+    defer c.wmu.Unlock()
+    if c.wroteHead {
+        return c.Conn.Write(b)
+    }
+
+    var head string
+    if c.server {
+        head = c.responseHeader()
+    } else {
+        head = c.requestHeader(len(b))
+    }
+    buf := make([]byte, 0, len(head)+len(b))
+    buf = append(buf, head...)
+    buf = append(buf, b...)
+    if _, err := c.Conn.Write(buf); err != nil {
+        return 0, err
+    }
+    c.wroteHead = true
+    return len(b), nil
+}
+
+func (c *httpObfsConn) requestHeader(bodyLen int) string {
+    path := c.cfg.Path
+    if path == "" {
+        path = "/"
+    }
+    return fmt.Sprintf("GET %s HTTP/1.1\r\n"+
+        "Host: %s\r\n"+
+        "User-Agent: curl/7.%d.%d\r\n"+
+        "Upgrade: websocket\r\n"+
+        "Connection: Upgrade\r\n"+
+        "Sec-WebSocket-Key: %s\r\n"+
+        "Content-Length: %d\r\n"+
+        "\r\n", path, c.cfg.Host, mrand.Intn(51), mrand.Intn(2), randomKey(), bodyLen)
+}
+
+func (c *httpObfsConn) responseHeader() string {
+    return fmt.Sprintf("HTTP/1.1 101 Switching Protocols\r\n"+
+        "Server: nginx/1.%d.%d\r\n"+
+        "Date: %s\r\n"+
+        "Upgrade: websocket\r\n"+
+        "Connection: Upgrade\r\n"+
+        "Sec-WebSocket-Accept: %s\r\n"+
+        "\r\n", mrand.Intn(11), mrand.Intn(12), time.Now().UTC().Format(http.TimeFormat), randomKey())
+}
+
+func randomKey() string {
+    key := make([]byte, 16)
+    io.ReadFull(rand.Reader, key)
+    return base64.StdEncoding.EncodeToString(key)
+}
@@ -0,0 +1,87 @@
+package transport
+
+import (
+    "bytes"
+    "io"
+    "net"
+    "testing"
+)
+
+func TestObfsRoundTrip(t *testing.T) {
+    for _, mode := range []string{ObfsHTTP, ObfsTLS} {
+        t.Run(mode, func(t *testing.T) {
+            cfg := ObfsConfig{Mode: mode, Host: "example.com", Path: "/"}
+            a, b := net.Pipe()
+            client := ObfsClient(a, cfg)
+            server := ObfsServer(b, cfg)
+            defer client.Close()
+            defer server.Close()
+
+            big := bytes.Repeat([]byte("x"), 40000)
+            go func() {
+                client.Write([]byte("hello"))
+                client.Write(big)
+            }()
+
+            buf := make([]byte, 5)
+            if _, err := io.ReadFull(server, buf); err != nil || string(buf) != "hello" {
+                t.Fatalf("Server read %q, %v", buf, err)
+            }
+            got := make([]byte, len(big))
+            if _, err := io.ReadFull(server, got); err != nil || !bytes.Equal(got, big) {
+                t.Fatalf("Server read %d bytes, %v", len(got), err)
+            }
+
+            go server.Write([]byte("world"))
+            if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "world" {
+                t.Fatalf("Client read %q, %v", buf, err)
+            }
+        })
+    }
+}
+
+func TestParseObfsOpts(t *testing.T) {
+    cfg, ok, err := ParseObfsOpts("obfs-local", "obfs=tls;obfs-host=example.com")
+    if !ok || err != nil || cfg.Mode != ObfsTLS || cfg.Host != "example.com" {
+        t.Errorf("Unexpected result %+v, %v, %v", cfg, ok, err)
+    }
+    if _, ok, _ := ParseObfsOpts("v2ray-plugin", "server"); ok {
+        t.Error("v2ray-plugin must not be handled natively")
+    }
+    if _, _, err := ParseObfsOpts("obfs-server", "obfs=quic"); err == nil {
+        t.Error("Expected an error for an unknown mode")
+    }
+}
+
+func TestWebSocketEarlyData(t *testing.T) {
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    wl := WebSocketListener(ln, "/ws")
+    defer wl.Close()
+
+    go func() {
+        conn, err := wl.Accept()
+        if err != nil {
+            return
+        }
+        defer conn.Close()
+        io.Copy(conn, conn)
+    }()
+
+    raw, err := net.Dial("tcp", ln.Addr().String())
+    if err != nil {
+        t.Fatal(err)
+    }
+    conn := WebSocketClient(raw, ln.Addr().String(), WebSocketConfig{Path: "/ws", Host: "cdn.example.com", EarlyData: 4})
+    defer conn.Close()
+
+    if _, err := conn.Write([]byte("hello websocket")); err != nil {
+        t.Fatalf("Write failed: %v", err)
+    }
+    buf := make([]byte, len("hello websocket"))
+    if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello websocket" {
+        t.Fatalf("Read %q, %v", buf, err)
+    }
+}
@@ -0,0 +1,313 @@
+package transport
+
+import (
+    "crypto/rand"
+    "encoding/binary"
+    "errors"
+    "io"
+    "net"
+    "sync"
+    "time"
+)
+
+// TLS record types.
+const (
+    recordChangeCipherSpec = 0x14
+    recordHandshake        = 0x16
+    recordApplicationData  = 0x17
+)
+
+const (
+    maxRecordPayload     = 16384
+    extSessionTicket     = 0x0023
+    extServerName        = 0x0000
+    handshakeClientHello = 0x01
+)
+
+var errBadClientHello = errors.New("obfs: malformed TLS client hello")
+
+// The fixed parts of the client hello sent by simple-obfs.
+var (
+    obfsCipherSuites = []byte{
+        0xc0, 0x2c, 0xc0, 0x30, 0x00, 0x9f, 0xcc, 0xa9, 0xcc, 0xa8, 0xcc, 0xaa, 0xc0, 0x2b, 0xc0, 0x2f,
+        0x00, 0x9e, 0xc0, 0x24, 0xc0, 0x28, 0x00, 0x6b, 0xc0, 0x23, 0xc0, 0x27, 0x00, 0x67, 0xc0, 0x0a,
+        0xc0, 0x14, 0x00, 0x39, 0xc0, 0x09, 0xc0, 0x13, 0x00, 0x33, 0x00, 0x9d, 0x00, 0x9c, 0x00, 0x3d,
+        0x00, 0x3c, 0x00, 0x35, 0x00, 0x2f, 0x00, 0xff,
+    }
+    obfsClientExtensions = []byte{
+        // ec_point_formats
+        0x00, 0x0b, 0x00, 0x04, 0x03, 0x01, 0x00, 0x02,
+        // supported_groups
+        0x00, 0x0a, 0x00, 0x0a, 0x00, 0x08, 0x00, 0x1d, 0x00, 0x17, 0x00, 0x19, 0x00, 0x18,
+        // signature_algorithms
+        0x00, 0x0d, 0x00, 0x20, 0x00, 0x1e,
+        0x06, 0x01, 0x06, 0x02, 0x06, 0x03, 0x05, 0x01, 0x05, 0x02, 0x05, 0x03, 0x04, 0x01, 0x04, 0x02,
+        0x04, 0x03, 0x03, 0x01, 0x03, 0x02, 0x03, 0x03, 0x02, 0x01, 0x02, 0x02, 0x02, 0x03,
+        // encrypt_then_mac, extended_master_secret
+        0x00, 0x16, 0x00, 0x00, 0x00, 0x17, 0x00, 0x00,
+    }
+    obfsServerExtensions = []byte{
+        // renegotiation_info, extended_master_secret, ec_point_formats
+        0xff, 0x01, 0x00, 0x01, 0x00, 0x00, 0x17, 0x00, 0x00, 0x00, 0x0b, 0x00, 0x02, 0x01, 0x00,
+    }
+)
+
+// tlsObfsConn looks like a resumed TLS 1.2 session. The client's first
+// write travels in the session ticket extension of its ClientHello, the
+// server answers with ServerHello, ChangeCipherSpec and Finished, and all
+// further data is carried in application data records. Nothing is
+// actually encrypted by this layer.
+type tlsObfsConn struct {
+    net.Conn
+    cfg    ObfsConfig
+    server bool
+
+    rmu       sync.Mutex
+    readHello bool
+    pending   []byte
+
+    wmu        sync.Mutex
+    wroteHello bool
+    wroteCCS   bool
+
+    sidMu     sync.Mutex
+    sessionID []byte
+}
+
+func newTLSObfsConn(conn net.Conn, cfg ObfsConfig, server bool) *tlsObfsConn {
+    return &tlsObfsConn{Conn: conn, cfg: cfg, server: server}
+}
+
+func (c *tlsObfsConn) Read(b []byte) (int, error) {
+    c.rmu.Lock()
+    defer c.rmu.Unlock()
+
+    for len(c.pending) == 0 {
+        typ, payload, err := c.readRecord()
+        if err != nil {
+            return 0, err
+        }
+        switch {
+        case c.server && !c.readHello:
+            if typ != recordHandshake {
+                return 0, errBadClientHello
+            }
+            ticket, sid, err := parseClientHello(payload)
+            if err != nil {
+                return 0, err
+            }
+            c.sidMu.Lock()
+            c.sessionID = sid
+            c.sidMu.Unlock()
+            c.readHello = true
+            c.pending = ticket
+        case typ == recordApplicationData:
+            c.pending = payload
+        }
+        // ServerHello, ChangeCipherSpec and Finished carry nothing.
+    }
+
+    n := copy(b, c.pending)
+    c.pending = c.pending[n:]
+    return n, nil
+}
+
+func (c *tlsObfsConn) readRecord() (byte, []byte, error) {
+    var header [5]byte
+    if _, err := io.ReadFull(c.Conn, header[:]); err != nil {
+        return 0, nil, err
+    }
+    payload := make([]byte, binary.BigEndian.Uint16(header[3:]))
+    if _, err := io.ReadFull(c.Conn, payload); err != nil {
+        return 0, nil, err
+    }
+    return header[0], payload, nil
+}
+
+func (c *tlsObfsConn) Write(b []byte) (int, error) {
+    c.wmu.Lock()
+    defer c.wmu.Unlock()
+
+    var out []byte
+    data := b
+    if !c.wroteHello {
+        if c.server {
+            out = c.serverHello()
+        } else {
+            first := data
+            if len(first) > maxRecordPayload {
+                first = first[:maxRecordPayload]
+            }
+            out = c.clientHello(first)
+            data = data[len(first):]
+        }
+        c.wroteHello = true
+    }
+    if len(data) > 0 && !c.server && !c.wroteCCS {
+        out = append(out, changeCipherSpec(32)...)
+        c.wroteCCS = true
+    }
+    for len(data) > 0 {
+        n := len(data)
+        if n > maxRecordPayload {
+            n = maxRecordPayload
+        }
+        out = appendRecord(out, recordApplicationData, data[:n])
+        data = data[n:]
+    }
+
+    if _, err := c.Conn.Write(out); err != nil {
+        return 0, err
+    }
+    return len(b), nil
+}
+
+func (c *tlsObfsConn) clientHello(ticket []byte) []byte {
+    sid := randomBytes(32)
+
+    var ext []byte
+    ext = appendUint16(ext, extSessionTicket)
+    ext = appendUint16(ext, uint16(len(ticket)))
+    ext = append(ext, ticket...)
+    host := []byte(c.cfg.Host)
+    ext = appendUint16(ext, extServerName)
+    ext = appendUint16(ext, uint16(len(host)+5))
+    ext = appendUint16(ext, uint16(len(host)+3))
+    ext = append(ext, 0) // host_name
+    ext = appendUint16(ext, uint16(len(host)))
+    ext = append(ext, host...)
+    ext = append(ext, obfsClientExtensions...)
+
+    var body []byte
+    body = append(body, 0x03, 0x03)
+    body = append(body, helloRandom()...)
+    body = append(body, byte(len(sid)))
+    body = append(body, sid...)
+    body = appendUint16(body, uint16(len(obfsCipherSuites)))
+    body = append(body, obfsCipherSuites...)
+    body = append(body, 0x01, 0x00) // null compression
+    body = appendUint16(body, uint16(len(ext)))
+    body = append(body, ext...)
+
+    hs := []byte{handshakeClientHello, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}
+    hs = append(hs, body...)
+
+    // ClientHello records still say TLS 1.0 for compatibility.
+    out := []byte{recordHandshake, 0x03, 0x01}
+    out = appendUint16(out, uint16(len(hs)))
+    return append(out, hs...)
+}
+
+func (c *tlsObfsConn) serverHello() []byte {
+    c.sidMu.Lock()
+    sid := c.sessionID
+    c.sidMu.Unlock()
+    if len(sid) != 32 {
+        sid = randomBytes(32)
+    }
+
+    var body []byte
+    body = append(body, 0x03, 0x03)
+    body = append(body, helloRandom()...)
+    body = append(body, byte(len(sid)))
+    body = append(body, sid...)
+    body = append(body, 0xcc, 0xa8) // TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305
+    body = append(body, 0x00)
+    body = appendUint16(body, uint16(len(obfsServerExtensions)))
+    body = append(body, obfsServerExtensions...)
+
+    hs := []byte{0x02, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}
+    hs = append(hs, body...)
+
+    out := []byte{recordHandshake, 0x03, 0x01}
+    out = appendUint16(out, uint16(len(hs)))
+    out = append(out, hs...)
+    return append(out, changeCipherSpec(40)...)
+}
+
+// changeCipherSpec returns a ChangeCipherSpec record followed by a
+// fake encrypted Finished of finishedLen random bytes.
+func changeCipherSpec(finishedLen int) []byte {
+    out := []byte{recordChangeCipherSpec, 0x03, 0x03, 0x00, 0x01, 0x01}
+    return appendRecord(out, recordHandshake, randomBytes(finishedLen))
+}
+
+// parseClientHello returns the session ticket and session id of a
+// ClientHello handshake message.
+func parseClientHello(b []byte) (ticket, sid []byte, err error) {
+    if len(b) < 4 || b[0] != handshakeClientHello {
+        return nil, nil, errBadClientHello
+    }
+    b = b[4:]
+    // version(2) + random(32)
+    if len(b) < 35 {
+        return nil, nil, errBadClientHello
+    }
+    b = b[34:]
+    sid, b, ok := readVector8(b)
+    if !ok {
+        return nil, nil, errBadClientHello
+    }
+    if _, b, ok = readVector16(b); !ok { // cipher suites
+        return nil, nil, errBadClientHello
+    }
+    if _, b, ok = readVector8(b); !ok { // compression methods
+        return nil, nil, errBadClientHello
+    }
+    ext, _, ok := readVector16(b)
+    if !ok {
+        return nil, nil, errBadClientHello
+    }
+    for len(ext) >= 4 {
+        typ := binary.BigEndian.Uint16(ext)
+        var data []byte
+        if data, ext, ok = readVector16(ext[2:]); !ok {
+            return nil, nil, errBadClientHello
+        }
+        if typ == extSessionTicket {
+            return data, sid, nil
+        }
+    }
+    return nil, nil, errBadClientHello
+}
+
+func readVector8(b []byte) ([]byte, []byte, bool) {
+    if len(b) < 1 || len(b) < 1+int(b[0]) {
+        return nil, nil, false
+    }
+    n := int(b[0])
+    return b[1 : 1+n], b[1+n:], true
+}
+
+func readVector16(b []byte) ([]byte, []byte, bool) {
+    if len(b) < 2 {
+        return nil, nil, false
+    }
+    n := int(binary.BigEndian.Uint16(b))
+    if len(b) < 2+n {
+        return nil, nil, false
+    }
+    return b[2 : 2+n], b[2+n:], true
+}
+
+func appendRecord(out []byte, typ byte, payload []byte) []byte {
+    out = append(out, typ, 0x03, 0x03)
+    out = appendUint16(out, uint16(len(payload)))
+    return append(out, payload...)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+    return append(b, byte(v>>8), byte(v))
+}
+
+func helloRandom() []byte {
+    r := randomBytes(32)
+    binary.BigEndian.PutUint32(r, uint32(time.Now().Unix()))
+    return r
+}
+
+func randomBytes(n int) []byte {
+    b := make([]byte, n)
+    io.ReadFull(rand.Reader, b)
+    return b
+}
@@ -0,0 +1,81 @@
+package transport
+
+import (
+    "encoding/binary"
+    "io"
+    "net"
+    "sync"
+)
+
+// maxSealedChunk bounds the plaintext of one chunk so the sealed chunk
+// still fits its 2 byte length prefix.
+const maxSealedChunk = 16 << 10
+
+// Sealer encrypts and authenticates whole messages; crypto.Cipher and
+// crypto.AEADCipher both qualify.
+type Sealer interface {
+    Encrypt(plaintext []byte) ([]byte, error)
+    Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// Seal turns conn into an encrypted stream: every write is sent as
+// length-prefixed sealed chunks and reads open them again.
+func Seal(conn net.Conn, s Sealer) net.Conn {
+    return &sealedConn{Conn: conn, s: s}
+}
+
+type sealedConn struct {
+    net.Conn
+    s Sealer
+
+    rmu     sync.Mutex
+    pending []byte
+
+    wmu sync.Mutex
+}
+
+func (c *sealedConn) Read(b []byte) (int, error) {
+    c.rmu.Lock()
+    defer c.rmu.Unlock()
+    for len(c.pending) == 0 {
+        var size [2]byte
+        if _, err := io.ReadFull(c.Conn, size[:]); err != nil {
+            return 0, err
+        }
+        sealed := make([]byte, binary.BigEndian.Uint16(size[:]))
+        if _, err := io.ReadFull(c.Conn, sealed); err != nil {
+            return 0, err
+        }
+        plain, err := c.s.Decrypt(sealed)
+        if err != nil {
+            return 0, err
+        }
+        c.pending = plain
+    }
+    n := copy(b, c.pending)
+    c.pending = c.pending[n:]
+    return n, nil
+}
+
+func (c *sealedConn) Write(b []byte) (int, error) {
+    c.wmu.Lock()
+    defer c.wmu.Unlock()
+    written := 0
+    for written < len(b) {
+        n := len(b) - written
+        if n > maxSealedChunk {
+            n = maxSealedChunk
+        }
+        sealed, err := c.s.Encrypt(b[written : written+n])
+        if err != nil {
+            return written, err
+        }
+        out := make([]byte, 2, 2+len(sealed))
+        binary.BigEndian.PutUint16(out, uint16(len(sealed)))
+        if _, err := c.Conn.Write(append(out, sealed...)); err != nil {
+            return written, err
+        }
+        written += n
+    }
+    return written, nil
+}
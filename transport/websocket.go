@@ -0,0 +1,451 @@
+package transport
+
+import (
+    "bufio"
+    "crypto/rand"
+    "crypto/sha1"
+    "crypto/tls"
+    "encoding/base64"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes (RFC 6455, section 5.2).
+const (
+    opContinuation = 0x0
+    opText         = 0x1
+    opBinary       = 0x2
+    opClose        = 0x8
+    opPing         = 0x9
+    opPong         = 0xa
+)
+
+// maxEarlyData bounds the early data a client may put in its handshake,
+// which travels base64 encoded in a header.
+const maxEarlyData = 4096
+
+var errNotWebSocket = errors.New("websocket: not a websocket upgrade")
+
+// WebSocketConfig configures the client side of the WebSocket transport.
+// Host is sent as the Host header and used as the TLS server name; it
+// defaults to the server address. With EarlyData set, up to that many
+// bytes of the first write travel in the upgrade request, saving a round
+// trip.
+type WebSocketConfig struct {
+    Path      string
+    Host      string
+    TLS       bool
+    EarlyData int
+}
+
+// WebSocketClient runs the WebSocket transport over conn, a connection to
+// addr. The upgrade is sent with the first write, so the caller must write
+// before it reads, as shadowsocks clients do.
+func WebSocketClient(conn net.Conn, addr string, cfg WebSocketConfig) net.Conn {
+    host := cfg.Host
+    if host == "" {
+        host = addr
+    }
+    if cfg.TLS {
+        serverName := host
+        if h, _, err := net.SplitHostPort(host); err == nil {
+            serverName = h
+        }
+        conn = tls.Client(conn, &tls.Config{ServerName: serverName})
+    }
+    if cfg.EarlyData > maxEarlyData {
+        cfg.EarlyData = maxEarlyData
+    }
+    cfg.Host = host
+    return &wsClientConn{
+        wsConn: newWSConn(conn, bufio.NewReader(conn), true),
+        cfg:    cfg,
+        ready:  make(chan struct{}),
+    }
+}
+
+type wsClientConn struct {
+    *wsConn
+    cfg WebSocketConfig
+
+    hmu     sync.Mutex
+    started bool
+    ready   chan struct{}
+    err     error
+}
+
+func (c *wsClientConn) Write(b []byte) (int, error) {
+    c.hmu.Lock()
+    if !c.started {
+        c.started = true
+        early := b
+        if len(early) > c.cfg.EarlyData {
+            early = early[:c.cfg.EarlyData]
+        }
+        c.err = c.handshake(early)
+        close(c.ready)
+        c.hmu.Unlock()
+        if c.err != nil {
+            return 0, c.err
+        }
+        if rest := b[len(early):]; len(rest) > 0 {
+            if _, err := c.wsConn.Write(rest); err != nil {
+                return len(early), err
+            }
+        }
+        return len(b), nil
+    }
+    c.hmu.Unlock()
+
+    <-c.ready
+    if c.err != nil {
+        return 0, c.err
+    }
+    return c.wsConn.Write(b)
+}
+
+func (c *wsClientConn) Read(b []byte) (int, error) {
+    <-c.ready
+    if c.err != nil {
+        return 0, c.err
+    }
+    return c.wsConn.Read(b)
+}
+
+func (c *wsClientConn) handshake(early []byte) error {
+    key := randomKey()
+    path := c.cfg.Path
+    if path == "" {
+        path = "/"
+    }
+
+    var req strings.Builder
+    fmt.Fprintf(&req, "GET %s HTTP/1.1\r\n", path)
+    fmt.Fprintf(&req, "Host: %s\r\n", c.cfg.Host)
+    req.WriteString("Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\n")
+    fmt.Fprintf(&req, "Sec-WebSocket-Key: %s\r\n", key)
+    if len(early) > 0 {
+        fmt.Fprintf(&req, "Sec-WebSocket-Protocol: %s\r\n", base64.RawURLEncoding.EncodeToString(early))
+    }
+    req.WriteString("\r\n")
+    if _, err := io.WriteString(c.conn, req.String()); err != nil {
+        return err
+    }
+
+    resp, err := http.ReadResponse(c.br, nil)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusSwitchingProtocols {
+        return fmt.Errorf("websocket: server answered %s", resp.Status)
+    }
+    if resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+        return errors.New("websocket: bad Sec-WebSocket-Accept")
+    }
+    return nil
+}
+
+// UpgradeWebSocket answers a WebSocket upgrade request and returns the
+// connection. Early data sent by the client in Sec-WebSocket-Protocol is
+// returned by the first reads.
+func UpgradeWebSocket(w http.ResponseWriter, r *http.Request) (net.Conn, error) {
+    if !IsWebSocketUpgrade(r) || r.Header.Get("Sec-WebSocket-Version") != "13" {
+        http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+        return nil, errNotWebSocket
+    }
+    key := r.Header.Get("Sec-WebSocket-Key")
+    if key == "" {
+        http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+        return nil, errNotWebSocket
+    }
+
+    var early []byte
+    protocol := r.Header.Get("Sec-WebSocket-Protocol")
+    if protocol != "" {
+        var err error
+        if early, err = base64.RawURLEncoding.DecodeString(protocol); err != nil {
+            http.Error(w, "invalid early data", http.StatusBadRequest)
+            return nil, err
+        }
+    }
+
+    hj, ok := w.(http.Hijacker)
+    if !ok {
+        return nil, errors.New("websocket: connection cannot be hijacked")
+    }
+    conn, rw, err := hj.Hijack()
+    if err != nil {
+        return nil, err
+    }
+    // The http.Server may have set deadlines for reading the request.
+    conn.SetDeadline(time.Time{})
+
+    resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+        "Upgrade: websocket\r\n" +
+        "Connection: Upgrade\r\n" +
+        "Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n"
+    if protocol != "" {
+        // Browsers insist on the protocol being echoed.
+        resp += "Sec-WebSocket-Protocol: " + protocol + "\r\n"
+    }
+    resp += "\r\n"
+    if _, err := io.WriteString(conn, resp); err != nil {
+        conn.Close()
+        return nil, err
+    }
+
+    ws := newWSConn(conn, rw.Reader, false)
+    ws.early = early
+    return ws, nil
+}
+
+// IsWebSocketUpgrade reports whether r asks for a WebSocket upgrade.
+func IsWebSocketUpgrade(r *http.Request) bool {
+    return r.Method == http.MethodGet &&
+        strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
+        headerContainsToken(r.Header, "Connection", "upgrade")
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+    for _, v := range h[name] {
+        for _, t := range strings.Split(v, ",") {
+            if strings.EqualFold(strings.TrimSpace(t), token) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+func acceptKey(key string) string {
+    h := sha1.Sum([]byte(key + websocketGUID))
+    return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// WebSocketListener serves WebSocket upgrades on path over the connections
+// accepted from ln and returns the upgraded connections from Accept.
+// Requests for other paths get a 404.
+func WebSocketListener(ln net.Listener, path string) net.Listener {
+    wl := &wsListener{
+        Listener: ln,
+        conns:    make(chan net.Conn),
+        done:     make(chan struct{}),
+    }
+    mux := http.NewServeMux()
+    mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+        conn, err := UpgradeWebSocket(w, r)
+        if err != nil {
+            return
+        }
+        select {
+        case wl.conns <- conn:
+        case <-wl.done:
+            conn.Close()
+        }
+    })
+    wl.srv = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+    go func() {
+        wl.srv.Serve(ln)
+        wl.closeOnce.Do(func() { close(wl.done) })
+    }()
+    return wl
+}
+
+type wsListener struct {
+    net.Listener
+    srv       *http.Server
+    conns     chan net.Conn
+    done      chan struct{}
+    closeOnce sync.Once
+}
+
+func (l *wsListener) Accept() (net.Conn, error) {
+    select {
+    case conn := <-l.conns:
+        return conn, nil
+    case <-l.done:
+        return nil, net.ErrClosed
+    }
+}
+
+func (l *wsListener) Close() error {
+    l.closeOnce.Do(func() { close(l.done) })
+    return l.srv.Close()
+}
+
+// wsConn carries a byte stream in binary WebSocket messages.
+type wsConn struct {
+    conn   net.Conn
+    br     *bufio.Reader
+    client bool
+
+    rmu       sync.Mutex
+    early     []byte
+    remaining uint64
+    mask      [4]byte
+    masked    bool
+    maskPos   int
+
+    wmu sync.Mutex
+}
+
+func newWSConn(conn net.Conn, br *bufio.Reader, client bool) *wsConn {
+    return &wsConn{conn: conn, br: br, client: client}
+}
+
+func (c *wsConn) Read(b []byte) (int, error) {
+    c.rmu.Lock()
+    defer c.rmu.Unlock()
+
+    if len(c.early) > 0 {
+        n := copy(b, c.early)
+        c.early = c.early[n:]
+        return n, nil
+    }
+
+    for c.remaining == 0 {
+        if err := c.nextFrame(); err != nil {
+            return 0, err
+        }
+    }
+
+    if uint64(len(b)) > c.remaining {
+        b = b[:c.remaining]
+    }
+    n, err := c.br.Read(b)
+    if c.masked {
+        for i := 0; i < n; i++ {
+            b[i] ^= c.mask[(c.maskPos+i)%4]
+        }
+        c.maskPos = (c.maskPos + n) % 4
+    }
+    c.remaining -= uint64(n)
+    return n, err
+}
+
+// nextFrame reads frame headers until a data frame starts, answering
+// control frames on the way.
+func (c *wsConn) nextFrame() error {
+    var head [2]byte
+    if _, err := io.ReadFull(c.br, head[:]); err != nil {
+        return err
+    }
+    opcode := head[0] & 0x0f
+    masked := head[1]&0x80 != 0
+    length := uint64(head[1] & 0x7f)
+    switch length {
+    case 126:
+        var ext [2]byte
+        if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+            return err
+        }
+        length = uint64(binary.BigEndian.Uint16(ext[:]))
+    case 127:
+        var ext [8]byte
+        if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+            return err
+        }
+        length = binary.BigEndian.Uint64(ext[:])
+    }
+    var mask [4]byte
+    if masked {
+        if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+            return err
+        }
+    }
+
+    switch opcode {
+    case opContinuation, opText, opBinary:
+        c.remaining, c.mask, c.masked, c.maskPos = length, mask, masked, 0
+        return nil
+    }
+
+    // Control frames are short and must not be fragmented.
+    if length > 125 {
+        return errors.New("websocket: oversized control frame")
+    }
+    payload := make([]byte, length)
+    if _, err := io.ReadFull(c.br, payload); err != nil {
+        return err
+    }
+    if masked {
+        for i := range payload {
+            payload[i] ^= mask[i%4]
+        }
+    }
+    switch opcode {
+    case opPing:
+        c.writeFrame(opPong, payload)
+    case opClose:
+        c.writeFrame(opClose, payload)
+        return io.EOF
+    }
+    return nil
+}
+
+func (c *wsConn) Write(b []byte) (int, error) {
+    if err := c.writeFrame(opBinary, b); err != nil {
+        return 0, err
+    }
+    return len(b), nil
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+    c.wmu.Lock()
+    defer c.wmu.Unlock()
+
+    frame := make([]byte, 0, 14+len(payload))
+    frame = append(frame, 0x80|opcode)
+    maskBit := byte(0)
+    if c.client {
+        maskBit = 0x80
+    }
+    switch n := len(payload); {
+    case n < 126:
+        frame = append(frame, maskBit|byte(n))
+    case n <= 0xffff:
+        frame = append(frame, maskBit|126, byte(n>>8), byte(n))
+    default:
+        var ext [8]byte
+        binary.BigEndian.PutUint64(ext[:], uint64(n))
+        frame = append(frame, maskBit|127)
+        frame = append(frame, ext[:]...)
+    }
+
+    if c.client {
+        var mask [4]byte
+        io.ReadFull(rand.Reader, mask[:])
+        frame = append(frame, mask[:]...)
+        start := len(frame)
+        frame = append(frame, payload...)
+        for i := range payload {
+            frame[start+i] ^= mask[i%4]
+        }
+    } else {
+        frame = append(frame, payload...)
+    }
+
+    _, err := c.conn.Write(frame)
+    return err
+}
+
+func (c *wsConn) Close() error {
+    c.writeFrame(opClose, []byte{0x03, 0xe8}) // 1000, normal closure
+    return c.conn.Close()
+}
+
+func (c *wsConn) LocalAddr() net.Addr                { return c.conn.LocalAddr() }
+func (c *wsConn) RemoteAddr() net.Addr               { return c.conn.RemoteAddr() }
+func (c *wsConn) SetDeadline(t time.Time) error      { return c.conn.SetDeadline(t) }
+func (c *wsConn) SetReadDeadline(t time.Time) error  { return c.conn.SetReadDeadline(t) }
+func (c *wsConn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
@@ -0,0 +1,118 @@
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "fmt"
+    "os"
+    "your_project/config"
+    "your_project/sip"
+    "your_project/store"
+    "your_project/users"
+
+    qrcode "github.com/skip2/go-qrcode"
+)
+
+// runURICommand implements `lunasocks uri`, which prints the ss:// URI of
+// a shadowsocks listener, optionally as a terminal QR code. With -user it
+// prints a managed user's URIs for the listeners that accept users.
+func runURICommand(args []string) int {
+    fs := flag.NewFlagSet("uri", flag.ExitOnError)
+    configFile := fs.String("config", "config.yaml", "Path to configuration file")
+    host := fs.String("host", "", "Public host name clients connect to (defaults to public_host)")
+    showQR := fs.Bool("qr", false, "Also print the URI as a QR code")
+    sip008 := fs.Bool("sip008", false, "Print a SIP008 document instead of a URI")
+    userName := fs.String("user", "", "Use the credentials of this managed user")
+    fs.Usage = func() {
+        fmt.Fprintln(os.Stderr, "usage: lunasocks uri [-config file] [-host name] [-qr] [-sip008] [-user name] [listener]")
+        fs.PrintDefaults()
+    }
+    fs.Parse(args)
+
+    cfg, err := config.LoadConfig(*configFile)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "%s: %v\n", *configFile, err)
+        return 1
+    }
+    if *host == "" {
+        *host = cfg.PublicHost
+    }
+
+    if fs.NArg() > 0 {
+        lc, ok := cfg.Inbound(fs.Arg(0))
+        if !ok {
+            fmt.Fprintf(os.Stderr, "no listener named %s\n", fs.Arg(0))
+            return 1
+        }
+        cfg.Listeners = []config.ListenerConfig{lc}
+    }
+
+    var servers []sip.Server
+    if *userName != "" {
+        servers, err = userServers(cfg, *userName, *host)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "%s: %v\n", *userName, err)
+            return 1
+        }
+    } else {
+        for _, lc := range cfg.Inbounds() {
+            if lc.Protocol != config.ProtocolShadowsocks {
+                continue
+            }
+            s, err := sip.FromListener(lc, *host)
+            if err != nil {
+                fmt.Fprintf(os.Stderr, "%s: %v\n", lc.Name, err)
+                return 1
+            }
+            servers = append(servers, s)
+        }
+    }
+
+    if len(servers) == 0 {
+        fmt.Fprintln(os.Stderr, "no matching shadowsocks listener")
+        return 1
+    }
+
+    if *sip008 {
+        out, err := json.MarshalIndent(sip.NewDocument(servers...), "", "  ")
+        if err != nil {
+            fmt.Fprintln(os.Stderr, err)
+            return 1
+        }
+        fmt.Println(string(out))
+        return 0
+    }
+
+    for _, s := range servers {
+        uri := s.URI()
+        fmt.Println(uri)
+        if *showQR {
+            qr, err := qrcode.New(uri, qrcode.Medium)
+            if err != nil {
+                fmt.Fprintln(os.Stderr, err)
+                return 1
+            }
+            fmt.Println(qr.ToSmallString(false))
+        }
+    }
+    return 0
+}
+
+// userServers reads the store without opening it for writing, which is
+// safe while the server runs, and describes the listeners as the managed
+// user name reaches them.
+func userServers(cfg *config.Config, name, host string) ([]sip.Server, error) {
+    st, err := store.LoadFile(cfg.StoreFile)
+    if err != nil {
+        return nil, err
+    }
+    m, err := users.New(st)
+    if err != nil {
+        return nil, err
+    }
+    u, err := m.Get(name)
+    if err != nil {
+        return nil, err
+    }
+    return u.Servers(cfg, host)
+}
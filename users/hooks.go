@@ -0,0 +1,135 @@
+package users
+
+import (
+    "io"
+    "sync"
+    "time"
+
+    "your_project/plugin"
+)
+
+// Hooks returns the plugin that enforces the users' restrictions. It
+// acts on every connection authenticated under a managed user's name and
+// leaves all others alone. Install it with network.Server.SetUsers.
+func (m *Manager) Hooks() plugin.Hooks {
+    return &hooks{m: m}
+}
+
+type hooks struct {
+    plugin.BaseHooks
+    m *Manager
+}
+
+func (h *hooks) Name() string {
+    return "users"
+}
+
+// SkipsData tells relays that the hooks act on the stream only, through
+// WrapReader.
+func (h *hooks) SkipsData() bool {
+    return true
+}
+
+func (h *hooks) OnAuth(ctx *plugin.ConnContext, user string) error {
+    u, ok := h.m.lookup(user)
+    if !ok {
+        return nil
+    }
+    return u.usable(time.Now())
+}
+
+func (h *hooks) OnDial(ctx *plugin.ConnContext, dest string) (string, error) {
+    if u, ok := h.m.lookup(ctx.User); ok && !u.allows(dest) {
+        return "", ErrDestinationNotAllowed
+    }
+    return dest, nil
+}
+
+// OnClose charges the connection's traffic to the user. Connections that
+// never dialed a destination, such as mux sessions whose streams are
+// charged on their own, are not charged.
+func (h *hooks) OnClose(ctx *plugin.ConnContext, stats plugin.Stats) {
+    if ctx.Destination == "" {
+        return
+    }
+    h.m.addUsage(ctx.User, stats.BytesUp+stats.BytesDown)
+}
+
+// TransformsConn limits to wrapping the relays of users with a quota or
+// rate limit; everyone else's traffic is charged from the connection's
+// stats in OnClose.
+func (h *hooks) TransformsConn(ctx *plugin.ConnContext) bool {
+    u, ok := h.m.lookup(ctx.User)
+    return ok && (u.RateLimit > 0 || u.QuotaBytes > 0)
+}
+
+func (h *hooks) WrapReader(ctx *plugin.ConnContext, dir plugin.Direction, r io.Reader) io.Reader {
+    u, ok := h.m.lookup(ctx.User)
+    if !ok || (u.RateLimit == 0 && u.QuotaBytes == 0) {
+        return r
+    }
+    lr := &limitedReader{r: r, m: h.m, ctx: ctx, quota: u.QuotaBytes}
+    if u.RateLimit > 0 {
+        lr.limit = h.m.limiter(u.Name, int(dir), u.RateLimit)
+    }
+    return lr
+}
+
+func (h *hooks) WrapWriter(ctx *plugin.ConnContext, dir plugin.Direction, w io.Writer) io.Writer {
+    return w
+}
+
+// limitedReader ends the relay once the user's quota is used up, counting
+// what the connection moved so far, and paces reads to the rate limit.
+type limitedReader struct {
+    r     io.Reader
+    m     *Manager
+    ctx   *plugin.ConnContext
+    quota int64
+    limit *limiter
+}
+
+func (lr *limitedReader) Read(b []byte) (int, error) {
+    if lr.quota > 0 {
+        stats := lr.ctx.Stats()
+        if lr.m.used(lr.ctx.User)+stats.BytesUp+stats.BytesDown >= lr.quota {
+            return 0, ErrQuotaExceeded
+        }
+    }
+    n, err := lr.r.Read(b)
+    if lr.limit != nil && n > 0 {
+        lr.limit.wait(n)
+    }
+    return n, err
+}
+
+// limiter is a token bucket holding up to one second's worth of bytes.
+type limiter struct {
+    mu     sync.Mutex
+    rate   float64
+    tokens float64
+    last   time.Time
+}
+
+func newLimiter(rate int64) *limiter {
+    return &limiter{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// wait takes n bytes from the bucket, sleeping until they would have
+// been available.
+func (l *limiter) wait(n int) {
+    l.mu.Lock()
+    now := time.Now()
+    l.tokens += now.Sub(l.last).Seconds() * l.rate
+    if l.tokens > l.rate {
+        l.tokens = l.rate
+    }
+    l.last = now
+    l.tokens -= float64(n)
+    var d time.Duration
+    if l.tokens < 0 {
+        d = time.Duration(-l.tokens / l.rate * float64(time.Second))
+    }
+    l.mu.Unlock()
+    time.Sleep(d)
+}
@@ -0,0 +1,302 @@
+package users
+
+import (
+    "crypto/subtle"
+    "encoding/json"
+    "errors"
+    "io/ioutil"
+    "os"
+    "sort"
+    "sync"
+    "time"
+
+    "your_project/store"
+)
+
+var (
+    ErrNotFound = errors.New("user not found")
+    ErrExists   = errors.New("user already exists")
+)
+
+// Bucket is the store bucket users are kept in, keyed by name.
+const Bucket = "users"
+
+// usageSaveInterval limits how often traffic counters are written to the
+// store. Flush saves whatever is left on shutdown.
+const usageSaveInterval = time.Minute
+
+// Manager holds the users and writes every change through to a store.
+// All changes apply to connections authenticated after them.
+type Manager struct {
+    mu       sync.RWMutex
+    store    store.Store
+    users    map[string]*User
+    limiters map[string]*[2]*limiter
+    dirty    map[string]bool
+    saved    time.Time
+    // detached is set while another process owns the store.
+    detached bool
+}
+
+// New loads the users kept in s.
+func New(s store.Store) (*Manager, error) {
+    m := &Manager{
+        store:    s,
+        users:    make(map[string]*User),
+        limiters: make(map[string]*[2]*limiter),
+        dirty:    make(map[string]bool),
+    }
+    all, err := s.List(Bucket)
+    if err != nil {
+        return nil, err
+    }
+    for name, data := range all {
+        var u User
+        if err := json.Unmarshal(data, &u); err != nil {
+            return nil, err
+        }
+        m.users[name] = &u
+    }
+    return m, nil
+}
+
+// ImportFile adds the users saved in a JSON users file by earlier
+// releases, skipping names that already exist, and renames the file so
+// it is only imported once. A missing file imports nothing.
+func (m *Manager) ImportFile(path string) (int, error) {
+    data, err := ioutil.ReadFile(path)
+    if os.IsNotExist(err) {
+        return 0, nil
+    }
+    if err != nil {
+        return 0, err
+    }
+    var list []User
+    if err := json.Unmarshal(data, &list); err != nil {
+        return 0, err
+    }
+    n := 0
+    for _, u := range list {
+        used := u.UsedBytes
+        if err := m.Create(u); errors.Is(err, ErrExists) {
+            continue
+        } else if err != nil {
+            return n, err
+        }
+        m.addUsage(u.Name, used)
+        n++
+    }
+    if err := m.Flush(); err != nil {
+        return n, err
+    }
+    return n, os.Rename(path, path+".imported")
+}
+
+// List returns every user, sorted by name.
+func (m *Manager) List() []User {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    list := make([]User, 0, len(m.users))
+    for _, u := range m.users {
+        list = append(list, *u)
+    }
+    sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+    return list
+}
+
+func (m *Manager) Get(name string) (User, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    u, ok := m.users[name]
+    if !ok {
+        return User{}, ErrNotFound
+    }
+    return *u, nil
+}
+
+// Create adds a new user. Its traffic counter starts at zero.
+func (m *Manager) Create(u User) error {
+    if err := u.Validate(); err != nil {
+        return err
+    }
+    u.UsedBytes = 0
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if _, ok := m.users[u.Name]; ok {
+        return ErrExists
+    }
+    if err := m.save(&u); err != nil {
+        return err
+    }
+    m.users[u.Name] = &u
+    return nil
+}
+
+// Update replaces the user named u.Name. The traffic counter is kept as
+// it is, since connections may have added to it meanwhile; see
+// ResetUsage.
+func (m *Manager) Update(u User) error {
+    if err := u.Validate(); err != nil {
+        return err
+    }
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    old, ok := m.users[u.Name]
+    if !ok {
+        return ErrNotFound
+    }
+    u.UsedBytes = old.UsedBytes
+    if err := m.save(&u); err != nil {
+        return err
+    }
+    m.users[u.Name] = &u
+    // The next connection picks up the new rate.
+    delete(m.limiters, u.Name)
+    return nil
+}
+
+// ResetUsage sets the user's traffic counter back to zero.
+func (m *Manager) ResetUsage(name string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    u, ok := m.users[name]
+    if !ok {
+        return ErrNotFound
+    }
+    next := *u
+    next.UsedBytes = 0
+    if err := m.save(&next); err != nil {
+        return err
+    }
+    m.users[name] = &next
+    return nil
+}
+
+func (m *Manager) Delete(name string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if _, ok := m.users[name]; !ok {
+        return ErrNotFound
+    }
+    if m.detached {
+        return store.ErrDetached
+    }
+    if err := m.store.Delete(Bucket, name); err != nil {
+        return err
+    }
+    delete(m.users, name)
+    delete(m.limiters, name)
+    delete(m.dirty, name)
+    return nil
+}
+
+// Authenticate checks a user name and password.
+func (m *Manager) Authenticate(name, password string) bool {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    u, ok := m.users[name]
+    return ok && u.Password != "" && subtle.ConstantTimeCompare([]byte(u.Password), []byte(password)) == 1
+}
+
+func (m *Manager) lookup(name string) (User, bool) {
+    if name == "" {
+        return User{}, false
+    }
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    u, ok := m.users[name]
+    if !ok {
+        return User{}, false
+    }
+    return *u, true
+}
+
+func (m *Manager) used(name string) int64 {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    if u, ok := m.users[name]; ok {
+        return u.UsedBytes
+    }
+    return 0
+}
+
+// addUsage charges n bytes to the user.
+func (m *Manager) addUsage(name string, n int64) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    u, ok := m.users[name]
+    if !ok || n == 0 {
+        return
+    }
+    u.UsedBytes += n
+    m.dirty[name] = true
+    if time.Since(m.saved) >= usageSaveInterval {
+        m.flush()
+    }
+}
+
+// limiter returns the rate limiter shared by the user's connections in
+// one direction.
+func (m *Manager) limiter(name string, dir int, rate int64) *limiter {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    l, ok := m.limiters[name]
+    if !ok {
+        l = &[2]*limiter{newLimiter(rate), newLimiter(rate)}
+        m.limiters[name] = l
+    }
+    return l[dir]
+}
+
+// Flush saves traffic counters that have not been saved yet.
+func (m *Manager) Flush() error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return m.flush()
+}
+
+// DetachStore saves the traffic counters and stops writing to the store,
+// so that the process started by an upgrade can take it over. Until
+// AttachStore is called, changes fail with store.ErrDetached and usage is
+// only counted in memory.
+func (m *Manager) DetachStore() error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if err := m.flush(); err != nil {
+        return err
+    }
+    m.detached = true
+    return nil
+}
+
+// AttachStore resumes writing to the store, after an upgrade failed.
+func (m *Manager) AttachStore() {
+    m.mu.Lock()
+    m.detached = false
+    m.mu.Unlock()
+}
+
+func (m *Manager) flush() error {
+    if m.detached {
+        return nil
+    }
+    for name := range m.dirty {
+        if err := m.save(m.users[name]); err != nil {
+            return err
+        }
+    }
+    m.saved = time.Now()
+    return nil
+}
+
+// save writes u to the store. It must be called with m.mu held.
+func (m *Manager) save(u *User) error {
+    if m.detached {
+        return store.ErrDetached
+    }
+    if err := store.PutJSON(m.store, Bucket, u.Name, u); err != nil {
+        return err
+    }
+    delete(m.dirty, u.Name)
+    return nil
+}
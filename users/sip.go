@@ -0,0 +1,39 @@
+package users
+
+import (
+    "errors"
+
+    "your_project/config"
+    "your_project/sip"
+)
+
+// ErrRawKey is returned by Servers for users with a raw key. Clients
+// derive the key from the password of an ss:// URI or SIP008 document,
+// and no password derives to an arbitrary key.
+var ErrRawKey = errors.New("user has a raw key, which ss:// URIs and SIP008 documents cannot carry")
+
+// Servers describes every shadowsocks listener in cfg that accepts
+// managed users, set up with u's credentials, as clients reach it at host.
+func (u *User) Servers(cfg *config.Config, host string) ([]sip.Server, error) {
+    if u.Key != "" {
+        return nil, ErrRawKey
+    }
+    var servers []sip.Server
+    for _, lc := range cfg.Inbounds() {
+        if lc.Protocol != config.ProtocolShadowsocks || !lc.Users {
+            continue
+        }
+        s, err := sip.FromListener(lc, host)
+        if err != nil {
+            return nil, err
+        }
+        s.ID = sip.StableID(lc.Name + "/" + u.Name)
+        s.Remarks = lc.Name + " (" + u.Name + ")"
+        s.Password = u.Password
+        if u.Method != "" {
+            s.Method = u.Method
+        }
+        servers = append(servers, s)
+    }
+    return servers, nil
+}
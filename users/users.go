@@ -0,0 +1,214 @@
+// Package users manages the proxy users created through the admin API:
+// their credentials, the limits put on them and the traffic they used.
+package users
+
+import (
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "net"
+    "path"
+    "strings"
+    "time"
+
+    "your_project/config"
+)
+
+// KeySize is the length of a shadowsocks key given instead of a password.
+const KeySize = 32
+
+// User is a proxy user. Zero limits mean unlimited.
+type User struct {
+    Name string `json:"name"`
+    // Password authenticates the user on socks5 and http listeners and
+    // derives its shadowsocks key.
+    Password string `json:"password,omitempty"`
+    // Key is a base64 shadowsocks key used instead of one derived from
+    // Password.
+    Key string `json:"key,omitempty"`
+    // Cipher for shadowsocks listeners; the listener's method if empty.
+    Method   string `json:"method,omitempty"`
+    Disabled bool   `json:"disabled"`
+
+    // Traffic allowance in bytes, counting both directions.
+    QuotaBytes int64 `json:"quota_bytes"`
+    UsedBytes  int64 `json:"used_bytes"`
+    // Bytes per second in each direction, shared by all of the user's
+    // connections.
+    RateLimit int64 `json:"rate_limit"`
+    // Destinations the user may connect to: host names or globs such as
+    // "*.example.com", IP addresses or CIDR ranges, each optionally with
+    // a ":port". Everything is allowed if empty.
+    AllowedDestinations []string   `json:"allowed_destinations,omitempty"`
+    ExpiresAt           *time.Time `json:"expires_at,omitempty"`
+}
+
+// Redacted returns a copy of u with its password and key replaced by
+// config.Redacted, for showing through the API.
+func (u User) Redacted() User {
+    if u.Password != "" {
+        u.Password = config.Redacted
+    }
+    if u.Key != "" {
+        u.Key = config.Redacted
+    }
+    u.AllowedDestinations = append([]string(nil), u.AllowedDestinations...)
+    return u
+}
+
+// KeyBytes decodes Key.
+func (u *User) KeyBytes() ([]byte, error) {
+    return base64.StdEncoding.DecodeString(u.Key)
+}
+
+// Validate checks u before it is stored.
+func (u *User) Validate() error {
+    var problems []string
+    add := func(format string, args ...interface{}) {
+        problems = append(problems, fmt.Sprintf(format, args...))
+    }
+
+    switch {
+    case u.Name == "":
+        add("name: must not be empty")
+    case len(u.Name) > 64 || strings.ContainsAny(u.Name, ":/ \t\r\n"):
+        add("name: must be at most 64 characters without colons, slashes or spaces")
+    }
+    if u.Password == "" && u.Key == "" {
+        add("password: a password or key is required")
+    }
+    if u.Password == config.Redacted || u.Key == config.Redacted {
+        add("password: %q is not a usable password or key", config.Redacted)
+    }
+    if u.Key != "" {
+        if key, err := u.KeyBytes(); err != nil || len(key) != KeySize {
+            add("key: must be %d bytes in base64", KeySize)
+        }
+    }
+    if u.Method != "" && !config.SupportedMethod(u.Method) {
+        add("method: unsupported method %q", u.Method)
+    }
+    if u.QuotaBytes < 0 || u.UsedBytes < 0 {
+        add("quota_bytes: must not be negative")
+    }
+    if u.RateLimit < 0 {
+        add("rate_limit: must not be negative")
+    }
+    for i, d := range u.AllowedDestinations {
+        if _, err := parseRule(d); err != nil {
+            add("allowed_destinations[%d]: %v", i, err)
+        }
+    }
+
+    if len(problems) > 0 {
+        return &config.ValidationError{Problems: problems}
+    }
+    return nil
+}
+
+var (
+    ErrDisabled              = errors.New("user is disabled")
+    ErrExpired               = errors.New("user has expired")
+    ErrQuotaExceeded         = errors.New("traffic quota exceeded")
+    ErrDestinationNotAllowed = errors.New("destination not allowed")
+)
+
+// usable reports why u may not connect at now, if it may not.
+func (u *User) usable(now time.Time) error {
+    switch {
+    case u.Disabled:
+        return ErrDisabled
+    case u.ExpiresAt != nil && !now.Before(*u.ExpiresAt):
+        return ErrExpired
+    case u.QuotaBytes > 0 && u.UsedBytes >= u.QuotaBytes:
+        return ErrQuotaExceeded
+    }
+    return nil
+}
+
+// allows reports whether u may connect to dest, a host:port.
+func (u *User) allows(dest string) bool {
+    if len(u.AllowedDestinations) == 0 {
+        return true
+    }
+    host, port, err := net.SplitHostPort(dest)
+    if err != nil {
+        return false
+    }
+    for _, d := range u.AllowedDestinations {
+        if r, err := parseRule(d); err == nil && r.match(strings.ToLower(host), port) {
+            return true
+        }
+    }
+    return false
+}
+
+// rule is a parsed allowed destination.
+type rule struct {
+    host string
+    cidr *net.IPNet
+    port string
+}
+
+func parseRule(s string) (rule, error) {
+    var r rule
+    host := s
+    if h, p, err := net.SplitHostPort(s); err == nil {
+        host, r.port = h, p
+    }
+    if host == "" {
+        return r, errors.New("missing host")
+    }
+    if strings.Contains(host, "/") {
+        _, cidr, err := net.ParseCIDR(host)
+        if err != nil {
+            return r, err
+        }
+        r.cidr = cidr
+        return r, nil
+    }
+    if _, err := path.Match(host, ""); err != nil {
+        return r, fmt.Errorf("invalid pattern %q", host)
+    }
+    r.host = strings.ToLower(host)
+    return r, nil
+}
+
+func (r rule) match(host, port string) bool {
+    if r.port != "" && r.port != port {
+        return false
+    }
+    if r.cidr != nil {
+        ip := net.ParseIP(host)
+        return ip != nil && r.cidr.Contains(ip)
+    }
+    if ip := net.ParseIP(host); ip != nil {
+        if rip := net.ParseIP(r.host); rip != nil {
+            return ip.Equal(rip)
+        }
+    }
+    ok, _ := path.Match(r.host, host)
+    return ok
+}
+
+// Diff lists what changed between two versions of a user for the audit
+// log. Passwords and keys only show as changed, never their values.
+func Diff(old, new *User) []config.FieldChange {
+    var a, b interface{}
+    if old != nil {
+        a = old.Redacted()
+    }
+    if new != nil {
+        b = new.Redacted()
+    }
+    changes := config.RedactedDiff(a, b)
+    if old != nil && new != nil {
+        if old.Password != new.Password && old.Password != "" && new.Password != "" {
+            changes = append(changes, config.FieldChange{Path: "password", Before: config.Redacted, After: config.Redacted})
+        }
+        if old.Key != new.Key && old.Key != "" && new.Key != "" {
+            changes = append(changes, config.FieldChange{Path: "key", Before: config.Redacted, After: config.Redacted})
+        }
+    }
+    return changes
+}
@@ -0,0 +1,120 @@
+package users
+
+import (
+    "errors"
+    "net"
+    "testing"
+    "time"
+
+    "your_project/config"
+    "your_project/plugin"
+    "your_project/store"
+)
+
+func TestManagerPersistsUsers(t *testing.T) {
+    s := store.NewMemory()
+    m, err := New(s)
+    if err != nil {
+        t.Fatalf("New failed: %v", err)
+    }
+    if err := m.Create(User{Name: "alice", Password: "secret", QuotaBytes: 1000}); err != nil {
+        t.Fatalf("Create failed: %v", err)
+    }
+    if err := m.Create(User{Name: "alice", Password: "other"}); !errors.Is(err, ErrExists) {
+        t.Errorf("Expected ErrExists, got %v", err)
+    }
+    if err := m.Create(User{Name: "bob", Key: "dG9vIHNob3J0"}); err == nil {
+        t.Error("Expected a short key to be rejected")
+    }
+    m.addUsage("alice", 300)
+    if err := m.Update(User{Name: "alice", Password: "new", QuotaBytes: 2000}); err != nil {
+        t.Fatalf("Update failed: %v", err)
+    }
+
+    m.Flush()
+    m, err = New(s)
+    if err != nil {
+        t.Fatalf("Reload failed: %v", err)
+    }
+    u, err := m.Get("alice")
+    if err != nil || u.QuotaBytes != 2000 || u.UsedBytes != 300 {
+        t.Errorf("Unexpected user %+v, %v", u, err)
+    }
+    if !m.Authenticate("alice", "new") || m.Authenticate("alice", "secret") {
+        t.Error("Authenticate does not use the updated password")
+    }
+    if err := m.Delete("alice"); err != nil || len(m.List()) != 0 {
+        t.Errorf("Delete failed: %v", err)
+    }
+}
+
+func TestHooksEnforceRestrictions(t *testing.T) {
+    m, err := New(store.NewMemory())
+    if err != nil {
+        t.Fatalf("New failed: %v", err)
+    }
+    past := time.Now().Add(-time.Hour)
+    m.Create(User{Name: "limited", Password: "pw", AllowedDestinations: []string{"*.example.com:443", "10.0.0.0/8"}})
+    m.Create(User{Name: "expired", Password: "pw", ExpiresAt: &past})
+    m.Create(User{Name: "disabled", Password: "pw", Disabled: true})
+    m.Create(User{Name: "spent", Password: "pw", QuotaBytes: 10})
+    m.addUsage("spent", 10)
+
+    chain := plugin.Chain{m.Hooks()}
+    client, server := net.Pipe()
+    defer client.Close()
+    defer server.Close()
+
+    for user, want := range map[string]error{
+        "limited":  nil,
+        "expired":  ErrExpired,
+        "disabled": ErrDisabled,
+        "spent":    ErrQuotaExceeded,
+        "other":    nil,
+    } {
+        ctx := plugin.NewConnContext(server, "test", "socks5")
+        if err := chain.Auth(ctx, user); !errors.Is(err, want) {
+            t.Errorf("Auth(%s) = %v, want %v", user, err, want)
+        }
+    }
+
+    ctx := plugin.NewConnContext(server, "test", "socks5")
+    chain.Auth(ctx, "limited")
+    for dest, ok := range map[string]bool{
+        "www.example.com:443": true,
+        "www.example.com:80":  false,
+        "example.org:443":     false,
+        "10.1.2.3:22":         true,
+        "192.168.0.1:22":      false,
+    } {
+        if _, err := chain.Dial(ctx, dest); (err == nil) != ok {
+            t.Errorf("Dial(%s) = %v, want allowed %v", dest, err, ok)
+        }
+    }
+}
+
+func TestUserServers(t *testing.T) {
+    cfg := config.Default()
+    cfg.Listeners = []config.ListenerConfig{
+        {Name: "ss", Address: "0.0.0.0:8388", Protocol: config.ProtocolShadowsocks, Method: "aes-256-gcm", Users: true},
+        {Name: "shared", Address: "0.0.0.0:8389", Protocol: config.ProtocolShadowsocks, Method: "aes-256-gcm"},
+    }
+
+    alice := &User{Name: "alice", Password: "secret", Method: "chacha20-ietf-poly1305"}
+    servers, err := alice.Servers(cfg, "proxy.example.com")
+    if err != nil {
+        t.Fatalf("Servers failed: %v", err)
+    }
+    if len(servers) != 1 {
+        t.Fatalf("Got %d servers, want only the listener with users", len(servers))
+    }
+    s := servers[0]
+    if s.Server != "proxy.example.com" || s.Password != "secret" || s.Method != "chacha20-ietf-poly1305" {
+        t.Errorf("Unexpected server: %+v", s)
+    }
+
+    carol := &User{Name: "carol", Key: "AAAA"}
+    if _, err := carol.Servers(cfg, "proxy.example.com"); !errors.Is(err, ErrRawKey) {
+        t.Errorf("Servers for a key user: got %v, want ErrRawKey", err)
+    }
+}
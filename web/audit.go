@@ -0,0 +1,81 @@
+package web
+
+import (
+    "encoding/json"
+    "log"
+    "net/http"
+    "strconv"
+
+    "your_project/audit"
+    "your_project/config"
+)
+
+const (
+    defaultAuditPage = 50
+    maxAuditPage     = 500
+)
+
+// update applies next and records what changed in the audit log. Every
+// admin endpoint that changes the configuration goes through here.
+func (ws *WebServer) update(r *http.Request, action string, next *config.Config) (config.Diff, error) {
+    old := ws.config.Current()
+    diff, err := ws.config.Edit(next)
+    if err != nil || diff.Empty() {
+        return diff, err
+    }
+    ws.record(r, action, config.RedactedDiff(old, ws.config.Current()))
+    return diff, nil
+}
+
+// record appends an entry for a change that has already been made, so a
+// failure to write it is logged rather than returned.
+func (ws *WebServer) record(r *http.Request, action string, changes []config.FieldChange) {
+    e := audit.Entry{
+        Actor:   requestPrincipal(r).name,
+        Source:  hostOnly(r.RemoteAddr),
+        Action:  action,
+        Changes: changes,
+    }
+    if err := ws.audit.Append(e); err != nil {
+        log.Printf("Failed to write audit log entry for %s by %s: %v", action, e.Actor, err)
+    }
+}
+
+// handleAudit returns audit log entries, newest first, paged with the
+// offset and limit query parameters.
+func (ws *WebServer) handleAudit(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    offset, err := queryInt(r, "offset", 0)
+    if err != nil || offset < 0 {
+        http.Error(w, "offset must be a non-negative integer", http.StatusBadRequest)
+        return
+    }
+    limit, err := queryInt(r, "limit", defaultAuditPage)
+    if err != nil || limit <= 0 || limit > maxAuditPage {
+        http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxAuditPage), http.StatusBadRequest)
+        return
+    }
+
+    entries, total, err := ws.audit.Page(offset, limit)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(struct {
+        Total   int           `json:"total"`
+        Offset  int           `json:"offset"`
+        Entries []audit.Entry `json:"entries"`
+    }{total, offset, entries})
+}
+
+func queryInt(r *http.Request, name string, def int) (int, error) {
+    v := r.URL.Query().Get(name)
+    if v == "" {
+        return def, nil
+    }
+    return strconv.Atoi(v)
+}
@@ -0,0 +1,205 @@
+package web
+
+import (
+    "context"
+    "crypto/subtle"
+    "encoding/json"
+    "net"
+    "net/http"
+    "strings"
+    "time"
+
+    "golang.org/x/crypto/bcrypt"
+    "your_project/config"
+)
+
+const (
+    sessionCookie = "lunasocks_session"
+    csrfHeader    = "X-CSRF-Token"
+)
+
+// dummyHash is compared against when a login names an unknown user, so
+// that unknown users take as long to reject as wrong passwords.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("lunasocks"), bcrypt.DefaultCost)
+
+// principal is who a request was authenticated as.
+type principal struct {
+    name    string
+    role    string
+    session *session
+}
+
+type principalKey struct{}
+
+// requestPrincipal returns the principal of a request that went through
+// authorize.
+func requestPrincipal(r *http.Request) principal {
+    p, _ := r.Context().Value(principalKey{}).(principal)
+    return p
+}
+
+// authorize lets read-only users make safe requests and admins anything.
+// Browser sessions must also send their CSRF token with unsafe requests.
+func (ws *WebServer) authorize(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        p, ok := ws.authenticate(r)
+        if !ok {
+            w.Header().Set("WWW-Authenticate", `Bearer realm="lunasocks"`)
+            http.Error(w, "authentication required", http.StatusUnauthorized)
+            return
+        }
+        if !safeMethod(r.Method) {
+            if p.role != config.RoleAdmin {
+                http.Error(w, "admin role required", http.StatusForbidden)
+                return
+            }
+            if p.session != nil && subtle.ConstantTimeCompare([]byte(r.Header.Get(csrfHeader)), []byte(p.session.csrf)) != 1 {
+                http.Error(w, "missing or invalid CSRF token", http.StatusForbidden)
+                return
+            }
+        }
+        next(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+    }
+}
+
+// requireAdmin restricts a route to admins even for safe requests, for
+// reads that reveal secrets such as SIP008 documents.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if !checkAdmin(w, r) {
+            return
+        }
+        next(w, r)
+    }
+}
+
+// checkAdmin reports whether the request was made by an admin, and
+// answers it with 403 if not.
+func checkAdmin(w http.ResponseWriter, r *http.Request) bool {
+    if requestPrincipal(r).role != config.RoleAdmin {
+        http.Error(w, "admin role required", http.StatusForbidden)
+        return false
+    }
+    return true
+}
+
+// authenticate checks a bearer token or session cookie against the
+// current config, so removing an account or token takes effect at once.
+// Without any credentials configured, only local requests are let in.
+func (ws *WebServer) authenticate(r *http.Request) (principal, bool) {
+    admin := ws.config.Current().Admin
+
+    if auth := r.Header.Get("Authorization"); auth != "" {
+        token := strings.TrimPrefix(auth, "Bearer ")
+        if token == auth {
+            return principal{}, false
+        }
+        for _, t := range admin.Tokens {
+            if subtle.ConstantTimeCompare([]byte(token), []byte(t.Token.Value())) == 1 {
+                return principal{name: "token:" + t.Name, role: t.Role}, true
+            }
+        }
+        return principal{}, false
+    }
+
+    if c, err := r.Cookie(sessionCookie); err == nil {
+        if s := ws.sessions.get(c.Value); s != nil {
+            for _, a := range admin.Accounts {
+                if a.Username == s.username {
+                    return principal{name: a.Username, role: a.Role, session: s}, true
+                }
+            }
+            ws.sessions.delete(s.id)
+        }
+        return principal{}, false
+    }
+
+    if !admin.AuthEnabled() && isLoopback(r.RemoteAddr) {
+        return principal{name: "local", role: config.RoleAdmin}, true
+    }
+    return principal{}, false
+}
+
+// handleLogin checks a user name and password and starts a session.
+func (ws *WebServer) handleLogin(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    var req struct {
+        Username string `json:"username"`
+        Password string `json:"password"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    admin := ws.config.Current().Admin
+    hash := dummyHash
+    var account *config.AdminAccount
+    for i, a := range admin.Accounts {
+        if a.Username == req.Username {
+            account = &admin.Accounts[i]
+            hash = []byte(a.PasswordHash.Value())
+            break
+        }
+    }
+    if err := bcrypt.CompareHashAndPassword(hash, []byte(req.Password)); err != nil || account == nil {
+        http.Error(w, "invalid user name or password", http.StatusUnauthorized)
+        return
+    }
+
+    ttl := admin.SessionTimeout.Duration()
+    s := ws.sessions.create(account.Username, ttl)
+    http.SetCookie(w, &http.Cookie{
+        Name:     sessionCookie,
+        Value:    s.id,
+        Path:     "/",
+        Expires:  time.Now().Add(ttl),
+        HttpOnly: true,
+        Secure:   ws.tls,
+        SameSite: http.SameSiteStrictMode,
+    })
+    writeSession(w, principal{name: account.Username, role: account.Role, session: s})
+}
+
+// handleLogout ends the caller's session.
+func (ws *WebServer) handleLogout(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    if p := requestPrincipal(r); p.session != nil {
+        ws.sessions.delete(p.session.id)
+    }
+    http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1})
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSession tells the UI who is logged in and the CSRF token to send.
+func (ws *WebServer) handleSession(w http.ResponseWriter, r *http.Request) {
+    writeSession(w, requestPrincipal(r))
+}
+
+func writeSession(w http.ResponseWriter, p principal) {
+    resp := struct {
+        User      string `json:"user"`
+        Role      string `json:"role"`
+        CSRFToken string `json:"csrf_token,omitempty"`
+    }{User: p.name, Role: p.role}
+    if p.session != nil {
+        resp.CSRFToken = p.session.csrf
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(resp)
+}
+
+func safeMethod(method string) bool {
+    return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func isLoopback(remoteAddr string) bool {
+    ip := net.ParseIP(hostOnly(remoteAddr))
+    return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,38 @@
+package web
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "your_project/config"
+)
+
+func TestSIP008RequiresAdmin(t *testing.T) {
+    cfg := config.Default()
+    cfg.ServerAddress = "127.0.0.1:1080"
+    cfg.Password = config.NewSecret("secret")
+    cfg.Admin.Tokens = []config.AdminToken{
+        {Name: "ops", Token: config.NewSecret("admin-token-0123456789"), Role: config.RoleAdmin},
+        {Name: "monitoring", Token: config.NewSecret("read-only-token-0123456789"), Role: config.RoleReadOnly},
+    }
+    mgr, err := config.NewManager(cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    ws := NewWebServer(mgr, nil, 0)
+    handler := ws.authorize(requireAdmin(ws.handleSIP008))
+
+    for token, want := range map[string]int{
+        "read-only-token-0123456789": http.StatusForbidden,
+        "admin-token-0123456789":     http.StatusOK,
+    } {
+        req := httptest.NewRequest(http.MethodGet, "/api/sip008", nil)
+        req.Header.Set("Authorization", "Bearer "+token)
+        rec := httptest.NewRecorder()
+        handler(rec, req)
+        if rec.Code != want {
+            t.Errorf("GET /api/sip008 with %s: got status %d, want %d", token, rec.Code, want)
+        }
+    }
+}
@@ -0,0 +1,68 @@
+package web
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strings"
+    "time"
+
+    "your_project/config"
+    "your_project/network"
+)
+
+// handleBans lists the bans in force or, with POST, adds one. A ban is
+// {"address": "<ip or cidr>", "reason": "...", "duration": "24h"}; without
+// a duration it lasts until removed.
+func (ws *WebServer) handleBans(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet:
+        writeJSON(w, ws.server.Bans())
+    case http.MethodPost:
+        var req struct {
+            network.Ban
+            Duration config.Duration `json:"duration"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        b := req.Ban
+        if req.Duration < 0 {
+            http.Error(w, "duration must not be negative", http.StatusBadRequest)
+            return
+        }
+        if req.Duration > 0 {
+            until := time.Now().Add(req.Duration.Duration()).UTC()
+            b.Until = &until
+        }
+        if err := ws.server.Ban(b); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        ws.record(r, "ban.add "+b.Address, config.RedactedDiff(nil, b))
+        w.WriteHeader(http.StatusCreated)
+        writeJSON(w, b)
+    default:
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+// handleBan removes the ban on the address in the path with DELETE.
+func (ws *WebServer) handleBan(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodDelete {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    addr := strings.TrimPrefix(r.URL.Path, "/api/bans/")
+    if err := ws.server.Unban(addr); err != nil {
+        if errors.Is(err, network.ErrBanNotFound) {
+            http.NotFound(w, r)
+            return
+        }
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    ws.record(r, "ban.remove "+addr, nil)
+    w.WriteHeader(http.StatusNoContent)
+}
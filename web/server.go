@@ -1,34 +1,121 @@
 package web
 
 import (
+    "context"
+    "crypto/tls"
     "encoding/json"
+    "errors"
     "fmt"
     "html/template"
+    "log"
+    "net"
     "net/http"
+    "strings"
+    "your_project/audit"
     "your_project/config"
     "your_project/network"
+    "your_project/plugin"
+    "your_project/sip"
+    "your_project/transport"
+    "your_project/users"
 )
 
 type WebServer struct {
-    config *config.Config
-    server *network.Server
-    port   int
+    config   *config.Manager
+    server   *network.Server
+    port     int
+    http     *http.Server
+    tls      bool
+    sessions *sessionStore
+    audit    *audit.Log
+    users    *users.Manager
 }
 
-func NewWebServer(cfg *config.Config, server *network.Server, port int) *WebServer {
+// NewWebServer creates the admin server. It listens on admin.address, or
+// on port on all interfaces if that is not set. The address, TLS settings
+// and audit log path are read once at start; accounts and tokens are
+// checked against the current config on every request.
+func NewWebServer(cfg *config.Manager, server *network.Server, port int) *WebServer {
+    addr := cfg.Current().Admin.Address
+    if addr == "" {
+        addr = fmt.Sprintf(":%d", port)
+    }
     return &WebServer{
-        config: cfg,
-        server: server,
-        port:   port,
+        config:   cfg,
+        server:   server,
+        port:     port,
+        http:     &http.Server{Addr: addr},
+        sessions: newSessionStore(),
     }
 }
 
 func (ws *WebServer) Start() error {
-    http.HandleFunc("/", ws.handleIndex)
-    http.HandleFunc("/api/config", ws.handleConfig)
-    http.HandleFunc("/api/server/status", ws.handleServerStatus)
+    mux := http.NewServeMux()
+    mux.HandleFunc("/", ws.handleIndex)
+    mux.HandleFunc("/api/login", ws.handleLogin)
+    mux.HandleFunc("/api/logout", ws.authorize(ws.handleLogout))
+    mux.HandleFunc("/api/session", ws.authorize(ws.handleSession))
+    mux.HandleFunc("/api/config", ws.authorize(ws.handleConfig))
+    mux.HandleFunc("/api/audit", ws.authorize(ws.handleAudit))
+    mux.HandleFunc("/api/users", ws.authorize(ws.handleUsers))
+    mux.HandleFunc("/api/users/", ws.authorize(ws.handleUser))
+    mux.HandleFunc("/api/bans", ws.authorize(ws.handleBans))
+    mux.HandleFunc("/api/bans/", ws.authorize(ws.handleBan))
+    mux.HandleFunc("/api/server/status", ws.authorize(ws.handleServerStatus))
+    mux.HandleFunc("/api/plugins", ws.authorize(ws.handlePlugins))
+    mux.HandleFunc("/api/plugins/", ws.authorize(ws.handlePlugin))
+    mux.HandleFunc("/api/sip008", ws.authorize(requireAdmin(ws.handleSIP008)))
+    mux.HandleFunc("/api/sip008/", ws.authorize(requireAdmin(ws.handleSIP008)))
+    ws.http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        // Listeners may share this port for their WebSocket transport.
+        if transport.IsWebSocketUpgrade(r) && ws.server.ServeWebSocket(w, r) {
+            return
+        }
+        mux.ServeHTTP(w, r)
+    })
+
+    admin := ws.config.Current().Admin
+    if !admin.AuthEnabled() {
+        log.Printf("Web admin has no accounts or tokens; only local requests are allowed")
+    }
+    var tlsConfig *tls.Config
+    if admin.TLS.Enabled {
+        var err error
+        if tlsConfig, err = ws.server.TLSConfig(admin.TLS); err != nil {
+            return err
+        }
+        ws.tls = true
+    }
+
+    auditLog, err := audit.Open(admin.AuditLog)
+    if err != nil {
+        return fmt.Errorf("audit log: %w", err)
+    }
+    ws.audit = auditLog
 
-    return http.ListenAndServe(fmt.Sprintf(":%d", ws.port), nil)
+    // Shared with the proxy server so an upgrade hands it over as well.
+    ln, err := network.ListenTCP(ws.http.Addr)
+    if err != nil {
+        return err
+    }
+    ws.server.ShareListener(ws.http.Addr, ln)
+
+    var l net.Listener = ln
+    if tlsConfig != nil {
+        l = tls.NewListener(ln, tlsConfig)
+    }
+    if err := ws.http.Serve(l); err != http.ErrServerClosed {
+        return err
+    }
+    return nil
+}
+
+func (ws *WebServer) Shutdown(ctx context.Context) error {
+    err := ws.http.Shutdown(ctx)
+    if ws.audit != nil {
+        ws.audit.Close()
+    }
+    return err
 }
 
 func (ws *WebServer) handleIndex(w http.ResponseWriter, r *http.Request) {
@@ -42,23 +129,164 @@ func (ws *WebServer) handleIndex(w http.ResponseWriter, r *http.Request) {
 
 func (ws *WebServer) handleConfig(w http.ResponseWriter, r *http.Request) {
     if r.Method == "GET" {
-        json.NewEncoder(w).Encode(ws.config)
+        json.NewEncoder(w).Encode(ws.config.Current())
     } else if r.Method == "POST" {
-        var newConfig config.Config
-        if err := json.NewDecoder(r.Body).Decode(&newConfig); err != nil {
+        newConfig := ws.config.Current().Clone()
+        if err := json.NewDecoder(r.Body).Decode(newConfig); err != nil {
             http.Error(w, err.Error(), http.StatusBadRequest)
             return
         }
-        *ws.config = newConfig
-        w.WriteHeader(http.StatusOK)
+        diff, err := ws.update(r, "config.update", newConfig)
+        if err != nil {
+            var verr *config.ValidationError
+            if errors.As(err, &verr) {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        json.NewEncoder(w).Encode(diff)
     }
 }
 
 func (ws *WebServer) handleServerStatus(w http.ResponseWriter, r *http.Request) {
     status := struct {
-        Running bool `json:"running"`
+        Running   bool                       `json:"running"`
+        Listeners map[string]string          `json:"listeners"`
+        Traffic   map[string]network.Traffic `json:"traffic"`
     }{
-        Running: ws.server.IsRunning(),
+        Running:   ws.server.IsRunning(),
+        Listeners: ws.server.Listeners(),
+        Traffic:   ws.server.Traffic(),
     }
     json.NewEncoder(w).Encode(status)
 }
+
+type pluginStatus struct {
+    Name    string `json:"name"`
+    Enabled bool   `json:"enabled"`
+    Loaded  bool   `json:"loaded"`
+}
+
+// handlePlugins lists the registered plugins and the configured ones in
+// the order they run.
+func (ws *WebServer) handlePlugins(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    loaded := make(map[string]bool)
+    for _, name := range ws.server.Plugins() {
+        loaded[name] = true
+    }
+    plugins := []pluginStatus{}
+    for _, pc := range ws.config.Current().Plugins {
+        plugins = append(plugins, pluginStatus{Name: pc.Name, Enabled: pc.IsEnabled(), Loaded: loaded[pc.Name]})
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(struct {
+        Available []string       `json:"available"`
+        Plugins   []pluginStatus `json:"plugins"`
+    }{plugin.Registered(), plugins})
+}
+
+// handlePlugin turns the plugin named in the path on or off with a body of
+// {"enabled": true|false}. Enabling a registered plugin that is not in the
+// config yet appends it to the end of the chain.
+func (ws *WebServer) handlePlugin(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    name := strings.TrimPrefix(r.URL.Path, "/api/plugins/")
+
+    var req struct {
+        Enabled *bool `json:"enabled"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Enabled == nil {
+        http.Error(w, `body must be {"enabled": true|false}`, http.StatusBadRequest)
+        return
+    }
+
+    next := ws.config.Current().Clone()
+    if err := next.SetPluginEnabled(name, *req.Enabled); err != nil {
+        if !*req.Enabled || !isRegistered(name) {
+            http.NotFound(w, r)
+            return
+        }
+        next.Plugins = append(next.Plugins, config.PluginConfig{Name: name})
+    }
+
+    action := "plugin.disable"
+    if *req.Enabled {
+        action = "plugin.enable"
+    }
+    diff, err := ws.update(r, action+" "+name, next)
+    if err != nil {
+        var verr *config.ValidationError
+        if errors.As(err, &verr) {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    json.NewEncoder(w).Encode(diff)
+}
+
+func isRegistered(name string) bool {
+    for _, n := range plugin.Registered() {
+        if n == name {
+            return true
+        }
+    }
+    return false
+}
+
+// handleSIP008 serves a SIP008 online configuration document with every
+// shadowsocks listener at /api/sip008, or a single one at
+// /api/sip008/<listener>. The documents hold passwords, so only admins
+// may read them.
+func (ws *WebServer) handleSIP008(w http.ResponseWriter, r *http.Request) {
+    cfg := ws.config.Current()
+    host := cfg.PublicHost
+    if host == "" {
+        host = hostOnly(r.Host)
+    }
+
+    name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/sip008"), "/")
+    var doc *sip.Document
+    if name == "" {
+        var err error
+        doc, err = sip.FromConfig(cfg, host)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+    } else {
+        lc, ok := cfg.Inbound(name)
+        if !ok {
+            http.NotFound(w, r)
+            return
+        }
+        s, err := sip.FromListener(lc, host)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        doc = sip.NewDocument(s)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(doc)
+}
+
+func hostOnly(hostport string) string {
+    if host, _, err := net.SplitHostPort(hostport); err == nil {
+        return host
+    }
+    return hostport
+}
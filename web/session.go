@@ -0,0 +1,74 @@
+package web
+
+import (
+    "crypto/rand"
+    "encoding/base64"
+    "sync"
+    "time"
+)
+
+// session is a logged-in browser. The CSRF token must accompany every
+// request that changes something, so a cookie alone is not enough.
+type session struct {
+    id       string
+    username string
+    csrf     string
+    expires  time.Time
+}
+
+type sessionStore struct {
+    mu       sync.Mutex
+    sessions map[string]*session
+}
+
+func newSessionStore() *sessionStore {
+    return &sessionStore{sessions: make(map[string]*session)}
+}
+
+func (st *sessionStore) create(username string, ttl time.Duration) *session {
+    s := &session{
+        id:       randomToken(),
+        username: username,
+        csrf:     randomToken(),
+        expires:  time.Now().Add(ttl),
+    }
+    st.mu.Lock()
+    defer st.mu.Unlock()
+    now := time.Now()
+    for id, old := range st.sessions {
+        if now.After(old.expires) {
+            delete(st.sessions, id)
+        }
+    }
+    st.sessions[s.id] = s
+    return s
+}
+
+// get returns the live session with the given id, or nil.
+func (st *sessionStore) get(id string) *session {
+    st.mu.Lock()
+    defer st.mu.Unlock()
+    s, ok := st.sessions[id]
+    if !ok {
+        return nil
+    }
+    if time.Now().After(s.expires) {
+        delete(st.sessions, id)
+        return nil
+    }
+    return s
+}
+
+func (st *sessionStore) delete(id string) {
+    st.mu.Lock()
+    defer st.mu.Unlock()
+    delete(st.sessions, id)
+}
+
+func randomToken() string {
+    b := make([]byte, 32)
+    if _, err := rand.Read(b); err != nil {
+        panic(err)
+    }
+    return base64.RawURLEncoding.EncodeToString(b)
+}
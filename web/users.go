@@ -0,0 +1,180 @@
+package web
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strings"
+
+    "your_project/config"
+    "your_project/sip"
+    "your_project/users"
+)
+
+// SetUsers serves the users managed by m under /api/users. Call it before
+// Start.
+func (ws *WebServer) SetUsers(m *users.Manager) {
+    ws.users = m
+}
+
+// handleUsers lists the users or, with POST, creates one.
+func (ws *WebServer) handleUsers(w http.ResponseWriter, r *http.Request) {
+    if ws.users == nil {
+        http.NotFound(w, r)
+        return
+    }
+    switch r.Method {
+    case http.MethodGet:
+        list := []users.User{}
+        for _, u := range ws.users.List() {
+            list = append(list, u.Redacted())
+        }
+        writeJSON(w, list)
+    case http.MethodPost:
+        var u users.User
+        if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        u.UsedBytes = 0
+        if err := ws.users.Create(u); err != nil {
+            writeUserError(w, err)
+            return
+        }
+        ws.record(r, "user.create "+u.Name, users.Diff(nil, &u))
+        w.WriteHeader(http.StatusCreated)
+        writeJSON(w, u.Redacted())
+    default:
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+// handleUser serves /api/users/<name>: GET shows the user, PUT or PATCH
+// changes the fields given in the body, DELETE removes it. Disabling a
+// user is a PATCH of {"disabled": true}. /reset-usage and /sip008 below a
+// user reset its traffic counter and return its SIP008 document, which
+// only admins may read.
+func (ws *WebServer) handleUser(w http.ResponseWriter, r *http.Request) {
+    if ws.users == nil {
+        http.NotFound(w, r)
+        return
+    }
+    name := strings.TrimPrefix(r.URL.Path, "/api/users/")
+    name, sub := splitSubresource(name)
+
+    old, err := ws.users.Get(name)
+    if err != nil {
+        writeUserError(w, err)
+        return
+    }
+
+    switch {
+    case sub == "sip008" && r.Method == http.MethodGet:
+        if checkAdmin(w, r) {
+            ws.writeUserSIP008(w, r, old)
+        }
+    case sub == "reset-usage" && r.Method == http.MethodPost:
+        if err := ws.users.ResetUsage(name); err != nil {
+            writeUserError(w, err)
+            return
+        }
+        ws.record(r, "user.reset-usage "+name, nil)
+        w.WriteHeader(http.StatusNoContent)
+    case sub != "":
+        http.NotFound(w, r)
+    case r.Method == http.MethodGet:
+        writeJSON(w, old.Redacted())
+    case r.Method == http.MethodPut || r.Method == http.MethodPatch:
+        // Decoding over the redacted user leaves out fields as they are,
+        // and a password or key submitted back redacted stays unchanged.
+        next := old.Redacted()
+        if err := json.NewDecoder(r.Body).Decode(&next); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        if next.Name != name {
+            http.Error(w, "name cannot be changed", http.StatusBadRequest)
+            return
+        }
+        if next.Password == config.Redacted {
+            next.Password = old.Password
+        }
+        if next.Key == config.Redacted {
+            next.Key = old.Key
+        }
+        if err := ws.users.Update(next); err != nil {
+            writeUserError(w, err)
+            return
+        }
+        ws.record(r, "user.update "+name, users.Diff(&old, &next))
+        next, _ = ws.users.Get(name)
+        writeJSON(w, next.Redacted())
+    case r.Method == http.MethodDelete:
+        if err := ws.users.Delete(name); err != nil {
+            writeUserError(w, err)
+            return
+        }
+        ws.record(r, "user.delete "+name, users.Diff(&old, nil))
+        w.WriteHeader(http.StatusNoContent)
+    default:
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+// writeUserSIP008 returns a SIP008 document with every shadowsocks
+// listener that accepts managed users, set up with u's credentials.
+func (ws *WebServer) writeUserSIP008(w http.ResponseWriter, r *http.Request, u users.User) {
+    cfg := ws.config.Current()
+    host := cfg.PublicHost
+    if host == "" {
+        host = hostOnly(r.Host)
+    }
+
+    servers, err := u.Servers(cfg, host)
+    if errors.Is(err, users.ErrRawKey) {
+        http.Error(w, err.Error(), http.StatusConflict)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    doc := sip.NewDocument(servers...)
+    used := uint64(u.UsedBytes)
+    doc.BytesUsed = &used
+    if u.QuotaBytes > 0 {
+        remaining := uint64(0)
+        if u.QuotaBytes > u.UsedBytes {
+            remaining = uint64(u.QuotaBytes - u.UsedBytes)
+        }
+        doc.BytesRemaining = &remaining
+    }
+    writeJSON(w, doc)
+}
+
+func splitSubresource(p string) (string, string) {
+    if i := strings.Index(p, "/"); i >= 0 {
+        return p[:i], p[i+1:]
+    }
+    return p, ""
+}
+
+func writeUserError(w http.ResponseWriter, err error) {
+    var verr *config.ValidationError
+    switch {
+    case errors.As(err, &verr):
+        http.Error(w, err.Error(), http.StatusBadRequest)
+    case errors.Is(err, users.ErrNotFound):
+        http.Error(w, err.Error(), http.StatusNotFound)
+    case errors.Is(err, users.ErrExists):
+        http.Error(w, err.Error(), http.StatusConflict)
+    default:
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+    }
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,48 @@
+package web
+
+import (
+    "encoding/base64"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "your_project/config"
+    "your_project/store"
+    "your_project/users"
+)
+
+func TestUserSIP008(t *testing.T) {
+    cfg := config.Default()
+    cfg.Listeners = []config.ListenerConfig{{
+        Name: "ss", Address: "127.0.0.1:8388", Protocol: config.ProtocolShadowsocks,
+        Method: "aes-256-gcm", Users: true,
+    }}
+    mgr, err := config.NewManager(cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    m, err := users.New(store.NewMemory())
+    if err != nil {
+        t.Fatal(err)
+    }
+    m.Create(users.User{Name: "alice", Password: "alice-password"})
+    m.Create(users.User{Name: "carol", Key: base64.StdEncoding.EncodeToString(make([]byte, users.KeySize))})
+
+    ws := NewWebServer(mgr, nil, 0)
+    ws.SetUsers(m)
+    get := func(name string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest(http.MethodGet, "/api/users/"+name+"/sip008", nil)
+        req.RemoteAddr = "127.0.0.1:50000"
+        rec := httptest.NewRecorder()
+        ws.authorize(ws.handleUser)(rec, req)
+        return rec
+    }
+
+    if rec := get("alice"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "alice-password") {
+        t.Errorf("alice: got %d %s", rec.Code, rec.Body)
+    }
+    if rec := get("carol"); rec.Code != http.StatusConflict {
+        t.Errorf("carol: got %d, want %d for a key-only user", rec.Code, http.StatusConflict)
+    }
+}